@@ -81,12 +81,70 @@ type ServerConfig struct {
 		MinSnippetLines    int  `env:"SCANOSS_MIN_SNIPPET_LINES"`    // Minimum snippet lines to consider a snippet match
 		HonourFileExts     bool `env:"SCANOSS_HONOUR_FILE_EXTS"`     // Honour file extensions to filter snippet matches
 		// file contents
-		FileContentsLimit int64 `env:"SCANOSS_FILE_CONTENTS_LIMIT"` // Maximum file contents size in MB (default 50)
+		FileContentsLimit     int64  `env:"SCANOSS_FILE_CONTENTS_LIMIT"`      // Maximum file contents size in MB (default 50)
+		FileContentsCache     string `env:"SCANOSS_FILE_CONTENTS_CACHE"`      // Cache file contents by md5: none (disabled), memory (LRU) or disk
+		FileContentsCacheSize int64  `env:"SCANOSS_FILE_CONTENTS_CACHE_SIZE"` // Maximum size of the file contents cache in MB (0 = unlimited)
+		FileContentsCacheDir  string `env:"SCANOSS_FILE_CONTENTS_CACHE_DIR"`  // Directory to store the disk cache in (defaults to a folder in the system temp directory)
+		// file contents batches
+		FileContentsBatchSize    int   `env:"SCANOSS_FILE_CONTENTS_BATCH_SIZE"`    // Maximum number of md5s per file contents batch request (0 = unlimited)
+		FileContentsBatchWorkers int   `env:"SCANOSS_FILE_CONTENTS_BATCH_WORKERS"` // Number of file contents to retrieve in parallel for each batch request
+		FileContentsBatchLimit   int64 `env:"SCANOSS_FILE_CONTENTS_BATCH_LIMIT"`   // Maximum total size in MB of the file contents returned by a batch request (0 = unlimited)
+		// request limits
+		MaxUploadSize int64 `env:"SCANOSS_MAX_UPLOAD_SIZE"` // Maximum request upload size in MB (0 = unlimited)
+		MaxWfpFiles   int64 `env:"SCANOSS_MAX_WFP_FILES"`   // Maximum number of WFP (file=) entries per scan request (0 = unlimited)
+		MaxSbomSize   int64 `env:"SCANOSS_MAX_SBOM_SIZE"`   // Maximum SBOM (assets/attribution) size in MB (0 = unlimited)
+		StreamUploads bool  `env:"SCAN_STREAM_UPLOADS"`     // Scan multi-worker WFP uploads as they are received (form fields must come before the WFP file)
+		// compression
+		CompressResponses   bool  `env:"SCANOSS_COMPRESS_RESPONSES"`    // Compress responses (gzip or zstd) for clients which accept it
+		CompressMinSize     int   `env:"SCANOSS_COMPRESS_MIN_SIZE"`     // Minimum response size (in bytes) worth compressing
+		MaxDecompressedSize int64 `env:"SCANOSS_MAX_DECOMPRESSED_SIZE"` // Maximum size in MB of a compressed (Content-Encoding: gzip) upload once decompressed (0 = unlimited)
+		// rate limits & quotas
+		ScanRateLimit      int   `env:"SCAN_RATE_LIMIT"`                   // Scan requests per minute allowed per client (API key or IP) (0 = unlimited)
+		ScanRateBurst      int   `env:"SCAN_RATE_BURST"`                   // Scan requests a client can send at once (defaults to the rate limit)
+		ScanDailyQuota     int64 `env:"SCAN_DAILY_QUOTA"`                  // Files a client can scan per day (UTC) (0 = unlimited)
+		ContentsRateLimit  int   `env:"SCANOSS_FILE_CONTENTS_RATE_LIMIT"`  // File contents requests per minute allowed per client (0 = unlimited)
+		ContentsRateBurst  int   `env:"SCANOSS_FILE_CONTENTS_RATE_BURST"`  // File contents requests a client can send at once (defaults to the rate limit)
+		ContentsDailyQuota int64 `env:"SCANOSS_FILE_CONTENTS_DAILY_QUOTA"` // Files a client can retrieve the contents of per day (UTC) (0 = unlimited)
+		// engine concurrency
+		EngineMaxProcesses int `env:"SCAN_ENGINE_MAX_PROCESSES"` // Maximum number of engine processes running at once across all requests (0 = unlimited)
+		EngineQueueSize    int `env:"SCAN_ENGINE_QUEUE_SIZE"`    // Maximum number of engine requests waiting for a free process
+		EngineQueueTimeout int `env:"SCAN_ENGINE_QUEUE_TIMEOUT"` // Maximum time (in seconds) to wait for a free engine process
+		// persistent engine workers
+		EnginePoolSize   int      `env:"SCAN_ENGINE_POOL_SIZE"`   // Number of persistent engine workers to scan with (0 = start a process per scan)
+		EnginePoolArgs   []string `env:"SCAN_ENGINE_POOL_ARGS"`   // Arguments to start the engine in persistent (stdin/stdout framing) mode
+		EnginePoolSocket string   `env:"SCAN_ENGINE_POOL_SOCKET"` // Unix socket of a persistent engine to connect to (instead of starting worker processes)
+		// engine input
+		EngineInput string `env:"SCAN_ENGINE_INPUT"` // How to pass WFP/SBOM data to the engine: file (temporary files), stdin or pipe (/dev/fd/N)
+		// engine retries
+		ScanRetries      int `env:"SCAN_ENGINE_RETRIES"`        // Number of times to retry a failed scan (failed multi-file chunks are split and retried file by file)
+		ScanRetryBackoff int `env:"SCAN_ENGINE_RETRY_BACKOFF"`  // Initial delay (in milliseconds) before retrying a failed scan (doubling each time)
+		ScanRetryMaxTime int `env:"SCAN_ENGINE_RETRY_MAX_TIME"` // Maximum time (in seconds) to spend retrying a failed chunk (0 = no limit)
+		// partial failures
+		PartialFailureStatus int `env:"SCAN_PARTIAL_FAILURE_STATUS"` // HTTP status to return when some files fail to scan (200, 207 or 500)
+		// file deduplication
+		DedupeFiles bool `env:"SCAN_DEDUPE_FILES"` // Only scan one copy of identical files (same md5, fingerprints & extension if honoured) in a request, sharing its result with the others
+		// scan result cache
+		ResultCache     string `env:"SCAN_RESULT_CACHE"`      // Cache the scan result of each file: none (disabled), memory (LRU) or disk
+		ResultCacheSize int    `env:"SCAN_RESULT_CACHE_SIZE"` // Maximum number of file results to keep in the cache (memory or disk)
+		ResultCacheDir  string `env:"SCAN_RESULT_CACHE_DIR"`  // Directory to store the disk cache in (defaults to a folder in the system temp directory)
+		// asynchronous scan jobs
+		JobWorkers   int `env:"SCAN_JOB_WORKERS"`    // Number of asynchronous scan jobs to process concurrently
+		JobQueueSize int `env:"SCAN_JOB_QUEUE_SIZE"` // Maximum number of asynchronous scan jobs waiting to be processed
+		JobRetention int `env:"SCAN_JOB_RETENTION"`  // Number of minutes to keep finished scan job results
+		// scan job callbacks
+		CallbackAllowedHosts []string `env:"SCAN_CALLBACK_ALLOWED_HOSTS"` // List of hosts allowed to receive scan job callbacks (none by default)
+		CallbackSecret       string   `env:"SCAN_CALLBACK_SECRET"`        // Shared secret used to sign callback payloads (HMAC-SHA256). Callbacks are refused without it
+		CallbackRetries      int      `env:"SCAN_CALLBACK_RETRIES"`       // Number of times to retry a failed callback
+		CallbackBackoff      int      `env:"SCAN_CALLBACK_BACKOFF"`       // Initial delay (in milliseconds) before retrying a failed callback
+		CallbackTimeout      int      `env:"SCAN_CALLBACK_TIMEOUT"`       // Timeout (in seconds) for each callback attempt
 	}
 	TLS struct {
-		CertFile string `env:"SCAN_TLS_CERT"`   // TLS Certificate
-		KeyFile  string `env:"SCAN_TLS_KEY"`    // Private TLS Key
-		Password string `env:"SCAN_TLS_PASSWD"` // TLS Decryption Password
+		CertFile       string `env:"SCAN_TLS_CERT"`            // TLS Certificate
+		KeyFile        string `env:"SCAN_TLS_KEY"`             // Private TLS Key
+		Password       string `env:"SCAN_TLS_PASSWD"`          // TLS Decryption Password
+		ReloadInterval int    `env:"SCAN_TLS_RELOAD_INTERVAL"` // Seconds between checking the cert/key files for changes (0 = only reload on SIGHUP)
+		ClientCAFile   string `env:"SCAN_TLS_CLIENT_CA"`       // CA bundle to verify client certificates (mTLS) with
+		ClientAuth     string `env:"SCAN_TLS_CLIENT_AUTH"`     // Client certificate mode: none, request, require, verify_if_given or verify
 	}
 	Filtering struct {
 		AllowListFile  string `env:"SCAN_ALLOW_LIST"`       // Allow list file for incoming connections
@@ -94,6 +152,22 @@ type ServerConfig struct {
 		BlockByDefault bool   `env:"SCAN_BLOCK_BY_DEFAULT"` // Block request by default if they are not in the allow list
 		TrustProxy     bool   `env:"SCAN_TRUST_PROXY"`      // Trust the interim proxy or not (causes the source IP to be validated instead of the proxy)
 	}
+	Auth struct {
+		Enabled  bool     `env:"SCAN_AUTH_ENABLED"`  // Require an API key or token (X-Session or Authorization: Bearer header) for API requests
+		KeysFile string   `env:"SCAN_API_KEYS_FILE"` // File of API keys, one "<id> <sha256 of key> <scope>..." entry per line
+		Keys     []string `env:"SCAN_API_KEYS"`      // List of API keys, in the same format as the keys file
+		// JWT bearer tokens
+		JWTEnabled     bool   `env:"SCAN_JWT_ENABLED"`      // Accept JWT bearer tokens (Authorization: Bearer header), verified against the JWKS
+		JWKSFile       string `env:"SCAN_JWT_JWKS_FILE"`    // JWKS (JSON Web Key Set) file to verify token signatures with
+		JWKSURL        string `env:"SCAN_JWT_JWKS_URL"`     // URL to load the JWKS from (instead of a file)
+		JWKSRefresh    int    `env:"SCAN_JWT_JWKS_REFRESH"` // Minutes between reloading the JWKS (0 = only load it at startup)
+		JWTIssuer      string `env:"SCAN_JWT_ISSUER"`       // Issuer (iss) tokens must have been issued by
+		JWTAudience    string `env:"SCAN_JWT_AUDIENCE"`     // Audience (aud) tokens must have been issued for
+		JWTScopeClaim  string `env:"SCAN_JWT_SCOPE_CLAIM"`  // Claim holding the token's scopes (space separated string or list)
+		JWTScopePrefix string `env:"SCAN_JWT_SCOPE_PREFIX"` // Prefix of the scopes in the claim (i.e. scanoss: for scanoss:scan)
+		// Client certificates (mTLS)
+		ClientCerts []string `env:"SCAN_AUTH_CLIENT_CERTS"` // Client certificate identities (subject CN or SAN) and their scopes: "<identity> <scope>..."
+	}
 }
 
 // NewServerConfig loads all config options and return a struct for use.
@@ -143,7 +217,59 @@ func setServerConfigDefaults(cfg *ServerConfig) {
 	cfg.Scanning.HonourFileExts = true
 	cfg.Scanning.AllowFlagsOverride = false // Disallow clients overriding the default flags if it's set server-side
 	// file contents
-	cfg.Scanning.FileContentsLimit = 50 // Default 50 MB
+	cfg.Scanning.FileContentsLimit = 50      // Default 50 MB
+	cfg.Scanning.FileContentsCache = "none"  // Default to not caching file contents
+	cfg.Scanning.FileContentsCacheSize = 256 // Default 256 MB
+	// file contents batches
+	cfg.Scanning.FileContentsBatchSize = 100  // Default to 100 md5s per batch request
+	cfg.Scanning.FileContentsBatchWorkers = 4 // Default to retrieving 4 files at a time
+	cfg.Scanning.FileContentsBatchLimit = 100 // Default to returning up to 100 MB of file contents per batch request
+	// request limits
+	cfg.Scanning.MaxUploadSize = 100   // Default 100 MB
+	cfg.Scanning.MaxWfpFiles = 0       // Default to no limit on the number of files per scan request
+	cfg.Scanning.MaxSbomSize = 10      // Default 10 MB
+	cfg.Scanning.StreamUploads = false // Default to reading the whole upload (streaming needs the form fields sent before the WFP file)
+	// compression
+	cfg.Scanning.CompressResponses = false // Default to uncompressed responses (existing clients may not handle a Content-Encoding)
+	cfg.Scanning.CompressMinSize = 1024    // Default to leaving responses under 1 KB uncompressed
+	cfg.Scanning.MaxDecompressedSize = 100 // Default 100 MB (the same as the upload limit, so compression cannot get round it)
+	// rate limits & quotas
+	cfg.Scanning.ScanRateLimit = 0      // Default to no limit on the rate of scan requests
+	cfg.Scanning.ScanDailyQuota = 0     // Default to no daily limit on the files scanned
+	cfg.Scanning.ContentsRateLimit = 0  // Default to no limit on the rate of file contents requests
+	cfg.Scanning.ContentsDailyQuota = 0 // Default to no daily limit on the file contents retrieved
+	// engine concurrency
+	cfg.Scanning.EngineMaxProcesses = 0  // Default to no server-wide limit on engine processes
+	cfg.Scanning.EngineQueueSize = 100   // Default to 100 engine requests waiting for a free process
+	cfg.Scanning.EngineQueueTimeout = 30 // Default to waiting up to 30 seconds for a free engine process
+	// persistent engine workers
+	cfg.Scanning.EnginePoolSize = 0 // Default to starting an engine process per scan
+	// engine input
+	cfg.Scanning.EngineInput = "file" // Default to passing data to the engine in temporary files
+	// engine retries
+	cfg.Scanning.ScanRetries = 1        // Default to retrying each file from a failed chunk once
+	cfg.Scanning.ScanRetryBackoff = 500 // Default to waiting half a second before the first retry
+	cfg.Scanning.ScanRetryMaxTime = 120 // Default to retrying a failed chunk for up to 2 minutes
+	// partial failures
+	cfg.Scanning.PartialFailureStatus = 200 // Default to returning the partial results (with failure headers)
+	// file deduplication
+	cfg.Scanning.DedupeFiles = false // Default to scanning every file (even if identical)
+	// scan result cache
+	cfg.Scanning.ResultCache = "none"     // Default to scanning every file
+	cfg.Scanning.ResultCacheSize = 100000 // Default to caching up to 100k file results
+	// asynchronous scan jobs
+	cfg.Scanning.JobWorkers = 2     // Default to two scan jobs running at once
+	cfg.Scanning.JobQueueSize = 100 // Default to 100 scan jobs waiting in the queue
+	cfg.Scanning.JobRetention = 60  // Default to keeping job results for an hour
+	// scan job callbacks
+	cfg.Scanning.CallbackRetries = 5    // Default to five retries (after the initial attempt)
+	cfg.Scanning.CallbackBackoff = 1000 // Default to waiting one second before the first retry (doubling each time)
+	cfg.Scanning.CallbackTimeout = 10   // Default to 10 seconds per callback attempt
+	cfg.Auth.Enabled = false            // Default to serving requests without an API key
+	cfg.Auth.JWKSRefresh = 60           // Default to reloading the JWKS every hour (to pick up rotated keys)
+	cfg.Auth.JWTScopeClaim = "scope"    // Default to the OAuth 2.0 scope claim
+	cfg.TLS.ClientAuth = "none"         // Default to not asking for client certificates
+	cfg.TLS.ReloadInterval = 60         // Default to checking the cert/key files for changes every minute
 }
 
 // LoadFile loads the specified file and returns its contents in a string array.
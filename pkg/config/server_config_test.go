@@ -39,6 +39,15 @@ func TestServerConfig(t *testing.T) {
 	if cfg.App.Addr != appAddr {
 		t.Errorf("App Addr '%v' doesn't match expected: %v", cfg.App.Addr, appAddr)
 	}
+	if cfg.Scanning.MaxDecompressedSize > cfg.Scanning.MaxUploadSize {
+		t.Errorf("Default max decompressed size (%v MB) is larger than the max upload size (%v MB)", cfg.Scanning.MaxDecompressedSize, cfg.Scanning.MaxUploadSize)
+	}
+	if cfg.Scanning.DedupeFiles {
+		t.Errorf("File deduplication should be disabled by default")
+	}
+	if cfg.Scanning.CompressResponses {
+		t.Errorf("Response compression should be disabled by default")
+	}
 	fmt.Printf("Server Config1: %+v\n", cfg)
 	err = os.Unsetenv("APP_ADDR")
 	if err != nil {
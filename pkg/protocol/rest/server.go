@@ -49,6 +49,15 @@ import (
 	"scanoss.com/go-api/pkg/service"
 )
 
+// clientAuthModes maps the client certificate (mTLS) modes to the TLS client authentication they require.
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"none":            tls.NoClientCert,
+	"request":         tls.RequestClientCert,          // Ask for a certificate, but don't require or verify it
+	"require":         tls.RequireAnyClientCert,       // Require a certificate, but don't verify it
+	"verify_if_given": tls.VerifyClientCertIfGiven,    // Verify the certificate, if one is sent
+	"verify":          tls.RequireAndVerifyClientCert, // Require a valid certificate
+}
+
 // RunServer runs REST service to publish.
 func RunServer(config *myconfig.ServerConfig, version string) error {
 	// Check if TLS should be enabled or not
@@ -60,6 +69,9 @@ func RunServer(config *myconfig.ServerConfig, version string) error {
 	if err != nil {
 		return err
 	}
+	if err = checkEnginePool(config); err != nil {
+		return err
+	}
 	if config.Telemetry.Enabled {
 		oltpShutdown, err2 := initProviders(config, version, config.Telemetry.ExtraMetrics)
 		if err2 != nil {
@@ -68,11 +80,17 @@ func RunServer(config *myconfig.ServerConfig, version string) error {
 		defer oltpShutdown()
 	}
 	apiService := service.NewAPIService(config)
+	if err = apiService.SetupAuth(); err != nil {
+		return err
+	}
 	if err2 := apiService.TestEngine(); err2 != nil {
 		zlog.S.Warnf("Scanning engine test failed. Scan requests are likely to fail.")
 		zlog.S.Warnf("Please make sure that %v is accessible", config.Scanning.ScanBinary)
 	}
 	apiService.SetupKBDetailsCron()
+	defer apiService.Close() // Only shut down the engine workers once the scan jobs have stopped
+	stopScanJobs := apiService.SetupScanJobs()
+	defer stopScanJobs()
 	// Set up the endpoint routing
 	router := mux.NewRouter().StrictSlash(true)
 	router.HandleFunc("/", service.WelcomeMsg).Methods(http.MethodGet)
@@ -89,6 +107,8 @@ func RunServer(config *myconfig.ServerConfig, version string) error {
 	router.HandleFunc("/health-check", service.HeadResponse).Methods(http.MethodHead)
 	router.HandleFunc("/api/metrics/{type}", service.MetricsHandler).Methods(http.MethodGet)
 	router.HandleFunc("/metrics/{type}", service.MetricsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/api/file_contents/batch", apiService.FileContentsBatch).Methods(http.MethodPost)
+	router.HandleFunc("/file_contents/batch", apiService.FileContentsBatch).Methods(http.MethodPost)
 	router.HandleFunc("/api/file_contents/{md5}", apiService.FileContents).Methods(http.MethodGet)
 	router.HandleFunc("/file_contents/{md5}", apiService.FileContents).Methods(http.MethodGet)
 	router.HandleFunc("/api/kb/details", apiService.KBDetails).Methods(http.MethodGet)
@@ -97,12 +117,36 @@ func RunServer(config *myconfig.ServerConfig, version string) error {
 	router.HandleFunc("/license/obligations/{license}", apiService.LicenseDetails).Methods(http.MethodGet)
 	router.HandleFunc("/api/scan/direct", apiService.ScanDirect).Methods(http.MethodPost)
 	router.HandleFunc("/scan/direct", apiService.ScanDirect).Methods(http.MethodPost)
+	router.HandleFunc("/api/scan/jobs", apiService.SubmitScanJob).Methods(http.MethodPost)
+	router.HandleFunc("/scan/jobs", apiService.SubmitScanJob).Methods(http.MethodPost)
+	router.HandleFunc("/api/scan/jobs/{id}", apiService.ScanJobStatus).Methods(http.MethodGet)
+	router.HandleFunc("/scan/jobs/{id}", apiService.ScanJobStatus).Methods(http.MethodGet)
+	router.HandleFunc("/api/scan/jobs/{id}/result", apiService.ScanJobResult).Methods(http.MethodGet)
+	router.HandleFunc("/scan/jobs/{id}/result", apiService.ScanJobResult).Methods(http.MethodGet)
 	router.HandleFunc("/api/sbom/attribution", apiService.SbomAttribution).Methods(http.MethodPost)
 	router.HandleFunc("/sbom/attribution", apiService.SbomAttribution).Methods(http.MethodPost)
+	router.NotFoundHandler = http.HandlerFunc(service.NotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(service.MethodNotAllowed)
 	// Setup Open Telemetry (OTEL)
 	if config.Telemetry.Enabled {
 		router.Use(otelmux.Middleware("scanoss-api"))
 	}
+	// Identify callers by their verified client certificate (mTLS)
+	if startTLS && len(config.TLS.ClientCAFile) > 0 {
+		router.Use(apiService.ClientCertificate)
+	}
+	// Require an API key (with the route's scope) for API requests
+	if config.Auth.Enabled {
+		router.Use(apiService.Authenticate)
+	}
+	// Limit the rate of scan & file contents requests per client (API key or IP)
+	if config.Scanning.ScanRateLimit > 0 || config.Scanning.ContentsRateLimit > 0 {
+		router.Use(apiService.RateLimit)
+	}
+	// Compress responses for clients which accept it (gzip or zstd)
+	if config.Scanning.CompressResponses {
+		router.Use(apiService.CompressResponses)
+	}
 	srv := &http.Server{
 		Handler:           router,
 		Addr:              fmt.Sprintf("%s:%s", config.App.Addr, config.App.Port),
@@ -115,12 +159,18 @@ func RunServer(config *myconfig.ServerConfig, version string) error {
 		})
 		srv.Handler = handler // assign the filtered handler
 	}
+	if startTLS {
+		stopReload, err2 := loadTLSConfig(config, srv)
+		if err2 != nil {
+			return err2
+		}
+		defer stopReload()
+	}
 	// Open TCP port (in the background) and listen for requests
 	go func() {
 		var httpErr error
 		if startTLS {
 			zlog.S.Infof("starting REST server with TLS on %v ...", srv.Addr)
-			loadTLSConfig(config, srv)
 			httpErr = srv.ListenAndServeTLS("", "")
 		} else {
 			zlog.S.Infof("starting REST server on %v ...", srv.Addr)
@@ -146,18 +196,12 @@ func RunServer(config *myconfig.ServerConfig, version string) error {
 }
 
 // loadTLSConfig loads the TLS config into memory (decrypting if required) and updates the Server config.
-func loadTLSConfig(config *myconfig.ServerConfig, srv *http.Server) {
-	pemBlocks := loadCertFile(config)
-	pkey := loadPrivateKey(config)
-
-	var combinedPem []byte
-	for _, pemBlock := range pemBlocks {
-		combinedPem = append(combinedPem, pem.EncodeToMemory(pemBlock)...)
-	}
-
-	c, err := tls.X509KeyPair(combinedPem, pkey)
+// The key pair is reloaded whenever the cert/key files change, or the process receives a SIGHUP (see certReloader).
+// The returned function stops watching for changes.
+func loadTLSConfig(config *myconfig.ServerConfig, srv *http.Server) (func(), error) {
+	certs, err := newCertReloader(config)
 	if err != nil {
-		zlog.S.Panicf("Failed to load TLS key pair (%v - %v): %v", config.TLS.KeyFile, config.TLS.CertFile, err)
+		return nil, err
 	}
 	cfg := &tls.Config{
 		MinVersion:       tls.VersionTLS12,
@@ -165,17 +209,38 @@ func loadTLSConfig(config *myconfig.ServerConfig, srv *http.Server) {
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 		},
-		Certificates: []tls.Certificate{c},
+		GetCertificate: certs.GetCertificate,
+		ClientAuth:     clientAuthModes[strings.ToLower(config.TLS.ClientAuth)],
+	}
+	if len(config.TLS.ClientCAFile) > 0 {
+		if cfg.ClientCAs, err = loadClientCAs(config); err != nil {
+			return nil, err
+		}
 	}
 	srv.TLSConfig = cfg
 	srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	return certs.watch(time.Duration(config.TLS.ReloadInterval) * time.Second), nil
+}
+
+// loadClientCAs loads the CA bundle to verify client certificates with.
+func loadClientCAs(config *myconfig.ServerConfig) (*x509.CertPool, error) {
+	b, err := os.ReadFile(config.TLS.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client CA file - %v: %w", config.TLS.ClientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in client CA file: %v", config.TLS.ClientCAFile)
+	}
+	zlog.S.Infof("Client certificates (%v) verified against: %v", config.TLS.ClientAuth, config.TLS.ClientCAFile)
+	return pool, nil
 }
 
 // loadCertFile load the certificate file into memory to use for hosting a TLS endpoint.
-func loadCertFile(config *myconfig.ServerConfig) []*pem.Block {
+func loadCertFile(config *myconfig.ServerConfig) ([]*pem.Block, error) {
 	b, err := os.ReadFile(config.TLS.CertFile)
 	if err != nil {
-		zlog.S.Panicf("Failed to load Cert file - %v: %v", config.TLS.CertFile, err)
+		return nil, fmt.Errorf("failed to load Cert file - %v: %w", config.TLS.CertFile, err)
 	}
 	var pemBlocks []*pem.Block
 	var v *pem.Block
@@ -190,16 +255,16 @@ func loadCertFile(config *myconfig.ServerConfig) []*pem.Block {
 			zlog.S.Warnf("Unknown certificate type (%v): %v", config.TLS.CertFile, v.Type)
 		}
 	}
-	return pemBlocks
+	return pemBlocks, nil
 }
 
 // loadPrivateKey loads the private key from file and attempt to decrypt it (if it's encrypted).
-func loadPrivateKey(config *myconfig.ServerConfig) []byte {
+func loadPrivateKey(config *myconfig.ServerConfig) ([]byte, error) {
 	var v *pem.Block
 	var pkey []byte
 	b, err := os.ReadFile(config.TLS.KeyFile)
 	if err != nil {
-		zlog.S.Panicf("Failed to load Key file - %v: %v", config.TLS.KeyFile, err)
+		return nil, fmt.Errorf("failed to load Key file - %v: %w", config.TLS.KeyFile, err)
 	}
 	for {
 		v, b = pem.Decode(b)
@@ -211,12 +276,12 @@ func loadPrivateKey(config *myconfig.ServerConfig) []byte {
 			// pvt, err := openssl.LoadPrivateKeyFromPEMWithPassword(encryptedPEM, passPhrase)
 			if x509.IsEncryptedPEMBlock(v) {
 				if len(config.TLS.Password) == 0 {
-					zlog.S.Panicf("Need to configure TLS Password to decrypt encrypted Key file: %v", config.TLS.KeyFile)
+					return nil, fmt.Errorf("need to configure TLS Password to decrypt encrypted Key file: %v", config.TLS.KeyFile)
 				}
 				zlog.S.Infof("Decrypting key...")
 				pkey, err = x509.DecryptPEMBlock(v, []byte(config.TLS.Password))
 				if err != nil {
-					zlog.S.Panicf("Failed to decrypt Key File (%v): %v", config.TLS.KeyFile, err)
+					return nil, fmt.Errorf("failed to decrypt Key File (%v): %w", config.TLS.KeyFile, err)
 				}
 				pkey = pem.EncodeToMemory(&pem.Block{
 					Type:  v.Type,
@@ -229,7 +294,7 @@ func loadPrivateKey(config *myconfig.ServerConfig) []byte {
 			zlog.S.Warnf("Unexpected certificate type (%v): %v", config.TLS.KeyFile, v.Type)
 		}
 	}
-	return pkey
+	return pkey, nil
 }
 
 // checkFile validates if the given file exists or not.
@@ -274,9 +339,51 @@ func checkTLS(config *myconfig.ServerConfig) (bool, error) {
 		}
 		startTLS = true
 	}
+	if err := checkClientAuth(config, startTLS); err != nil {
+		return false, err
+	}
 	return startTLS, nil
 }
 
+// checkEnginePool tests if the persistent engine worker config is valid.
+// There is no default command line for a persistent engine worker, so it must be set unless connecting to a socket.
+func checkEnginePool(config *myconfig.ServerConfig) error {
+	sc := config.Scanning
+	if sc.EnginePoolSize > 0 && len(sc.EnginePoolSocket) == 0 && len(sc.EnginePoolArgs) == 0 {
+		return fmt.Errorf("engine pool size set (%v), but no SCAN_ENGINE_POOL_ARGS or SCAN_ENGINE_POOL_SOCKET supplied", sc.EnginePoolSize)
+	}
+	return nil
+}
+
+// checkClientAuth tests if the client certificate (mTLS) config is valid.
+func checkClientAuth(config *myconfig.ServerConfig, startTLS bool) error {
+	mode, found := clientAuthModes[strings.ToLower(config.TLS.ClientAuth)]
+	if !found && len(config.TLS.ClientAuth) > 0 {
+		return fmt.Errorf("unknown client certificate mode (none, request, require, verify_if_given or verify): %v", config.TLS.ClientAuth)
+	}
+	if mode == tls.NoClientCert {
+		return nil
+	}
+	if !startTLS {
+		return fmt.Errorf("client certificates (%v) need TLS to be enabled", config.TLS.ClientAuth)
+	}
+	verify := mode == tls.VerifyClientCertIfGiven || mode == tls.RequireAndVerifyClientCert
+	if !verify {
+		zlog.S.Warnf("Client certificates are not verified in %v mode, so will not identify callers", config.TLS.ClientAuth)
+	}
+	if len(config.TLS.ClientCAFile) == 0 {
+		if verify {
+			return fmt.Errorf("client certificate mode %v needs a client CA file", config.TLS.ClientAuth)
+		}
+		return nil
+	}
+	cf, err := checkFile(config.TLS.ClientCAFile)
+	if err != nil || !cf {
+		return fmt.Errorf("client CA file not accessible: %v", config.TLS.ClientCAFile)
+	}
+	return nil
+}
+
 // loadFiltering loads the IP filtering options if available.
 func loadFiltering(config *myconfig.ServerConfig) ([]string, []string, error) {
 	var allowedIPs []string
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rest
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	myconfig "scanoss.com/go-api/pkg/config"
+)
+
+// certReloader serves the TLS key pair, reloading it (without a restart) when the cert/key files change or the
+// process receives a SIGHUP. The key pair is swapped atomically, and kept if the new files cannot be loaded.
+type certReloader struct {
+	config  *myconfig.ServerConfig
+	cert    atomic.Pointer[tls.Certificate]
+	mu      sync.Mutex
+	modTime time.Time // Latest modification time of the cert/key files when last checked
+}
+
+// newCertReloader loads the initial key pair.
+func newCertReloader(config *myconfig.ServerConfig) (*certReloader, error) {
+	cr := &certReloader{config: config, modTime: filesModTime(config.TLS.CertFile, config.TLS.KeyFile)}
+	cert, err := loadKeyPair(config)
+	if err != nil {
+		return nil, err
+	}
+	cr.cert.Store(cert)
+	return cr, nil
+}
+
+// loadKeyPair loads the cert and key files (decrypting the key if required).
+func loadKeyPair(config *myconfig.ServerConfig) (*tls.Certificate, error) {
+	pemBlocks, err := loadCertFile(config)
+	if err != nil {
+		return nil, err
+	}
+	pkey, err := loadPrivateKey(config)
+	if err != nil {
+		return nil, err
+	}
+	var combinedPem []byte
+	for _, pemBlock := range pemBlocks {
+		combinedPem = append(combinedPem, pem.EncodeToMemory(pemBlock)...)
+	}
+	c, err := tls.X509KeyPair(combinedPem, pkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS key pair (%v - %v): %w", config.TLS.KeyFile, config.TLS.CertFile, err)
+	}
+	return &c, nil
+}
+
+// filesModTime returns the latest modification time of the given files (ignoring any which cannot be read).
+func filesModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// GetCertificate returns the current key pair for each TLS handshake.
+func (cr *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load(), nil
+}
+
+// reload loads the key pair again, logging (and keeping the current key pair) if it fails.
+func (cr *certReloader) reload(reason string) {
+	cert, err := loadKeyPair(cr.config)
+	if err != nil {
+		zlog.S.Errorf("Failed to reload the TLS certificate (%v). Keeping the current one: %v", reason, err)
+		return
+	}
+	cr.cert.Store(cert)
+	if cert.Leaf != nil {
+		zlog.S.Infof("Reloaded the TLS certificate (%v): %v, expiring %v", reason, cert.Leaf.Subject, cert.Leaf.NotAfter)
+	} else {
+		zlog.S.Infof("Reloaded the TLS certificate (%v)", reason)
+	}
+}
+
+// checkFiles reloads the key pair if the cert or key file has been modified since last checked.
+func (cr *certReloader) checkFiles() {
+	modTime := filesModTime(cr.config.TLS.CertFile, cr.config.TLS.KeyFile)
+	cr.mu.Lock()
+	changed := modTime.After(cr.modTime)
+	if changed {
+		cr.modTime = modTime
+	}
+	cr.mu.Unlock()
+	if changed {
+		cr.reload("files changed")
+	}
+}
+
+// watch checks the cert/key files for changes every interval (if set), and reloads the key pair on SIGHUP,
+// until the returned function is called.
+func (cr *certReloader) watch(interval time.Duration) func() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	stopTicker := func() {}
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		tick, stopTicker = ticker.C, ticker.Stop
+		zlog.S.Debugf("Checking TLS cert/key files for changes every %v", interval)
+	}
+	done := make(chan struct{})
+	go cr.run(hup, tick, done)
+	return func() {
+		signal.Stop(hup)
+		stopTicker()
+		close(done)
+	}
+}
+
+// run reloads the key pair on each SIGHUP and checks the files on each tick, until done.
+func (cr *certReloader) run(hup <-chan os.Signal, tick <-chan time.Time, done <-chan struct{}) {
+	for {
+		select {
+		case <-hup:
+			cr.reload("SIGHUP")
+		case <-tick:
+			cr.checkFiles()
+		case <-done:
+			return
+		}
+	}
+}
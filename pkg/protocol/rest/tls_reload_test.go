@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package rest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	myconfig "scanoss.com/go-api/pkg/config"
+)
+
+// setupReloadConfig returns a server config using the cert/key files in a temporary directory.
+func setupReloadConfig(t *testing.T) *myconfig.ServerConfig {
+	t.Helper()
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	t.Cleanup(zlog.SyncZap)
+	dir := t.TempDir()
+	config := &myconfig.ServerConfig{}
+	config.TLS.CertFile = filepath.Join(dir, "cert.pem")
+	config.TLS.KeyFile = filepath.Join(dir, "key.pem")
+	return config
+}
+
+// writeKeyPair writes a newly generated self-signed cert (with the given common name) and key to the configured files.
+func writeKeyPair(t *testing.T, config *myconfig.ServerConfig, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	if err = os.WriteFile(config.TLS.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(config.TLS.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setModTime sets the modification time of the cert and key files.
+func setModTime(t *testing.T, config *myconfig.ServerConfig, modTime time.Time) {
+	t.Helper()
+	for _, file := range []string{config.TLS.CertFile, config.TLS.KeyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedName returns the common name of the certificate currently served.
+func servedName(t *testing.T, cr *certReloader) string {
+	t.Helper()
+	cert, err := cr.GetCertificate(nil)
+	if err != nil || cert == nil {
+		t.Fatalf("no certificate served: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("invalid certificate served: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	config := setupReloadConfig(t)
+	_, err := newCertReloader(config)
+	assert.Error(t, err) // No files yet
+	writeKeyPair(t, config, "first")
+	cr, err := newCertReloader(config)
+	if err != nil {
+		t.Fatalf("an error was not expected loading the key pair: %v", err)
+	}
+	assert.Equal(t, "first", servedName(t, cr))
+
+	writeKeyPair(t, config, "second")
+	cr.reload("test")
+	assert.Equal(t, "second", servedName(t, cr))
+
+	// Bad files are logged, and the current certificate kept
+	if err = os.WriteFile(config.TLS.CertFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cr.reload("test")
+	assert.Equal(t, "second", servedName(t, cr))
+	if err = os.Remove(config.TLS.KeyFile); err != nil {
+		t.Fatal(err)
+	}
+	cr.reload("test")
+	assert.Equal(t, "second", servedName(t, cr))
+}
+
+func TestCertReloaderCheckFiles(t *testing.T) {
+	config := setupReloadConfig(t)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeKeyPair(t, config, "first")
+	setModTime(t, config, start)
+	cr, err := newCertReloader(config)
+	if err != nil {
+		t.Fatalf("an error was not expected loading the key pair: %v", err)
+	}
+
+	// Files are only reloaded once their modification time changes
+	writeKeyPair(t, config, "second")
+	setModTime(t, config, start)
+	cr.checkFiles()
+	assert.Equal(t, "first", servedName(t, cr))
+	setModTime(t, config, start.Add(time.Minute))
+	cr.checkFiles()
+	assert.Equal(t, "second", servedName(t, cr))
+	writeKeyPair(t, config, "third")
+	setModTime(t, config, start.Add(time.Minute))
+	cr.checkFiles()
+	assert.Equal(t, "second", servedName(t, cr))
+
+	// A bad update is not retried until the files change again
+	if err = os.WriteFile(config.TLS.CertFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setModTime(t, config, start.Add(2*time.Minute))
+	cr.checkFiles()
+	assert.Equal(t, "second", servedName(t, cr))
+	writeKeyPair(t, config, "fourth")
+	setModTime(t, config, start.Add(3*time.Minute))
+	cr.checkFiles()
+	assert.Equal(t, "fourth", servedName(t, cr))
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	config := setupReloadConfig(t)
+	// Keep catching SIGHUP, so that it cannot stop the tests once the watcher has stopped
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	start := time.Now().Add(time.Hour).Truncate(time.Second) // Files being written (now) are older, so not picked up part way through
+	writeKeyPair(t, config, "first")
+	setModTime(t, config, start)
+	cr, err := newCertReloader(config)
+	if err != nil {
+		t.Fatalf("an error was not expected loading the key pair: %v", err)
+	}
+	stop := cr.watch(10 * time.Millisecond)
+
+	// Reloaded on SIGHUP (without the files changing)
+	writeKeyPair(t, config, "second")
+	setModTime(t, config, start)
+	if err = syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	<-hup
+	assert.Eventually(t, func() bool { return servedName(t, cr) == "second" }, 5*time.Second, 10*time.Millisecond)
+
+	// Reloaded when the files change
+	writeKeyPair(t, config, "third")
+	setModTime(t, config, start.Add(time.Minute))
+	assert.Eventually(t, func() bool { return servedName(t, cr) == "third" }, 5*time.Second, 10*time.Millisecond)
+
+	// Nothing is reloaded once stopped
+	stop()
+	writeKeyPair(t, config, "fourth")
+	setModTime(t, config, start.Add(2*time.Minute))
+	if err = syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	<-hup
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "third", servedName(t, cr))
+}
+
+func TestCertReloaderRun(t *testing.T) {
+	config := setupReloadConfig(t)
+	writeKeyPair(t, config, "first")
+	cr, err := newCertReloader(config)
+	if err != nil {
+		t.Fatalf("an error was not expected loading the key pair: %v", err)
+	}
+	hup := make(chan os.Signal)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		cr.run(hup, nil, done)
+		close(finished)
+	}()
+	writeKeyPair(t, config, "second")
+	hup <- syscall.SIGHUP
+	assert.Eventually(t, func() bool { return servedName(t, cr) == "second" }, 5*time.Second, 10*time.Millisecond)
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reloader did not stop")
+	}
+}
@@ -19,14 +19,10 @@ package service
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"io"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"os/exec"
-	"strings"
-	"time"
 )
 
 // SbomAttribution handles retrieving the attribution notices for the given SBOM.
@@ -43,12 +39,19 @@ func (s APIService) SbomAttribution(w http.ResponseWriter, r *http.Request) {
 	}
 	zs := sugaredLogger(logContext) // Setup logger with context
 	logRequestDetails(r, zs)
+	s.limitRequestBody(w, r)
+	if !s.decompressRequestBody(w, r, zs) {
+		return
+	}
 	var contents []byte
 	var err error
 	formFiles := []string{"file", "filename"}
 	for _, fName := range formFiles { // Check for the SBOM contents in 'file' and 'filename'
 		var file multipart.File
 		file, _, err = r.FormFile(fName)
+		if isBodyLimitError(err) {
+			break // No point trying alternative names once the body is too large
+		}
 		if err != nil {
 			zs.Infof("Cannot retrieve SBOM Form File: %v - %v. Trying an alternative name...", fName, err)
 			continue
@@ -61,58 +64,30 @@ func (s APIService) SbomAttribution(w http.ResponseWriter, r *http.Request) {
 			zs.Infof("Cannot retrieve SBOM Form File (%v) contents: %v. Trying an alternative name...", file, err)
 		}
 	}
+	if s.writeBodyLimitExceeded(w, err, zs) {
+		return
+	}
 	if err != nil {
 		zs.Errorf("Failed to retrieve SBOM file contents (using %v): %v", formFiles, err)
-		http.Error(w, "ERROR receiving SBOM file contents", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "failed to receive SBOM file contents", nil, zs)
 		return
 	}
 	contentsTrimmed := bytes.TrimSpace(contents)
 	if len(contentsTrimmed) == 0 {
 		zs.Errorf("No SBOM contents to attribute (%v - %v)", len(contents), contents)
-		http.Error(w, "ERROR no SBOM contents supplied", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no SBOM contents supplied", nil, zs)
 		return
 	}
-	// Check if we have an SBOM (and type) supplied
-	tempFile, err := os.CreateTemp(s.config.Scanning.WfpLoc, "sbom-attr*.json")
-	if err != nil {
-		zs.Errorf("Failed to create temporary SBOM file: %v", err)
-		http.Error(w, "ERROR engine attribution failed", http.StatusInternalServerError)
+	if !s.checkSbomSize(w, len(contentsTrimmed), zs) {
 		return
 	}
-	_, err = tempFile.Write(contentsTrimmed)
-	if err != nil {
-		zs.Errorf("Failed to write to temporary SBOM file: %v - %v", tempFile.Name(), err)
-		closeFile(tempFile, zs)
-		removeFile(tempFile, zs)
-		http.Error(w, "ERROR engine attribution failed", http.StatusInternalServerError)
+	output, err := s.engine.Attribution(context.Background(), contentsTrimmed, zs)
+	if errors.Is(err, errEngineBusy) {
+		s.writeEngineBusy(w, zs)
 		return
 	}
-	closeFile(tempFile, zs)
-	if s.config.Scanning.TmpFileDelete {
-		defer removeFile(tempFile, zs)
-	}
-	sbomFilename := tempFile.Name() // Save the SBOM filename
-
-	zs.Debugf("Retrieving attribution for %v", sbomFilename)
-	var args []string
-	if s.config.Scanning.ScanDebug {
-		args = append(args, "-d")
-	}
-
-	// Set Database name if declared
-	if len(s.config.Scanning.ScanKbName) > 0 {
-		args = append(args, fmt.Sprintf("-n%s", s.config.Scanning.ScanKbName))
-	}
-	args = append(args, "-a", sbomFilename)
-	zs.Debugf("Executing %v %v", s.config.Scanning.ScanBinary, strings.Join(args, " "))
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second) // put a timeout on the scan execution
-	defer cancel()
-	//nolint:gosec
-	output, err := exec.CommandContext(ctx, s.config.Scanning.ScanBinary, args...).Output()
 	if err != nil {
-		zs.Errorf("Attribution command (%v %v) failed: %v", s.config.Scanning.ScanBinary, args, err)
-		zs.Errorf("Command output: %s", bytes.TrimSpace(output))
-		http.Error(w, "ERROR engine attribution failed", http.StatusInternalServerError)
+		writeError(w, codeEngineError, "engine attribution failed", nil, zs)
 		return
 	}
 	if s.config.App.Trace {
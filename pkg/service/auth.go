@@ -0,0 +1,351 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	myconfig "scanoss.com/go-api/pkg/config"
+)
+
+// Scopes an API key, token or client certificate can be granted. Each API route requires one of them.
+const (
+	scopeScan        = "scan"        // Scanning (direct and asynchronous jobs)
+	scopeContents    = "contents"    // File contents
+	scopeLicense     = "license"     // License obligations
+	scopeAttribution = "attribution" // SBOM attribution
+	scopeMetrics     = "metrics"     // Service metrics
+	scopeAll         = "*"           // All of the above
+)
+
+// knownScopes are the scopes which can be granted to a caller.
+var knownScopes = map[string]bool{scopeScan: true, scopeContents: true, scopeLicense: true, scopeAttribution: true, scopeMetrics: true, scopeAll: true}
+
+// routeScopes maps the first element of an API path (without /api) to the scope required to access it.
+// Routes not listed (i.e. welcome and health checks) are open to all.
+var routeScopes = map[string]string{
+	"scan":          scopeScan,
+	"file_contents": scopeContents,
+	"license":       scopeLicense,
+	"sbom":          scopeAttribution,
+	"metrics":       scopeMetrics,
+	"kb":            "", // Any authenticated caller
+}
+
+// Authentication failures reported back to clients.
+var (
+	errNoCredentials = errors.New("API key or token required (X-Session or Authorization: Bearer header)")
+	errInvalidAPIKey = errors.New("invalid API key")
+	errInvalidToken  = errors.New("invalid token")
+	errExpiredToken  = errors.New("token has expired")
+)
+
+// scopeSet is the set of scopes granted to a caller.
+type scopeSet map[string]bool
+
+// allows reports if the given scope has been granted (directly or through *).
+func (ss scopeSet) allows(scope string) bool {
+	return len(scope) == 0 || ss[scope] || ss[scopeAll]
+}
+
+// callerIdentity is the authenticated identity of the caller making a request.
+type callerIdentity struct {
+	keyID       string // ID of the API key used (if any)
+	subject     string // Subject (sub) of the JWT used (if any)
+	certificate string // Identity of the client certificate used (if any)
+	scopes      scopeSet
+}
+
+// String describes the caller for logging.
+func (c callerIdentity) String() string {
+	switch {
+	case len(c.keyID) > 0:
+		return "API key " + c.keyID
+	case len(c.subject) > 0:
+		return "subject " + c.subject
+	}
+	return "client certificate " + c.certificate
+}
+
+// callerID identifies the authenticated caller of a request from its context: the API key ID, token subject or
+// client certificate (prefixed by its type). It is empty if the request was not authenticated.
+func callerID(ctx context.Context) string {
+	if keyID, ok := ctx.Value(KeyIDContextKey{}).(string); ok && len(keyID) > 0 {
+		return "key:" + keyID
+	}
+	if subject, ok := ctx.Value(SubjectContextKey{}).(string); ok && len(subject) > 0 {
+		return "sub:" + subject
+	}
+	if cert, ok := ctx.Value(ClientCertContextKey{}).(string); ok && len(cert) > 0 {
+		return "cert:" + cert
+	}
+	return ""
+}
+
+// apiKey is a configured API key. Only the SHA-256 hash of the key itself is kept.
+type apiKey struct {
+	id     string
+	hash   []byte
+	scopes scopeSet
+}
+
+// apiKeyStore holds the API keys (and client certificate identities) accepted by the service.
+type apiKeyStore struct {
+	mu    sync.RWMutex
+	keys  []apiKey
+	certs map[string]scopeSet // Scopes granted to each client certificate identity
+}
+
+// set replaces the accepted API keys.
+func (ks *apiKeyStore) set(keys []apiKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+}
+
+// setCerts replaces the accepted client certificate identities.
+func (ks *apiKeyStore) setCerts(certs map[string]scopeSet) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.certs = certs
+}
+
+// lookupCert returns the scopes granted to the given client certificate identity.
+func (ks *apiKeyStore) lookupCert(name string) (scopeSet, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	scopes, found := ks.certs[name]
+	return scopes, found
+}
+
+// lookup returns the API key matching the one supplied by a client. Every key is compared (in constant time),
+// so that the time taken does not reveal anything about the configured keys.
+func (ks *apiKeyStore) lookup(key string) (apiKey, bool) {
+	hash := sha256.Sum256([]byte(key))
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var match apiKey
+	found := false
+	for _, k := range ks.keys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash) == 1 && !found {
+			match, found = k, true
+		}
+	}
+	return match, found
+}
+
+// parseAPIKey parses an API key entry of the form: <id> <sha256 of key> <scope> [<scope>...].
+func parseAPIKey(entry string) (apiKey, error) {
+	fields := strings.Fields(entry)
+	if len(fields) < 3 {
+		return apiKey{}, fmt.Errorf("expected '<id> <sha256 of key> <scope>...', got %d field(s)", len(fields))
+	}
+	hash, err := hex.DecodeString(fields[1])
+	if err != nil || len(hash) != sha256.Size {
+		return apiKey{}, fmt.Errorf("key %v hash is not a SHA-256 hex digest", fields[0])
+	}
+	scopes, err := parseScopes(fields[0], fields[2:])
+	if err != nil {
+		return apiKey{}, err
+	}
+	return apiKey{id: fields[0], hash: hash, scopes: scopes}, nil
+}
+
+// parseScopes parses the scopes granted to the given API key or client certificate.
+func parseScopes(id string, values []string) (scopeSet, error) {
+	scopes := make(scopeSet, len(values))
+	for _, scope := range values {
+		scope = strings.ToLower(scope)
+		if !knownScopes[scope] {
+			return nil, fmt.Errorf("%v has unknown scope: %v", id, scope)
+		}
+		scopes[scope] = true
+	}
+	return scopes, nil
+}
+
+// loadAPIKeys loads the API keys from the given file (if any) and list of entries.
+func loadAPIKeys(keysFile string, entries []string) ([]apiKey, error) {
+	if len(keysFile) > 0 {
+		fileEntries, err := myconfig.LoadFile(keysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load API keys: %w", err)
+		}
+		entries = append(fileEntries, entries...)
+	}
+	ids := make(map[string]bool)
+	var keys []apiKey
+	for _, entry := range entries {
+		if len(strings.TrimSpace(entry)) == 0 {
+			continue
+		}
+		key, err := parseAPIKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API key entry: %w", err)
+		}
+		if ids[key.id] {
+			return nil, fmt.Errorf("duplicate API key id: %v", key.id)
+		}
+		ids[key.id] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SetupAuth loads the API keys (from SCAN_API_KEYS_FILE and SCAN_API_KEYS), client certificate identities
+// (SCAN_AUTH_CLIENT_CERTS) and the JWKS to verify tokens with (if enabled), when authentication is enabled.
+func (s APIService) SetupAuth() error {
+	if !s.config.Auth.Enabled {
+		zlog.L.Debug("API key authentication not enabled.")
+		return nil
+	}
+	keys, err := loadAPIKeys(s.config.Auth.KeysFile, s.config.Auth.Keys)
+	if err != nil {
+		return err
+	}
+	certs, err := loadClientCerts(s.config.Auth.ClientCerts)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 && len(certs) == 0 && s.tokens == nil {
+		return fmt.Errorf("authentication is enabled, but no API keys, client certificates (or JWT validation) are configured")
+	}
+	s.apiKeys.set(keys)
+	s.apiKeys.setCerts(certs)
+	zlog.S.Infof("Loaded %v API key(s) and %v client certificate identities", len(keys), len(certs))
+	if s.tokens != nil {
+		return s.setupJWT()
+	}
+	return nil
+}
+
+// requestCredentials returns the API key or token supplied in the X-Session or Authorization (Bearer) header.
+func requestCredentials(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get(SessionKey)); len(key) > 0 {
+		return key
+	}
+	scheme, token, found := strings.Cut(strings.TrimSpace(r.Header.Get(AuthorizationKey)), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// authenticate identifies the caller from the API key or JWT (if enabled) supplied with the request.
+// Without either, a verified client certificate (mTLS) granted scopes identifies the caller.
+func (s APIService) authenticate(r *http.Request) (callerIdentity, error) {
+	credentials := requestCredentials(r)
+	if len(credentials) == 0 {
+		if caller, found := s.certificateCaller(r); found {
+			return caller, nil
+		}
+		return callerIdentity{}, errNoCredentials
+	}
+	if s.tokens != nil && strings.Count(credentials, ".") == 2 { // Looks like a JWT (header.payload.signature)
+		caller, err := s.tokens.verify(credentials)
+		if err != nil {
+			zlog.S.Debugf("Token validation failed: %v", err)
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				return callerIdentity{}, errExpiredToken
+			}
+			return callerIdentity{}, errInvalidToken
+		}
+		return caller, nil
+	}
+	key, found := s.apiKeys.lookup(credentials)
+	if !found {
+		return callerIdentity{}, errInvalidAPIKey
+	}
+	return callerIdentity{keyID: key.id, scopes: key.scopes}, nil
+}
+
+// routeName returns the first element of an API path (without /api), i.e. scan for /api/scan/direct.
+func routeName(path string) string {
+	if trimmed, found := strings.CutPrefix(path, "/api/"); found {
+		path = "/" + trimmed
+	}
+	first, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return first
+}
+
+// requiredScope returns the scope needed to access the given path, and if the path needs authenticating at all.
+func requiredScope(path string) (string, bool) {
+	scope, found := routeScopes[routeName(path)]
+	return scope, found
+}
+
+// Authenticate is middleware requiring a valid API key, JWT or client certificate, with the scope needed by the route,
+// on every API request. Only the welcome message and health checks (whatever the method) are left open. The caller's key ID
+// (or token subject) is added to the request context (for logging) and to the request span.
+func (s APIService) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, protected := requiredScope(r.URL.Path)
+		if !protected {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sourceIP, forwardedIP := getClientIP(r)
+		caller, err := s.authenticate(r)
+		if err != nil {
+			w.Header().Set(ResponseIDKey, getReqID(r))
+			challenge := `Bearer realm="scanoss-api"`
+			if !errors.Is(err, errNoCredentials) {
+				challenge += `, error="invalid_token"`
+			}
+			w.Header().Set(AuthenticateKey, challenge)
+			zlog.S.Warnf("%v request from %v (%v) rejected: %v", r.URL.Path, sourceIP, forwardedIP, err)
+			writeError(w, codeUnauthorized, err.Error(), nil, zlog.S)
+			return
+		}
+		if !caller.scopes.allows(scope) {
+			w.Header().Set(ResponseIDKey, getReqID(r))
+			zlog.S.Warnf("%v request from %v (%v) with %v missing scope: %v", r.URL.Path, sourceIP, forwardedIP, caller, scope)
+			writeError(w, codeInsufficientScope, fmt.Sprintf("caller does not have the %v scope", scope), scopeDetails{Scope: scope}, zlog.S)
+			return
+		}
+		ctx := r.Context()
+		span := oteltrace.SpanFromContext(ctx)
+		if len(caller.keyID) > 0 {
+			ctx = context.WithValue(ctx, KeyIDContextKey{}, caller.keyID)
+			if s.config.Telemetry.Enabled {
+				span.SetAttributes(attribute.String("scanoss.key_id", caller.keyID))
+			}
+		}
+		if len(caller.subject) > 0 {
+			ctx = context.WithValue(ctx, SubjectContextKey{}, caller.subject)
+			if s.config.Telemetry.Enabled {
+				span.SetAttributes(attribute.String("enduser.id", caller.subject))
+			}
+		}
+		if len(caller.certificate) > 0 { // The name granted the scopes
+			ctx = context.WithValue(ctx, ClientCertContextKey{}, caller.certificate)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
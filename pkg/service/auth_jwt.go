@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/golang-jwt/jwt/v5"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	myconfig "scanoss.com/go-api/pkg/config"
+)
+
+// jwtAlgorithms are the (asymmetric) signing algorithms accepted for tokens.
+var jwtAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jwksMaxSize is the largest JWKS accepted from a JWKS URL.
+const jwksMaxSize = 1024 * 1024
+
+// jsonWebKey is a single (public) key from a JWKS (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the RSA, EC or Ed25519 (OKP) public key.
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve: %v", k.Crv)
+		}
+		x, errX := decode(k.X)
+		y, errY := decode(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("invalid EC coordinates")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		x, err := decode(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported or invalid OKP key (%v)", k.Crv)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %v", k.Kty)
+}
+
+// parseJWKS parses a JWKS, returning its signing keys by key ID (kid). Keys for other uses (i.e. encryption) are ignored.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no signing keys")
+	}
+	return keys, nil
+}
+
+// loadJWKS loads the JWKS from the given file, or URL if there is no file.
+func loadJWKS(file, url string) (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if len(file) > 0 {
+		data, err = os.ReadFile(file)
+	} else {
+		data, err = fetchJWKS(url)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+// fetchJWKS downloads the JWKS from the given URL.
+func fetchJWKS(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	//nolint:gosec // The JWKS URL comes from the server configuration
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS URL responded with status: %v", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, jwksMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > jwksMaxSize {
+		return nil, fmt.Errorf("JWKS too large (over %v bytes)", jwksMaxSize)
+	}
+	return data, nil
+}
+
+// jwtVerifier verifies JWT bearer tokens against the keys of a JWKS, mapping their scopes to the API scopes.
+type jwtVerifier struct {
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey // By key ID
+	parser      *jwt.Parser
+	scopeClaim  string
+	scopePrefix string
+	file        string
+	url         string
+	scheduler   *gocron.Scheduler // Reloads the JWKS (if scheduled)
+}
+
+// newJWTVerifier creates a verifier for the tokens configured (SCAN_JWT_*). The JWKS is loaded by reload.
+func newJWTVerifier(config *myconfig.ServerConfig) *jwtVerifier {
+	auth := config.Auth
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtAlgorithms), jwt.WithIssuer(auth.JWTIssuer), jwt.WithAudience(auth.JWTAudience),
+		jwt.WithExpirationRequired(), jwt.WithLeeway(30*time.Second))
+	return &jwtVerifier{parser: parser, scopeClaim: auth.JWTScopeClaim, scopePrefix: auth.JWTScopePrefix, file: auth.JWKSFile, url: auth.JWKSURL}
+}
+
+// reload (re)loads the JWKS. The current keys are kept if it fails.
+func (v *jwtVerifier) reload() error {
+	keys, err := loadJWKS(v.file, v.url)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys = keys
+	return nil
+}
+
+// stop stops any scheduled reloading of the JWKS.
+func (v *jwtVerifier) stop() {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.scheduler != nil {
+		v.scheduler.Stop()
+		v.scheduler = nil
+	}
+}
+
+// keyFunc returns the JWKS key matching the token's key ID (kid). Tokens without a kid need a JWKS with a single key.
+func (v *jwtVerifier) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if len(kid) == 0 && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, nil
+		}
+	}
+	key, found := v.keys[kid]
+	if !found {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	return key, nil
+}
+
+// verify checks the token's signature, issuer, audience and expiry, returning the caller's subject and scopes.
+func (v *jwtVerifier) verify(tokenString string) (callerIdentity, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(tokenString, claims, v.keyFunc); err != nil {
+		return callerIdentity{}, err
+	}
+	subject, err := claims.GetSubject()
+	if err != nil || len(subject) == 0 {
+		return callerIdentity{}, errors.New("token has no subject")
+	}
+	return callerIdentity{subject: subject, scopes: v.scopes(claims)}, nil
+}
+
+// scopes returns the API scopes granted by the token's scope claim, which can be a space separated string (i.e. scope)
+// or a list (i.e. scp). Scopes without the configured prefix, or unknown to the API, are ignored.
+func (v *jwtVerifier) scopes(claims jwt.MapClaims) scopeSet {
+	var values []string
+	switch claim := claims[v.scopeClaim].(type) {
+	case string:
+		values = strings.Fields(claim)
+	case []any:
+		for _, value := range claim {
+			if str, ok := value.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+	scopes := make(scopeSet, len(values))
+	for _, value := range values {
+		scope, found := strings.CutPrefix(value, v.scopePrefix)
+		if scope = strings.ToLower(scope); found && knownScopes[scope] {
+			scopes[scope] = true
+		}
+	}
+	return scopes
+}
+
+// setupJWT loads the JWKS to verify tokens with, and schedules reloading it (SCAN_JWT_JWKS_REFRESH) to pick up rotated keys.
+// The reloading is stopped when the service is closed.
+func (s APIService) setupJWT() error {
+	auth := s.config.Auth
+	if len(auth.JWTIssuer) == 0 || len(auth.JWTAudience) == 0 {
+		return errors.New("JWT validation is enabled, but the token issuer (SCAN_JWT_ISSUER) or audience (SCAN_JWT_AUDIENCE) is not set")
+	}
+	if len(auth.JWKSFile) == 0 && len(auth.JWKSURL) == 0 {
+		return errors.New("JWT validation is enabled, but no JWKS file (SCAN_JWT_JWKS_FILE) or URL (SCAN_JWT_JWKS_URL) is set")
+	}
+	if err := s.tokens.reload(); err != nil {
+		return err
+	}
+	zlog.S.Infof("Validating JWT bearer tokens from %v for %v", auth.JWTIssuer, auth.JWTAudience)
+	if auth.JWKSRefresh <= 0 {
+		return nil
+	}
+	scheduler := gocron.NewScheduler(time.UTC)
+	_, err := scheduler.Every(auth.JWKSRefresh).Minutes().WaitForSchedule().Do(func() {
+		if err := s.tokens.reload(); err != nil {
+			zlog.S.Warnf("Failed to reload the JWKS. Keeping the current keys: %v", err)
+		}
+	})
+	if err != nil {
+		zlog.S.Warnf("Problem setting up JWKS reload cron: %v", err)
+		return nil
+	}
+	scheduler.StartAsync()
+	s.tokens.mu.Lock()
+	s.tokens.scheduler = scheduler
+	s.tokens.mu.Unlock()
+	return nil
+}
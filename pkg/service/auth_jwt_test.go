@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	myconfig "scanoss.com/go-api/pkg/config"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "scanoss-api"
+)
+
+// testJWK returns the JWKS entry for the given public key.
+func testJWK(t *testing.T, kid string, key any) map[string]string {
+	t.Helper()
+	encode := base64.RawURLEncoding.EncodeToString
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": encode(pub.N.Bytes()), "e": encode(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		point, err := pub.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (len(point) - 1) / 2
+		return map[string]string{"kty": "EC", "kid": kid, "crv": pub.Curve.Params().Name, "x": encode(point[1 : 1+size]), "y": encode(point[1+size:])}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": encode(pub)}
+	}
+	t.Fatalf("unsupported key type: %T", key)
+	return nil
+}
+
+// testJWKS returns a JWKS document containing the given keys.
+func testJWKS(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// signToken returns a token signed with the given key, with the default issuer, audience and expiry unless overridden.
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	allClaims := jwt.MapClaims{"iss": testIssuer, "aud": testAudience, "sub": "user@example.com", "exp": time.Now().Add(time.Hour).Unix()}
+	for name, value := range claims {
+		if value == nil {
+			delete(allClaims, name)
+		} else {
+			allClaims[name] = value
+		}
+	}
+	token := jwt.NewWithClaims(method, allClaims)
+	if len(kid) > 0 {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// jwtConfig returns a config validating tokens against the given JWKS file.
+func jwtConfig(t *testing.T, jwksFile string) *myconfig.ServerConfig {
+	t.Helper()
+	myConfig := setupConfig(t)
+	myConfig.Auth.Enabled = true
+	myConfig.Auth.JWTEnabled = true
+	myConfig.Auth.JWKSFile = jwksFile
+	myConfig.Auth.JWTIssuer = testIssuer
+	myConfig.Auth.JWTAudience = testAudience
+	myConfig.Auth.JWKSRefresh = 0
+	return myConfig
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encKey := testJWK(t, "enc", &rsaKey.PublicKey)
+	encKey["use"] = "enc"
+	keys, err := parseJWKS(testJWKS(t, testJWK(t, "rsa", &rsaKey.PublicKey), testJWK(t, "ec", &ecKey.PublicKey), testJWK(t, "ed", edKey), encKey))
+	assert.NoError(t, err)
+	assert.Len(t, keys, 3) // The encryption key is ignored
+	assert.True(t, rsaKey.PublicKey.Equal(keys["rsa"]))
+	assert.True(t, ecKey.PublicKey.Equal(keys["ec"]))
+	assert.True(t, edKey.Equal(keys["ed"]))
+
+	badCurve := testJWK(t, "ec", &ecKey.PublicKey)
+	badCurve["crv"] = "P-192"
+	shortX := testJWK(t, "ec", &ecKey.PublicKey)
+	shortX["x"] = shortX["x"][4:]
+	for name, data := range map[string][]byte{
+		"not json":    []byte("keys"),
+		"no keys":     testJWKS(t),
+		"only enc":    testJWKS(t, encKey),
+		"bad curve":   testJWKS(t, badCurve),
+		"short x":     testJWKS(t, shortX),
+		"unknown kty": testJWKS(t, map[string]string{"kty": "oct", "k": "c2VjcmV0"}),
+	} {
+		_, err = parseJWKS(data)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestJWTScopes(t *testing.T) {
+	verifier := &jwtVerifier{scopeClaim: "scope"}
+	assert.Equal(t, scopeSet{scopeScan: true, scopeContents: true}, verifier.scopes(jwt.MapClaims{"scope": "openid scan Contents"}))
+	assert.Empty(t, verifier.scopes(jwt.MapClaims{"scp": "scan"}))
+	verifier = &jwtVerifier{scopeClaim: "scp", scopePrefix: "scanoss:"}
+	assert.Equal(t, scopeSet{scopeLicense: true, scopeAll: true},
+		verifier.scopes(jwt.MapClaims{"scp": []any{"scanoss:license", "scanoss:*", "scan", 42}}))
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(jwksFile, testJWKS(t, testJWK(t, "rsa", &rsaKey.PublicKey), testJWK(t, "ec", &ecKey.PublicKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	myConfig := jwtConfig(t, jwksFile)
+	myConfig.Auth.Keys = []string{"ci " + keyHash("ci-secret") + " scan"}
+	apiService := NewAPIService(myConfig)
+	if err = apiService.SetupAuth(); err != nil {
+		t.Fatal(err)
+	}
+	var subject, keyID string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		subject, _ = r.Context().Value(SubjectContextKey{}).(string)
+		keyID, _ = r.Context().Value(KeyIDContextKey{}).(string)
+		w.WriteHeader(http.StatusOK)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/scan/direct", handler).Methods(http.MethodPost)
+	router.HandleFunc("/license/obligations/{license}", handler).Methods(http.MethodGet)
+	router.Use(apiService.Authenticate)
+
+	scan := jwt.MapClaims{"scope": "openid scan"}
+	tests := []struct {
+		name    string
+		path    string
+		token   string
+		status  int
+		code    string
+		message string
+		subject string
+	}{
+		{name: "rsa", path: "/scan/direct", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, scan), status: http.StatusOK, subject: "user@example.com"},
+		{name: "ec", path: "/scan/direct", token: signToken(t, jwt.SigningMethodES256, "ec", ecKey, scan), status: http.StatusOK, subject: "user@example.com"},
+		{name: "api key", path: "/scan/direct", token: "ci-secret", status: http.StatusOK},
+		{name: "missing scope", path: "/license/obligations/MIT", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, scan),
+			status: http.StatusForbidden, code: codeInsufficientScope},
+		{name: "expired", path: "/scan/direct", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey,
+			jwt.MapClaims{"scope": "scan", "exp": time.Now().Add(-time.Hour).Unix()}), status: http.StatusUnauthorized, code: codeUnauthorized,
+			message: errExpiredToken.Error()},
+		{name: "no expiry", path: "/scan/direct", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"scope": "scan", "exp": nil}),
+			status: http.StatusUnauthorized, code: codeUnauthorized, message: errInvalidToken.Error()},
+		{name: "wrong issuer", path: "/scan/direct", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey,
+			jwt.MapClaims{"scope": "scan", "iss": "https://other.example.com"}), status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "wrong audience", path: "/scan/direct", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey,
+			jwt.MapClaims{"scope": "scan", "aud": []string{"other"}}), status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "no subject", path: "/scan/direct", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"scope": "scan", "sub": nil}),
+			status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "unknown kid", path: "/scan/direct", token: signToken(t, jwt.SigningMethodRS256, "other", rsaKey, scan),
+			status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "wrong key", path: "/scan/direct", token: signToken(t, jwt.SigningMethodRS256, "rsa", otherKey, scan),
+			status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "hmac", path: "/scan/direct", token: signToken(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), scan),
+			status: http.StatusUnauthorized, code: codeUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, keyID = "", ""
+			method := http.MethodPost
+			if tt.path != "/scan/direct" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			req.Header.Set(AuthorizationKey, "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Equal(t, tt.subject, subject)
+			if tt.name == "api key" {
+				assert.Equal(t, "ci", keyID)
+			}
+			if len(tt.code) == 0 {
+				return
+			}
+			resp := decodeError(t, w.Body.Bytes(), nil)
+			assert.Equal(t, tt.code, resp.Code)
+			if len(tt.message) > 0 {
+				assert.Equal(t, tt.message, resp.Message)
+			}
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, w.Header().Get(AuthenticateKey), `error="invalid_token"`)
+			}
+		})
+	}
+	// The subject identifies the client for rate limiting
+	req := httptest.NewRequest(http.MethodPost, "/scan/direct", nil)
+	req.Header.Set(AuthorizationKey, "Bearer "+signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, scan))
+	var client string
+	router = mux.NewRouter()
+	router.HandleFunc("/scan/direct", func(_ http.ResponseWriter, r *http.Request) { client = apiService.clientID(r) })
+	router.Use(apiService.Authenticate)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "sub:user@example.com", client)
+}
+
+func TestSetupJWT(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := testJWKS(t, testJWK(t, "rsa", &key.PublicKey))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(jwks) }))
+	defer server.Close()
+
+	myConfig := jwtConfig(t, "")
+	myConfig.Auth.JWKSURL = server.URL
+	myConfig.Auth.JWKSRefresh = 60
+	apiService := NewAPIService(myConfig) // Tokens only, no API keys
+	assert.NoError(t, apiService.SetupAuth())
+	scheduler := apiService.tokens.scheduler
+	if assert.NotNil(t, scheduler) {
+		defer func() { // Closing the service stops reloading the JWKS
+			apiService.Close()
+			assert.False(t, scheduler.IsRunning())
+		}()
+	}
+	caller, err := apiService.tokens.verify(signToken(t, jwt.SigningMethodRS256, "", key, jwt.MapClaims{"scope": "*"})) // Single key, no kid
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.com", caller.subject)
+	assert.True(t, caller.scopes.allows(scopeMetrics))
+
+	// The current keys are kept if reloading fails
+	apiService.tokens.url = server.URL + "/missing"
+	jwks = []byte("{}")
+	assert.Error(t, apiService.tokens.reload())
+	_, err = apiService.tokens.verify(signToken(t, jwt.SigningMethodRS256, "rsa", key, nil))
+	assert.NoError(t, err)
+	apiService.tokens.url = server.URL
+	jwks = []byte(strings.Repeat(" ", jwksMaxSize) + "{}") // Too large, rather than truncated
+	assert.ErrorContains(t, apiService.tokens.reload(), "JWKS too large")
+
+	myConfig = jwtConfig(t, filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, NewAPIService(myConfig).SetupAuth())
+	myConfig = jwtConfig(t, "")
+	assert.ErrorContains(t, NewAPIService(myConfig).SetupAuth(), "JWKS")
+	myConfig.Auth.JWKSURL = server.URL
+	myConfig.Auth.JWTAudience = ""
+	assert.ErrorContains(t, NewAPIService(myConfig).SetupAuth(), "SCAN_JWT_AUDIENCE")
+	myConfig.Auth.Enabled = false // JWT validation only applies when authentication is enabled
+	assert.Nil(t, NewAPIService(myConfig).tokens)
+}
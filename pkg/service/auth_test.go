@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyHash returns the SHA-256 hex digest of an API key, as stored in the API key configuration.
+func keyHash(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		scopes  []string
+		wantErr bool
+	}{
+		{name: "single scope", entry: "ci " + keyHash("secret") + " scan", scopes: []string{scopeScan}},
+		{name: "multiple scopes", entry: "ci\t" + keyHash("secret") + "  Scan contents", scopes: []string{scopeScan, scopeContents}},
+		{name: "all scopes", entry: "admin " + keyHash("secret") + " *", scopes: []string{scopeAll}},
+		{name: "no scopes", entry: "ci " + keyHash("secret"), wantErr: true},
+		{name: "unknown scope", entry: "ci " + keyHash("secret") + " scan admin", wantErr: true},
+		{name: "plain text key", entry: "ci secret scan", wantErr: true},
+		{name: "short hash", entry: "ci " + keyHash("secret")[:32] + " scan", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseAPIKey(tt.entry)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, keyHash("secret"), hex.EncodeToString(key.hash))
+			assert.Len(t, key.scopes, len(tt.scopes))
+			for _, scope := range tt.scopes {
+				assert.True(t, key.scopes.allows(scope), scope)
+			}
+		})
+	}
+}
+
+func TestSetupAuth(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	keysFile := filepath.Join(t.TempDir(), "api_keys.txt")
+	contents := "# id hash scopes\nci " + keyHash("ci-secret") + " scan contents\n\nmetrics " + keyHash("metrics-secret") + " metrics\n"
+	if err = os.WriteFile(keysFile, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	myConfig := setupConfig(t)
+	myConfig.Auth.Enabled = true
+	myConfig.Auth.KeysFile = keysFile
+	myConfig.Auth.Keys = []string{"admin " + keyHash("admin-secret") + " *"}
+	apiService := NewAPIService(myConfig)
+	assert.NoError(t, apiService.SetupAuth())
+	for _, id := range []string{"ci", "metrics", "admin"} {
+		key, found := apiService.apiKeys.lookup(id + "-secret")
+		assert.True(t, found, id)
+		assert.Equal(t, id, key.id)
+	}
+	_, found := apiService.apiKeys.lookup("unknown")
+	assert.False(t, found)
+
+	myConfig.Auth.Keys = []string{"ci " + keyHash("other-secret") + " scan"}
+	assert.ErrorContains(t, NewAPIService(myConfig).SetupAuth(), "duplicate")
+	myConfig.Auth.KeysFile = filepath.Join(t.TempDir(), "missing.txt")
+	assert.Error(t, NewAPIService(myConfig).SetupAuth())
+	myConfig.Auth.KeysFile = ""
+	myConfig.Auth.Keys = nil
+	assert.Error(t, NewAPIService(myConfig).SetupAuth()) // Enabled without any keys
+	myConfig.Auth.Enabled = false
+	assert.NoError(t, NewAPIService(myConfig).SetupAuth())
+}
+
+func TestAuthenticate(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Auth.Enabled = true
+	myConfig.Auth.Keys = []string{"ci " + keyHash("ci-secret") + " scan contents", "admin " + keyHash("admin-secret") + " *"}
+	apiService := NewAPIService(myConfig)
+	if err = apiService.SetupAuth(); err != nil {
+		t.Fatal(err)
+	}
+	var keyID string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		keyID, _ = r.Context().Value(KeyIDContextKey{}).(string)
+		w.WriteHeader(http.StatusOK)
+	}
+	router := mux.NewRouter()
+	for _, path := range []string{"/", "/api/health", "/scan/direct", "/api/scan/direct", "/file_contents/{md5}", "/license/obligations/{license}",
+		"/api/metrics/{type}", "/kb/details"} {
+		router.HandleFunc(path, handler).Methods(http.MethodGet, http.MethodPost, http.MethodHead)
+	}
+	router.Use(apiService.Authenticate)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+		code    string
+		keyID   string
+	}{
+		{name: "welcome", method: http.MethodGet, path: "/", status: http.StatusOK},
+		{name: "health", method: http.MethodGet, path: "/api/health", status: http.StatusOK},
+		{name: "head health", method: http.MethodHead, path: "/api/health", status: http.StatusOK},
+		{name: "head", method: http.MethodHead, path: "/scan/direct", status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "no key", method: http.MethodPost, path: "/scan/direct", status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "invalid key", method: http.MethodPost, path: "/scan/direct", headers: map[string]string{SessionKey: "wrong"}, status: http.StatusUnauthorized,
+			code: codeUnauthorized},
+		{name: "session", method: http.MethodPost, path: "/scan/direct", headers: map[string]string{SessionKey: "ci-secret"}, status: http.StatusOK, keyID: "ci"},
+		{name: "bearer", method: http.MethodPost, path: "/api/scan/direct", headers: map[string]string{AuthorizationKey: "bearer ci-secret"}, status: http.StatusOK,
+			keyID: "ci"},
+		{name: "basic", method: http.MethodPost, path: "/scan/direct", headers: map[string]string{AuthorizationKey: "Basic Y2k6c2VjcmV0"},
+			status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "contents", method: http.MethodGet, path: "/file_contents/37f7cd1e657aa3c30ece35995b4c59e5", headers: map[string]string{SessionKey: "ci-secret"},
+			status: http.StatusOK, keyID: "ci"},
+		{name: "missing scope", method: http.MethodGet, path: "/license/obligations/MIT", headers: map[string]string{SessionKey: "ci-secret"},
+			status: http.StatusForbidden, code: codeInsufficientScope},
+		{name: "missing metrics scope", method: http.MethodGet, path: "/api/metrics/all", headers: map[string]string{AuthorizationKey: "Bearer ci-secret"},
+			status: http.StatusForbidden, code: codeInsufficientScope},
+		{name: "all scopes", method: http.MethodGet, path: "/api/metrics/all", headers: map[string]string{SessionKey: "admin-secret"}, status: http.StatusOK,
+			keyID: "admin"},
+		{name: "any scope", method: http.MethodGet, path: "/kb/details", headers: map[string]string{SessionKey: "ci-secret"}, status: http.StatusOK, keyID: "ci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyID = ""
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(RequestIDKey, "req-"+tt.name)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Equal(t, tt.keyID, keyID)
+			if len(tt.code) == 0 {
+				return
+			}
+			resp := decodeError(t, w.Body.Bytes(), nil)
+			assert.Equal(t, tt.code, resp.Code)
+			assert.Equal(t, "req-"+tt.name, resp.RequestID)
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, w.Header().Get(AuthenticateKey), "Bearer")
+			}
+		})
+	}
+	// The scope needed is reported in the details
+	req := httptest.NewRequest(http.MethodGet, "/license/obligations/MIT", nil)
+	req.Header.Set(SessionKey, "ci-secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var details scopeDetails
+	decodeError(t, w.Body.Bytes(), &details)
+	assert.Equal(t, scopeLicense, details.Scope)
+}
@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"container/list"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+)
+
+// Cache backend types.
+const (
+	cacheNone   = "none"   // Caching disabled
+	cacheMemory = "memory" // In-memory LRU cache
+	cacheDisk   = "disk"   // One file per entry on disk
+)
+
+// cacheBackend stores cached values by key.
+type cacheBackend interface {
+	get(key string) ([]byte, bool)
+	set(key string, value []byte)
+	purge()
+}
+
+// newCacheBackend creates a cache of the given type, or nil if caching is disabled (or the cache could not be set up).
+// Limits of zero mean unlimited. If no directory is given, disk caches are stored in the system temp directory.
+func newCacheBackend(name, cacheType string, maxEntries int, maxBytes int64, dir string) cacheBackend {
+	switch cacheType {
+	case "", cacheNone:
+		return nil
+	case cacheMemory:
+		return newMemoryCache(maxEntries, maxBytes)
+	case cacheDisk:
+		if len(dir) == 0 {
+			dir = filepath.Join(os.TempDir(), "scanoss-"+name+"-cache")
+		}
+		diskCache, err := newDiskCache(dir, maxEntries, maxBytes)
+		if err != nil {
+			zlog.S.Errorf("Failed to set up the %v cache in %v. Caching disabled: %v", name, dir, err)
+			return nil
+		}
+		return diskCache
+	default:
+		zlog.S.Errorf("Unknown %v cache type: %v. Caching disabled", name, cacheType)
+		return nil
+	}
+}
+
+// memoryCache is an in-memory cache, evicting the least recently used entries once full.
+type memoryCache struct {
+	mu         sync.Mutex
+	maxEntries int                      // Maximum number of entries (0 = unlimited)
+	maxBytes   int64                    // Maximum total size of the entries (0 = unlimited)
+	size       int64                    // Total size of the entries
+	order      *list.List               // Cached entries, most recently used first
+	entries    map[string]*list.Element // Cache key -> entry in the order list
+	evicted    func(key string)         // Optionally called for each entry evicted to make room
+}
+
+// memoryCacheEntry is a single cached value.
+type memoryCacheEntry struct {
+	key   string
+	value []byte
+	size  int64
+}
+
+// newMemoryCache creates an in-memory cache holding up to the given number of entries and bytes.
+func newMemoryCache(maxEntries int, maxBytes int64) *memoryCache {
+	return &memoryCache{maxEntries: maxEntries, maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// cacheEntry returns the cached value held in the given element of the order list.
+func cacheEntry(element *list.Element) *memoryCacheEntry {
+	entry, _ := element.Value.(*memoryCacheEntry) // Only cache entries are added to the list
+	return entry
+}
+
+// get returns the cached value for the given key (if any), marking it as recently used.
+func (c *memoryCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return cacheEntry(element).value, true
+}
+
+// set caches the given value, evicting the least recently used entries if the cache is full.
+func (c *memoryCache) set(key string, value []byte) {
+	c.add(key, value, int64(len(value)))
+}
+
+// add caches a value of the given size, evicting the least recently used entries if the cache is full.
+// The value may be nil if it is held elsewhere (i.e. on disk). It reports false if the value is too large to cache.
+func (c *memoryCache) add(key string, value []byte, size int64) bool {
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, found := c.entries[key]; found {
+		entry := cacheEntry(element)
+		c.size += size - entry.size
+		entry.value, entry.size = value, size
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(&memoryCacheEntry{key: key, value: value, size: size})
+		c.size += size
+	}
+	for (c.maxEntries > 0 && c.order.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		oldest := cacheEntry(c.order.Back())
+		c.order.Remove(c.order.Back())
+		delete(c.entries, oldest.key)
+		c.size -= oldest.size
+		if c.evicted != nil {
+			c.evicted(oldest.key)
+		}
+	}
+	return true
+}
+
+// remove drops the given key from the cache.
+func (c *memoryCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, found := c.entries[key]; found {
+		c.size -= cacheEntry(element).size
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+// purge removes all the cached entries.
+func (c *memoryCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+}
+
+// diskCache stores each cached value in its own file on disk.
+type diskCache struct {
+	dir   string
+	index *memoryCache // Size & use of each cached file, so the least recently used can be removed once the cache is full
+}
+
+// newDiskCache creates a disk cache in the given directory, holding up to the given number of entries and bytes (0 = unlimited).
+// Any files already in the directory are kept.
+func newDiskCache(dir string, maxEntries int, maxBytes int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	c := &diskCache{dir: dir, index: newMemoryCache(maxEntries, maxBytes)}
+	c.index.evicted = func(key string) {
+		if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+			zlog.S.Warnf("Failed to remove cache file for %v: %v", key, err)
+		}
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load indexes the files already in the cache directory, oldest first.
+func (c *diskCache) load() error {
+	type cachedFile struct {
+		key      string
+		size     int64
+		modified time.Time
+	}
+	var files []cachedFile
+	err := filepath.WalkDir(c.dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, cachedFile{key: entry.Name(), size: info.Size(), modified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modified.Before(files[j].modified) })
+	for _, file := range files {
+		c.index.add(file.key, nil, file.size)
+	}
+	return nil
+}
+
+// path returns the file used to store the value with the given key, spreading the files over sub-directories.
+func (c *diskCache) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(c.dir, key)
+	}
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns the cached value for the given key (if any).
+func (c *diskCache) get(key string) ([]byte, bool) {
+	value, err := os.ReadFile(c.path(key))
+	if err != nil {
+		c.index.remove(key)
+		return nil, false
+	}
+	c.index.get(key) // Mark as recently used
+	return value, true
+}
+
+// set caches the given value. It is written to a temporary file first, so that readers never see a partial value.
+func (c *diskCache) set(key string, value []byte) {
+	if c.index.maxBytes > 0 && int64(len(value)) > c.index.maxBytes {
+		return // Too large to cache
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		zlog.S.Warnf("Failed to create cache directory: %v", err)
+		return
+	}
+	tempFile, err := writeTempFile(filepath.Dir(path), "cache*.tmp", value, zlog.S)
+	if err != nil {
+		return
+	}
+	if err = os.Rename(tempFile.Name(), path); err != nil {
+		zlog.S.Warnf("Failed to store cache file %v: %v", path, err)
+		removeFile(tempFile, zlog.S)
+		return
+	}
+	c.index.add(key, nil, int64(len(value)))
+}
+
+// purge removes all the cached values.
+func (c *diskCache) purge() {
+	c.index.purge()
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		zlog.S.Warnf("Failed to read cache directory %v: %v", c.dir, err)
+		return
+	}
+	for _, entry := range entries {
+		if err = os.RemoveAll(filepath.Join(c.dir, entry.Name())); err != nil {
+			zlog.S.Warnf("Failed to remove cached files %v: %v", entry.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCache(t *testing.T) {
+	cache := newMemoryCache(2, 0)
+	cache.set("a", []byte("1"))
+	cache.set("b", []byte("2"))
+	value, found := cache.get("a") // a is now the most recently used
+	assert.True(t, found)
+	assert.Equal(t, "1", string(value))
+	cache.set("c", []byte("3")) // Evicts b
+	_, found = cache.get("b")
+	assert.False(t, found)
+	cache.set("a", []byte("4"))
+	value, _ = cache.get("a")
+	assert.Equal(t, "4", string(value))
+	_, found = cache.get("c")
+	assert.True(t, found)
+	cache.purge()
+	_, found = cache.get("a")
+	assert.False(t, found)
+	assert.Equal(t, 0, cache.order.Len())
+}
+
+func TestMemoryCacheBytes(t *testing.T) {
+	cache := newMemoryCache(0, 10)
+	cache.set("a", []byte("1234"))
+	cache.set("b", []byte("1234"))
+	cache.get("a")
+	cache.set("c", []byte("1234")) // Evicts b
+	_, found := cache.get("b")
+	assert.False(t, found)
+	assert.Equal(t, int64(8), cache.size)
+	cache.set("d", []byte("12345678901")) // Too large to cache
+	_, found = cache.get("d")
+	assert.False(t, found)
+	_, found = cache.get("a")
+	assert.True(t, found)
+	cache.set("a", []byte("1234567")) // Replacing a evicts c
+	_, found = cache.get("c")
+	assert.False(t, found)
+	assert.Equal(t, int64(7), cache.size)
+}
+
+func TestDiskCache(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	dir := filepath.Join(t.TempDir(), "cache")
+	cache, err := newDiskCache(dir, 0, 20)
+	if err != nil {
+		t.Fatalf("an error was not expected creating the disk cache: %v", err)
+	}
+	const key = "0123456789abcdef"
+	_, found := cache.get(key)
+	assert.False(t, found)
+	cache.set(key, []byte(`[{"id":"none"}]`))
+	value, found := cache.get(key)
+	assert.True(t, found)
+	assert.Equal(t, `[{"id":"none"}]`, string(value))
+	assert.FileExists(t, filepath.Join(dir, "01", key))
+
+	// Existing files are picked up by a new cache, and evicted once it is full
+	cache, err = newDiskCache(dir, 0, 20)
+	if err != nil {
+		t.Fatalf("an error was not expected reopening the disk cache: %v", err)
+	}
+	_, found = cache.get(key)
+	assert.True(t, found)
+	cache.set("fedcba9876543210", []byte("0123456789"))
+	assert.NoFileExists(t, filepath.Join(dir, "01", key))
+	cache.set("x", []byte("0123456789abcdefghijk")) // Too large to cache
+	_, found = cache.get("x")
+	assert.False(t, found)
+
+	cache.purge()
+	_, found = cache.get("fedcba9876543210")
+	assert.False(t, found)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// verifiedClientCert returns the client certificate of the request's TLS connection, if it was verified against
+// the client CA bundle (SCAN_TLS_CLIENT_CA). Unverified certificates are never trusted as an identity.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certNames returns the names identifying a client certificate: its URI, DNS & email SANs, then its subject CN.
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	if len(cert.Subject.CommonName) > 0 {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// certIdentity returns the identity of a client certificate (its first name), as used for logging and rate limiting.
+func certIdentity(cert *x509.Certificate) string {
+	if names := certNames(cert); len(names) > 0 {
+		return names[0]
+	}
+	return cert.Subject.String()
+}
+
+// parseClientCert parses a client certificate entry of the form: <identity> <scope> [<scope>...].
+func parseClientCert(entry string) (string, scopeSet, error) {
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return "", nil, fmt.Errorf("expected '<identity> <scope>...', got %d field(s)", len(fields))
+	}
+	scopes, err := parseScopes(fields[0], fields[1:])
+	if err != nil {
+		return "", nil, err
+	}
+	return fields[0], scopes, nil
+}
+
+// loadClientCerts loads the client certificate identities, and the scopes they're granted, from the given entries.
+func loadClientCerts(entries []string) (map[string]scopeSet, error) {
+	certs := make(map[string]scopeSet)
+	for _, entry := range entries {
+		if len(strings.TrimSpace(entry)) == 0 {
+			continue
+		}
+		name, scopes, err := parseClientCert(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate entry: %w", err)
+		}
+		if _, found := certs[name]; found {
+			return nil, fmt.Errorf("duplicate client certificate identity: %v", name)
+		}
+		certs[name] = scopes
+	}
+	return certs, nil
+}
+
+// certificateCaller identifies the caller from its verified client certificate, if one of its names has been granted scopes.
+func (s APIService) certificateCaller(r *http.Request) (callerIdentity, bool) {
+	cert := verifiedClientCert(r)
+	if cert == nil {
+		return callerIdentity{}, false
+	}
+	for _, name := range certNames(cert) {
+		if scopes, found := s.apiKeys.lookupCert(name); found {
+			return callerIdentity{certificate: name, scopes: scopes}, true
+		}
+	}
+	return callerIdentity{}, false
+}
+
+// ClientCertificate is middleware adding the identity of the verified client certificate (mTLS), if any, to the
+// request context (for logging and rate limiting) and to the request span.
+func (s APIService) ClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert := verifiedClientCert(r)
+		if cert == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.config.Telemetry.Enabled {
+			oteltrace.SpanFromContext(r.Context()).SetAttributes(attribute.String("tls.client.subject", cert.Subject.String()))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ClientCertContextKey{}, certIdentity(cert))))
+	})
+}
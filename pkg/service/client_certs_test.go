@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert creates a certificate from the template, signed by the given parent (self-signed if nil).
+func testCert(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// testCA creates a self-signed CA certificate.
+func testCA(t *testing.T) tls.Certificate {
+	t.Helper()
+	return testCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}, IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign}, nil)
+}
+
+// testClientCert creates a client certificate, with the given subject CN and DNS SANs, signed by the CA.
+func testClientCert(t *testing.T, ca tls.Certificate, cn string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	return testCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: cn, Organization: []string{"SCANOSS"}}, DNSNames: dnsNames,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, &ca)
+}
+
+// withClientCert returns the request as if it was sent over a TLS connection with the given (verified) client certificate.
+func withClientCert(r *http.Request, cert tls.Certificate, verified bool) *http.Request {
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}
+	if verified {
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{cert.Leaf}}
+	}
+	return r
+}
+
+func TestCertNames(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/ci")
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ci"}, URIs: []*url.URL{spiffe}, DNSNames: []string{"ci.example.com"},
+		EmailAddresses: []string{"ci@example.com"}}
+	assert.Equal(t, []string{"spiffe://example.com/ci", "ci.example.com", "ci@example.com", "ci"}, certNames(cert))
+	assert.Equal(t, "spiffe://example.com/ci", certIdentity(cert))
+	assert.Equal(t, "ci", certIdentity(&x509.Certificate{Subject: pkix.Name{CommonName: "ci"}}))
+	assert.Equal(t, "O=SCANOSS", certIdentity(&x509.Certificate{Subject: pkix.Name{Organization: []string{"SCANOSS"}}}))
+}
+
+func TestLoadClientCerts(t *testing.T) {
+	certs, err := loadClientCerts([]string{"ci.example.com scan contents", "", "admin *"})
+	assert.NoError(t, err)
+	assert.Len(t, certs, 2)
+	assert.True(t, certs["ci.example.com"].allows(scopeContents))
+	assert.False(t, certs["ci.example.com"].allows(scopeMetrics))
+	assert.True(t, certs["admin"].allows(scopeMetrics))
+	_, err = loadClientCerts([]string{"ci.example.com"})
+	assert.Error(t, err)
+	_, err = loadClientCerts([]string{"ci.example.com scan admin"})
+	assert.Error(t, err)
+	_, err = loadClientCerts([]string{"ci scan", "ci contents"})
+	assert.ErrorContains(t, err, "duplicate")
+}
+
+func TestClientCertificate(t *testing.T) {
+	ca := testCA(t)
+	cert := testClientCert(t, ca, "ci", "ci.example.com")
+	apiService := NewAPIService(setupConfig(t))
+	var identity, client string
+	handler := apiService.ClientCertificate(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		identity, _ = r.Context().Value(ClientCertContextKey{}).(string)
+		client = apiService.clientID(r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), withClientCert(httptest.NewRequest(http.MethodGet, "/kb/details", nil), cert, true))
+	assert.Equal(t, "ci.example.com", identity)
+	assert.Equal(t, "cert:ci.example.com", client)
+	handler.ServeHTTP(httptest.NewRecorder(), withClientCert(httptest.NewRequest(http.MethodGet, "/kb/details", nil), cert, false))
+	assert.Empty(t, identity) // Unverified certificates are ignored
+	assert.Equal(t, "ip:192.0.2.1", client)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/kb/details", nil))
+	assert.Empty(t, identity)
+}
+
+func TestAuthenticateClientCert(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Auth.Enabled = true
+	myConfig.Auth.ClientCerts = []string{"ci scan"}
+	myConfig.Auth.Keys = []string{"admin " + keyHash("admin-secret") + " *"}
+	apiService := NewAPIService(myConfig)
+	if err = apiService.SetupAuth(); err != nil {
+		t.Fatal(err)
+	}
+	ca := testCA(t)
+	router := mux.NewRouter()
+	var identity, keyID string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = r.Context().Value(ClientCertContextKey{}).(string)
+		keyID, _ = r.Context().Value(KeyIDContextKey{}).(string)
+		w.WriteHeader(http.StatusOK)
+	}
+	router.HandleFunc("/scan/direct", handler).Methods(http.MethodPost)
+	router.HandleFunc("/api/metrics/{type}", handler).Methods(http.MethodGet)
+	router.Use(apiService.ClientCertificate, apiService.Authenticate)
+	send := func(method, path string, cert tls.Certificate, verified bool, key string) *httptest.ResponseRecorder {
+		identity, keyID = "", ""
+		req := withClientCert(httptest.NewRequest(method, path, nil), cert, verified)
+		if len(key) > 0 {
+			req.Header.Set(SessionKey, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := send(http.MethodPost, "/scan/direct", testClientCert(t, ca, "ci", "ci.example.com"), true, "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "ci", identity) // The name granted the scopes
+	w = send(http.MethodGet, "/api/metrics/all", testClientCert(t, ca, "ci"), true, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, codeInsufficientScope, decodeError(t, w.Body.Bytes(), nil).Code)
+	w = send(http.MethodPost, "/scan/direct", testClientCert(t, ca, "ci"), false, "") // Unverified
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = send(http.MethodPost, "/scan/direct", testClientCert(t, ca, "other"), true, "") // Not granted any scopes
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = send(http.MethodGet, "/api/metrics/all", testClientCert(t, ca, "other"), true, "admin-secret")
+	assert.Equal(t, http.StatusOK, w.Code) // The API key authenticates, the certificate is still logged
+	assert.Equal(t, "admin", keyID)
+	assert.Equal(t, "other", identity)
+
+	myConfig.Auth.Keys = nil // Client certificates only
+	assert.NoError(t, NewAPIService(myConfig).SetupAuth())
+	myConfig.Auth.ClientCerts = []string{"ci"}
+	assert.Error(t, NewAPIService(myConfig).SetupAuth())
+}
+
+func TestClientCertHandshake(t *testing.T) {
+	ca := testCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	apiService := NewAPIService(setupConfig(t))
+	server := httptest.NewUnstartedServer(apiService.ClientCertificate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := r.Context().Value(ClientCertContextKey{}).(string)
+		_, _ = io.WriteString(w, identity)
+	})))
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	get := func(certs ...tls.Certificate) (string, error) {
+		transport, _ := server.Client().Transport.(*http.Transport)
+		transport = transport.Clone()
+		transport.TLSClientConfig.Certificates = certs
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+	identity, err := get(testClientCert(t, ca, "ci", "ci.example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ci.example.com", identity)
+	identity, err = get()
+	assert.NoError(t, err)
+	assert.Empty(t, identity)
+	_, err = get(testClientCert(t, testCA(t), "ci")) // Signed by an unknown CA
+	assert.Error(t, err)
+}
@@ -0,0 +1,332 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Content codings supported for responses and uploads.
+const (
+	encodingGzip     = "gzip"
+	encodingZstd     = "zstd"
+	encodingIdentity = "identity"
+)
+
+// responseEncoder is a (reusable) compressor of response bodies.
+type responseEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPools keeps the response encoders for reuse across requests, as they are expensive to create.
+var encoderPools = map[string]*sync.Pool{
+	encodingGzip: {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	encodingZstd: {New: func() any {
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return zw
+	}},
+}
+
+// CompressResponses is middleware compressing responses with gzip or zstd for clients which accept it (Accept-Encoding).
+// Responses smaller than SCANOSS_COMPRESS_MIN_SIZE, partial (ranged) responses and those already encoded are sent as they are.
+func (s APIService) CompressResponses(next http.Handler) http.Handler {
+	minSize := max(s.config.Scanning.CompressMinSize, 0)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(VaryKey, AcceptEncodingKey)
+		encoding := negotiateEncoding(r.Header.Get(AcceptEncodingKey))
+		if len(encoding) == 0 || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+		defer func() {
+			if err := cw.close(); err != nil {
+				zlog.S.Debugf("Failed to complete %v response: %v", encoding, err) // Usually the client going away
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns the supported content coding with the highest quality value in an Accept-Encoding header.
+// zstd is preferred when both are equally acceptable. An empty string is returned if neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := make(map[string]float64)
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			var err error
+			if quality, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if len(name) > 0 {
+			qualities[name] = quality
+		}
+	}
+	preferred, best := "", 0.0
+	for _, encoding := range []string{encodingZstd, encodingGzip} {
+		quality, found := qualities[encoding]
+		if !found {
+			quality = qualities["*"] // Codings not listed are only acceptable if covered by a wildcard
+		}
+		if quality > best {
+			preferred, best = encoding, quality
+		}
+	}
+	return preferred
+}
+
+// compressWriter compresses a response as it is written. The response is held back until it is large enough to be worth
+// compressing (or is flushed), so that small responses can still be sent uncompressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string          // Content coding to compress with
+	minSize  int             // Minimum response size worth compressing
+	status   int             // Status code held back until compression is decided
+	buf      []byte          // Output held back until compression is decided
+	decided  bool            // Compression has been decided (and the header written)
+	encoder  responseEncoder // Set if the response is being compressed
+}
+
+// WriteHeader records the status code, writing the header straight away if the response cannot be compressed.
+func (cw *compressWriter) WriteHeader(status int) {
+	if status < http.StatusOK { // Informational responses are passed straight through
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if !cw.compressible() {
+		cw.decide(false)
+	}
+}
+
+// Write compresses the given data, or holds it back if compression has not been decided yet.
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		cw.decide(true)
+		if err := cw.writeHeldBack(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// FlushError sends everything written so far to the client. Flushing commits the response to compression (if allowed),
+// as the rest of a streamed response is likely to make it worthwhile.
+func (cw *compressWriter) FlushError() error {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide(true)
+		if err := cw.writeHeldBack(); err != nil {
+			return err
+		}
+	}
+	if cw.encoder != nil {
+		if err := cw.encoder.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Flush sends everything written so far to the client.
+func (cw *compressWriter) Flush() {
+	_ = cw.FlushError()
+}
+
+// Unwrap returns the original response writer (for use by http.ResponseController).
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// compressible reports if the response can be compressed, based on its status and headers.
+func (cw *compressWriter) compressible() bool {
+	switch cw.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	header := cw.Header()
+	if len(header.Get(ContentEncodingKey)) > 0 || len(header.Get(ContentRangeKey)) > 0 {
+		return false
+	}
+	if length, err := strconv.Atoi(header.Get(ContentLengthKey)); err == nil && length < cw.minSize {
+		return false
+	}
+	return true
+}
+
+// decide writes the response header, either setting up compression or sending the response as it is.
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	if compress && cw.compressible() {
+		header := cw.Header()
+		header.Set(ContentEncodingKey, cw.encoding)
+		header.Del(ContentLengthKey)
+		if etag := header.Get(ETagKey); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+			header.Set(ETagKey, "W/"+etag) // The compressed bytes differ from the original representation
+		}
+		cw.encoder, _ = encoderPools[cw.encoding].Get().(responseEncoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// writeHeldBack writes out the data held back while compression was being decided.
+func (cw *compressWriter) writeHeldBack() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close completes the response, sending any data held back and finishing the compressed stream.
+func (cw *compressWriter) close() error {
+	if cw.status == 0 { // Nothing written, so leave the response to the server
+		return nil
+	}
+	if !cw.decided {
+		cw.decide(false) // Too small to be worth compressing
+	}
+	err := cw.writeHeldBack()
+	if cw.encoder != nil {
+		if closeErr := cw.encoder.Close(); err == nil {
+			err = closeErr
+		}
+		cw.encoder.Reset(io.Discard) // Don't keep hold of the response writer while pooled
+		encoderPools[cw.encoding].Put(cw.encoder)
+		cw.encoder = nil
+	}
+	return err
+}
+
+// decompressedBody reads a compressed request body, failing once the decompressed data exceeds the configured limit.
+type decompressedBody struct {
+	reader    io.ReadCloser // Decompressor
+	body      io.ReadCloser // Original (compressed) request body
+	remaining int64         // Bytes left before the limit is exceeded (-1 for no limit)
+	maxSize   int64         // Maximum decompressed size in MB
+	err       error
+}
+
+// Read returns the decompressed request body, up to the maximum decompressed size.
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.remaining < 0 {
+		return b.reader.Read(p)
+	}
+	if int64(len(p)) > b.remaining+1 { // Read one byte beyond the limit to see if it's exceeded
+		p = p[:b.remaining+1]
+	}
+	n, err := b.reader.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n = int(b.remaining)
+	b.remaining = 0
+	b.err = &limitError{limit: limitDecompressedSize, maxValue: b.maxSize, unit: "MB"}
+	return n, b.err
+}
+
+// Close closes both the decompressor and the original request body.
+func (b *decompressedBody) Close() error {
+	err := b.reader.Close()
+	if bodyErr := b.body.Close(); err == nil {
+		err = bodyErr
+	}
+	return err
+}
+
+// decompressRequestBody replaces a compressed (Content-Encoding: gzip) request body with its decompressed contents, limited
+// to SCANOSS_MAX_DECOMPRESSED_SIZE to guard against decompression bombs. Uncompressed bodies are left as they are.
+// On failure, the error is written to the response and false is returned.
+func (s APIService) decompressRequestBody(w http.ResponseWriter, r *http.Request, zs *zap.SugaredLogger) bool {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get(ContentEncodingKey)))
+	switch encoding {
+	case "", encodingIdentity:
+		return true
+	case encodingGzip, "x-gzip":
+	default:
+		zs.Errorf("Unsupported request Content-Encoding: %v", encoding)
+		w.Header().Set(AcceptEncodingKey, encodingGzip) // Tell the client which codings are accepted (RFC 7694)
+		writeError(w, codeUnsupportedEncoding, fmt.Sprintf("unsupported Content-Encoding: %v (only gzip is supported)", encoding), nil, zs)
+		return false
+	}
+	gr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		if isMaxBytesError(err) {
+			writeLimitExceeded(w, limitUploadSize, s.config.Scanning.MaxUploadSize, "MB", zs)
+			return false
+		}
+		zs.Errorf("Failed to read gzip request body: %v", err)
+		writeError(w, codeInvalidEncoding, "invalid gzip request body", nil, zs)
+		return false
+	}
+	maxSize := s.config.Scanning.MaxDecompressedSize
+	remaining := int64(-1)
+	if maxSize > 0 {
+		remaining = maxSize * 1024 * 1024
+	}
+	r.Body = &decompressedBody{reader: gr, body: r.Body, remaining: remaining, maxSize: maxSize}
+	r.Header.Del(ContentEncodingKey)
+	r.ContentLength = -1
+	zs.Debugf("Decompressing %v request body", encoding)
+	return true
+}
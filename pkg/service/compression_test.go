@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeBody returns the decompressed body of a response, based on its Content-Encoding.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var reader io.Reader
+	switch w.Header().Get(ContentEncodingKey) {
+	case encodingGzip:
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("invalid gzip response: %v", err)
+		}
+		reader = gr
+	case encodingZstd:
+		zr, err := zstd.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("invalid zstd response: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		reader = w.Body
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decode %v response: %v", w.Header().Get(ContentEncodingKey), err)
+	}
+	return string(body)
+}
+
+// gzipRequest compresses the body of the given request.
+func gzipRequest(t *testing.T, r *http.Request) *http.Request {
+	t.Helper()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(gzipData(t, body)))
+	r.ContentLength = -1
+	r.Header.Set(ContentEncodingKey, encodingGzip)
+	return r
+}
+
+// gzipData compresses the given data.
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: encodingGzip},
+		{acceptEncoding: "zstd", want: encodingZstd},
+		{acceptEncoding: "gzip, deflate, br, zstd", want: encodingZstd},
+		{acceptEncoding: "gzip, zstd;q=0.5", want: encodingGzip},
+		{acceptEncoding: "GZIP;q=0.8, zstd;q=0", want: encodingGzip},
+		{acceptEncoding: "br, deflate", want: ""},
+		{acceptEncoding: "identity", want: ""},
+		{acceptEncoding: "*", want: encodingZstd},
+		{acceptEncoding: "zstd;q=0, *;q=0.1", want: encodingGzip},
+		{acceptEncoding: "gzip;q=bad", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	myConfig := setupConfig(t)
+	myConfig.Scanning.CompressMinSize = 100
+	apiService := NewAPIService(myConfig)
+	large := strings.Repeat(`{"id":"file","status":"pending"}`+"\n", 100)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		status         int
+		body           string
+		headers        map[string]string
+		want           string // Expected Content-Encoding
+	}{
+		{name: "gzip", acceptEncoding: "gzip", status: http.StatusOK, body: large, want: encodingGzip},
+		{name: "zstd", acceptEncoding: "gzip, zstd", status: http.StatusOK, body: large, want: encodingZstd},
+		{name: "not accepted", acceptEncoding: "br", status: http.StatusOK, body: large},
+		{name: "too small", acceptEncoding: "gzip", status: http.StatusOK, body: "small"},
+		{name: "small content length", acceptEncoding: "gzip", status: http.StatusOK, body: "small", headers: map[string]string{ContentLengthKey: "5"}},
+		{name: "error", acceptEncoding: "gzip", status: http.StatusInternalServerError, body: large, want: encodingGzip},
+		{name: "partial content", acceptEncoding: "gzip", status: http.StatusPartialContent, body: large,
+			headers: map[string]string{ContentRangeKey: "bytes 0-3299/5000"}},
+		{name: "already encoded", acceptEncoding: "gzip", status: http.StatusOK, body: large, headers: map[string]string{ContentEncodingKey: "br"}, want: "br"},
+		{name: "not modified", acceptEncoding: "gzip", status: http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := apiService.CompressResponses(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				for key, value := range tt.headers {
+					w.Header().Set(key, value)
+				}
+				w.Header().Set(ETagKey, `"etag"`)
+				w.WriteHeader(tt.status)
+				for _, line := range strings.SplitAfter(tt.body, "\n") { // Written in several parts
+					_, _ = io.WriteString(w, line)
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/scan/jobs/1/result", nil)
+			req.Header.Set(AcceptEncodingKey, tt.acceptEncoding)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.want, w.Header().Get(ContentEncodingKey))
+			assert.Contains(t, w.Header().Values(VaryKey), AcceptEncodingKey)
+			if tt.want == encodingGzip || tt.want == encodingZstd {
+				assert.Less(t, w.Body.Len(), len(tt.body))
+				assert.Empty(t, w.Header().Get(ContentLengthKey))
+				assert.Equal(t, `W/"etag"`, w.Header().Get(ETagKey))
+				assert.Equal(t, tt.body, decodeBody(t, w))
+			} else {
+				assert.Equal(t, tt.body, w.Body.String())
+				assert.Equal(t, `"etag"`, w.Header().Get(ETagKey))
+			}
+		})
+	}
+}
+
+func TestCompressResponsesStream(t *testing.T) {
+	myConfig := setupConfig(t)
+	apiService := NewAPIService(myConfig)
+	lines := make(chan string)
+	handler := apiService.CompressResponses(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set(ContentTypeKey, ApplicationNDJSON)
+		for line := range lines {
+			_, _ = io.WriteString(w, line)
+			assert.NoError(t, http.NewResponseController(w).Flush())
+		}
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/scan/direct", nil)
+	req.Header.Set(AcceptEncodingKey, encodingGzip)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(w, req)
+	}()
+	lines <- `{"file":"a.c"}` + "\n" // Smaller than the minimum size, but still compressed (and sent) when flushed
+	lines <- `{"file":"b.c"}` + "\n"
+	close(lines)
+	<-done
+	assert.True(t, w.Flushed)
+	assert.Equal(t, encodingGzip, w.Header().Get(ContentEncodingKey))
+	assert.Equal(t, `{"file":"a.c"}`+"\n"+`{"file":"b.c"}`+"\n", decodeBody(t, w))
+}
+
+func TestCompressedUploads(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.MaxDecompressedSize = 1
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n"
+	bomb := wfp + strings.Repeat("x", 2*1024*1024) // Compresses to a few KB
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		encoding string // Content-Encoding of an uncompressed body
+		stream   bool
+		want     int
+		limit    string
+	}{
+		{name: "scan", path: "/scan/direct", body: wfp, want: http.StatusOK},
+		{name: "scan streamed", path: "/scan/direct", body: wfp, stream: true, want: http.StatusOK},
+		{name: "attribution", path: "/sbom/attribution", body: `{"components":[]}`, want: http.StatusOK},
+		{name: "scan bomb", path: "/scan/direct", body: bomb, want: http.StatusRequestEntityTooLarge, limit: limitDecompressedSize},
+		{name: "scan streamed bomb", path: "/scan/direct", body: bomb, stream: true, want: http.StatusRequestEntityTooLarge, limit: limitDecompressedSize},
+		{name: "attribution bomb", path: "/sbom/attribution", body: bomb, want: http.StatusRequestEntityTooLarge, limit: limitDecompressedSize},
+		{name: "invalid gzip", path: "/scan/direct", body: wfp, encoding: encodingGzip, want: http.StatusBadRequest},
+		{name: "unsupported encoding", path: "/scan/direct", body: wfp, encoding: "br", want: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myConfig.Scanning.StreamUploads = tt.stream
+			myConfig.Scanning.Workers = 2
+			apiService := NewAPIService(myConfig)
+			handler := apiService.ScanDirect
+			if strings.HasPrefix(tt.path, "/sbom") {
+				handler = apiService.SbomAttribution
+			}
+			req := newScanReq(t, tt.path, tt.body, nil)
+			if len(tt.encoding) > 0 {
+				req.Header.Set(ContentEncodingKey, tt.encoding)
+			} else {
+				req = gzipRequest(t, req)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+			if len(tt.limit) > 0 {
+				var details limitDetails
+				decodeError(t, w.Body.Bytes(), &details)
+				assert.Equal(t, tt.limit, details.Limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	myconfig "scanoss.com/go-api/pkg/config"
+)
+
+const (
+	engineCommandTimeout = 60 * time.Second // Timeout for non-scanning engine commands
+	engineTestTimeout    = 10 * time.Second // Timeout for engine health/version commands
+)
+
+// Engine defines the operations the API requires from a SCANOSS engine.
+// The default implementation (SubprocessEngine) forks the scanoss binary for each request,
+// but alternatives (in-process fakes, remote engines, recording wrappers, etc.) can be supplied via NewAPIServiceWithEngine.
+type Engine interface {
+	// Scan runs a scan of the given WFP (and optional SBOM file) and returns the JSON result.
+	// The boolean return value reports if the scan failed due to a timeout.
+	Scan(ctx context.Context, wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, bool, error)
+	// FileContents returns the contents of the file matching the given MD5.
+	FileContents(ctx context.Context, md5 string, zs *zap.SugaredLogger) ([]byte, error)
+	// LicenseDetails returns the obligations JSON for the given license.
+	LicenseDetails(ctx context.Context, license string, zs *zap.SugaredLogger) ([]byte, error)
+	// Attribution returns the attribution notices for the given SBOM contents.
+	Attribution(ctx context.Context, sbom []byte, zs *zap.SugaredLogger) ([]byte, error)
+	// Version returns the version reported by the engine.
+	Version(ctx context.Context, zs *zap.SugaredLogger) (string, error)
+	// Health checks that the engine is accessible and running.
+	Health(ctx context.Context, zs *zap.SugaredLogger) error
+}
+
+// engineCloser is implemented by engines holding resources (i.e. persistent workers) which need releasing on shutdown.
+type engineCloser interface {
+	Close()
+}
+
+// SubprocessEngine implements Engine by executing the configured scanoss binary.
+type SubprocessEngine struct {
+	config *myconfig.ServerConfig
+}
+
+// NewSubprocessEngine creates an engine which runs the scanoss binary from the server config for each request.
+func NewSubprocessEngine(config *myconfig.ServerConfig) *SubprocessEngine {
+	return &SubprocessEngine{config: config}
+}
+
+// baseArgs returns the command arguments common to every engine invocation (debug & KB name).
+func (e *SubprocessEngine) baseArgs(dbName string) []string {
+	var args []string
+	if e.config.Scanning.ScanDebug {
+		args = append(args, "-d") // Set debug mode
+	}
+	if len(dbName) > 0 {
+		args = append(args, fmt.Sprintf("-n%s", dbName)) // Set Database name if declared
+	}
+	return args
+}
+
+// run executes the engine binary with the given arguments, timeout and error description.
+// Any (optional) inputs are streamed to the engine while it runs.
+// It returns the command output and a flag indicating if the timeout was hit.
+func (e *SubprocessEngine) run(ctx context.Context, timeout time.Duration, desc string, args []string, inputs *engineInputs, zs *zap.SugaredLogger) ([]byte, bool, error) {
+	binary := e.config.Scanning.ScanBinary
+	zs.Debugf("Executing %v %v", binary, strings.Join(args, " "))
+	timeoutErr := fmt.Errorf("%s command timed out after %v", strings.ToLower(desc), timeout)
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, timeoutErr) // put a timeout on the engine execution
+	defer cancel()
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, binary, args...)
+	var output []byte
+	var err error
+	if inputs != nil {
+		output, err = inputs.output(cmd)
+	} else {
+		output, err = cmd.Output()
+	}
+	if err != nil {
+		timedOut := false
+		if cause := context.Cause(ctx); errors.Is(cause, timeoutErr) {
+			zs.Errorf("%s command (%v) timed out: %v", desc, binary, cause)
+			timedOut = true
+		} else {
+			zs.Errorf("%s command (%v %v) failed: %v", desc, binary, args, err)
+		}
+		zs.Errorf("Command output: %s", bytes.TrimSpace(output))
+		return output, timedOut, err
+	}
+	return output, false, nil
+}
+
+// Scan runs the scanoss engine against the WFP, either streaming it to the engine or writing it to a temporary file.
+// If no SBOM file is supplied, any SBOM in the scanning configuration is passed to the engine the same way.
+func (e *SubprocessEngine) Scan(ctx context.Context, wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, bool, error) {
+	if streamEngineInput(e.config) {
+		result, timedOut, err := e.scanStreamed(ctx, wfp, sbomFile, config, zs)
+		if !errors.Is(err, errEngineInput) {
+			return result, timedOut, err
+		}
+		zs.Warnf("Falling back to temporary files for scanning: %v", err)
+	}
+	if len(sbomFile) == 0 && len(config.sbomFile) > 0 && len(config.sbomType) > 0 {
+		sbomTempFile, err := writeTempFile(e.config.Scanning.WfpLoc, "sbom*.json", []byte(config.sbomFile+"\n"), zs)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to create temporary SBOM file")
+		}
+		if e.config.Scanning.TmpFileDelete {
+			defer removeFile(sbomTempFile, zs)
+		}
+		sbomFile = sbomTempFile.Name()
+	}
+	tempFile, err := os.CreateTemp(e.config.Scanning.WfpLoc, "finger*.wfp")
+	if err != nil {
+		zs.Errorf("Failed to create temporary file: %v", err)
+		return "", false, fmt.Errorf("failed to create temporary WFP file")
+	}
+	if e.config.Scanning.TmpFileDelete {
+		defer removeFile(tempFile, zs)
+	}
+	zs.Debugf("Using temporary file: %v", tempFile.Name())
+	_, err = tempFile.WriteString(wfp + "\n")
+	if err != nil {
+		closeFile(tempFile, zs)
+		zs.Errorf("Failed to write WFP to temporary file: %v", err)
+		return "", false, fmt.Errorf("failed to write to temporary WFP file")
+	}
+	closeFile(tempFile, zs)
+	args := e.baseArgs(config.dbName)
+	args = append(args, e.scanArgs(sbomFile, config)...)
+	args = append(args, "-w", tempFile.Name()) // WFP file argument
+	timeout := time.Duration(e.config.Scanning.ScanTimeout) * time.Second
+	output, timedOut, err := e.run(ctx, timeout, "Scan", args, nil, zs)
+	if err != nil {
+		if e.config.Scanning.KeepFailedWfps {
+			copyWfpTempFile(e.config.Scanning.WfpLoc, tempFile.Name(), zs)
+		}
+		return "", timedOut, fmt.Errorf("failed to scan WFP: %v", err)
+	}
+	return string(output), false, nil
+}
+
+// scanStreamed runs the scanoss engine, streaming the WFP (and SBOM) to it through stdin and/or pipes.
+func (e *SubprocessEngine) scanStreamed(ctx context.Context, wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, bool, error) {
+	inputs := &engineInputs{mode: e.config.Scanning.EngineInput}
+	wfpPath := inputs.add([]byte(wfp + "\n"))
+	if len(sbomFile) == 0 && len(config.sbomFile) > 0 && len(config.sbomType) > 0 {
+		sbomFile = inputs.add([]byte(config.sbomFile + "\n"))
+	}
+	if err := inputs.open(); err != nil {
+		return "", false, err
+	}
+	defer inputs.close()
+	args := e.baseArgs(config.dbName)
+	args = append(args, e.scanArgs(sbomFile, config)...)
+	args = append(args, "-w", wfpPath) // WFP stream argument
+	timeout := time.Duration(e.config.Scanning.ScanTimeout) * time.Second
+	output, timedOut, err := e.run(ctx, timeout, "Scan", args, inputs, zs)
+	if err != nil {
+		if e.config.Scanning.KeepFailedWfps {
+			saveFailedWfp(e.config.Scanning.WfpLoc, wfp, zs)
+		}
+		return "", timedOut, fmt.Errorf("failed to scan WFP: %v", err)
+	}
+	return string(output), false, nil
+}
+
+// scanArgs builds the scan specific command arguments from the request scanning configuration.
+func (e *SubprocessEngine) scanArgs(sbomFile string, config ScanningServiceConfig) []string {
+	var args []string
+	// Scanning flags
+	if config.flags > 0 {
+		args = append(args, fmt.Sprintf("-F%v", config.flags))
+	}
+	// SBOM configuration
+	if len(sbomFile) > 0 && len(config.sbomType) > 0 {
+		switch config.sbomType {
+		case sbomIdentify:
+			args = append(args, "-s")
+		case sbomBlackList:
+			args = append(args, "-b")
+		default:
+			args = append(args, "-s") // Default to identify
+		}
+		args = append(args, sbomFile)
+	}
+	// Ranking threshold (only if ranking is enabled and allowed)
+	if config.rankingEnabled && config.rankingThreshold >= 0 && e.config.Scanning.RankingAllowed {
+		args = append(args, fmt.Sprintf("-r%d", config.rankingThreshold))
+	}
+	// Minimum snippet hits
+	if config.minSnippetHits > 0 {
+		args = append(args, fmt.Sprintf("--min-snippet-hits=%d", config.minSnippetHits))
+	}
+	// Minimum snippet lines
+	if config.minSnippetLines > 0 {
+		args = append(args, fmt.Sprintf("--min-snippet-lines=%d", config.minSnippetLines))
+	}
+	// Honour file extensions (not yet implemented in scanoss engine)
+	if !config.honourFileExts {
+		args = append(args, "--ignore-file-ext")
+	}
+	return args
+}
+
+// FileContents retrieves the contents of the given file MD5 from the engine.
+func (e *SubprocessEngine) FileContents(ctx context.Context, md5 string, zs *zap.SugaredLogger) ([]byte, error) {
+	args := e.baseArgs(e.config.Scanning.ScanKbName)
+	args = append(args, "-k", md5)
+	output, _, err := e.run(ctx, engineCommandTimeout, "Contents", args, nil, zs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve file contents: %v", err)
+	}
+	return output, nil
+}
+
+// LicenseDetails retrieves the obligations for the given license from the engine.
+func (e *SubprocessEngine) LicenseDetails(ctx context.Context, license string, zs *zap.SugaredLogger) ([]byte, error) {
+	args := e.baseArgs(e.config.Scanning.ScanKbName)
+	args = append(args, "-l", license)
+	output, _, err := e.run(ctx, engineCommandTimeout, "License Details", args, nil, zs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve license details: %v", err)
+	}
+	return output, nil
+}
+
+// Attribution streams the SBOM (or writes it to a temporary file) and retrieves its attribution notices from the engine.
+func (e *SubprocessEngine) Attribution(ctx context.Context, sbom []byte, zs *zap.SugaredLogger) ([]byte, error) {
+	if streamEngineInput(e.config) {
+		output, err := e.attributionStreamed(ctx, sbom, zs)
+		if !errors.Is(err, errEngineInput) {
+			return output, err
+		}
+		zs.Warnf("Falling back to a temporary file for attribution: %v", err)
+	}
+	tempFile, err := os.CreateTemp(e.config.Scanning.WfpLoc, "sbom-attr*.json")
+	if err != nil {
+		zs.Errorf("Failed to create temporary SBOM file: %v", err)
+		return nil, fmt.Errorf("failed to create temporary SBOM file")
+	}
+	_, err = tempFile.Write(sbom)
+	if err != nil {
+		zs.Errorf("Failed to write to temporary SBOM file: %v - %v", tempFile.Name(), err)
+		closeFile(tempFile, zs)
+		removeFile(tempFile, zs)
+		return nil, fmt.Errorf("failed to write to temporary SBOM file")
+	}
+	closeFile(tempFile, zs)
+	if e.config.Scanning.TmpFileDelete {
+		defer removeFile(tempFile, zs)
+	}
+	zs.Debugf("Retrieving attribution for %v", tempFile.Name())
+	args := e.baseArgs(e.config.Scanning.ScanKbName)
+	args = append(args, "-a", tempFile.Name())
+	output, _, err := e.run(ctx, engineCommandTimeout, "Attribution", args, nil, zs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve attribution: %v", err)
+	}
+	return output, nil
+}
+
+// attributionStreamed retrieves the attribution notices from the engine, streaming the SBOM to it through stdin or a pipe.
+func (e *SubprocessEngine) attributionStreamed(ctx context.Context, sbom []byte, zs *zap.SugaredLogger) ([]byte, error) {
+	inputs := &engineInputs{mode: e.config.Scanning.EngineInput}
+	sbomPath := inputs.add(sbom)
+	if err := inputs.open(); err != nil {
+		return nil, err
+	}
+	defer inputs.close()
+	args := e.baseArgs(e.config.Scanning.ScanKbName)
+	args = append(args, "-a", sbomPath)
+	output, _, err := e.run(ctx, engineCommandTimeout, "Attribution", args, inputs, zs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve attribution: %v", err)
+	}
+	return output, nil
+}
+
+// Version retrieves the version string reported by the engine.
+func (e *SubprocessEngine) Version(ctx context.Context, zs *zap.SugaredLogger) (string, error) {
+	output, _, err := e.run(ctx, engineTestTimeout, "Version", []string{"-v"}, nil, zs)
+	if err != nil {
+		return "", fmt.Errorf("failed to get engine version: %v", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Health tests if the engine binary is accessible and responding.
+func (e *SubprocessEngine) Health(ctx context.Context, zs *zap.SugaredLogger) error {
+	_, _, err := e.run(ctx, engineTestTimeout, "Engine test", []string{"-h"}, nil, zs)
+	if err != nil {
+		return fmt.Errorf("failed to test scan engine: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+
+	"go.uber.org/zap"
+	myconfig "scanoss.com/go-api/pkg/config"
+)
+
+// Modes for passing WFP/SBOM data to the engine.
+const (
+	engineInputFile  = "file"  // Write the data to temporary files (default)
+	engineInputStdin = "stdin" // Stream the WFP through stdin (/dev/stdin) and anything else through pipes
+	engineInputPipe  = "pipe"  // Stream all data through anonymous pipes (/dev/fd/N)
+)
+
+// errEngineInput is returned when the data cannot be streamed to the engine (and temporary files should be used instead).
+var errEngineInput = errors.New("failed to set up engine input streaming")
+
+// streamEngineInput reports if WFP/SBOM data should be streamed to the engine rather than written to temporary files.
+func streamEngineInput(config *myconfig.ServerConfig) bool {
+	return config.Scanning.EngineInput == engineInputStdin || config.Scanning.EngineInput == engineInputPipe
+}
+
+// engineInputs holds data to stream to an engine process, instead of writing it to temporary files.
+type engineInputs struct {
+	mode    string
+	stdin   []byte     // Data to send to the process standard input
+	pipes   [][]byte   // Data to send through inherited pipes (/dev/fd/3 onwards)
+	readers []*os.File // Pipe ends passed to the process
+	writers []*os.File // Pipe ends used to write the data
+}
+
+// add registers data to stream to the engine and returns the path the engine should read it from.
+func (in *engineInputs) add(data []byte) string {
+	if in.mode == engineInputStdin && in.stdin == nil {
+		in.stdin = data
+		return "/dev/stdin"
+	}
+	in.pipes = append(in.pipes, data)
+	return fmt.Sprintf("/dev/fd/%d", 2+len(in.pipes)) // Extra files start after stdin, stdout & stderr
+}
+
+// open creates the pipes needed to stream the data. They must be released with close once the command has finished.
+func (in *engineInputs) open() error {
+	for range in.pipes {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			in.close()
+			return fmt.Errorf("%w: %v", errEngineInput, err)
+		}
+		in.readers = append(in.readers, reader)
+		in.writers = append(in.writers, writer)
+	}
+	return nil
+}
+
+// close releases any pipes still held open.
+func (in *engineInputs) close() {
+	for _, f := range append(in.readers, in.writers...) {
+		_ = f.Close() // Pipe ends may already be closed
+	}
+}
+
+// output runs the command, streaming the data into it, and returns its standard output.
+func (in *engineInputs) output(cmd *exec.Cmd) ([]byte, error) {
+	if in.stdin != nil {
+		cmd.Stdin = bytes.NewReader(in.stdin)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.ExtraFiles = in.readers
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	for _, reader := range in.readers {
+		_ = reader.Close() // The engine has its own copy now
+	}
+	var wg sync.WaitGroup
+	for i, writer := range in.writers {
+		wg.Add(1)
+		go func(writer *os.File, data []byte) {
+			defer wg.Done()
+			defer writer.Close()
+			_, _ = writer.Write(data) // Fails if the engine exits without reading everything, which Wait reports
+		}(writer, in.pipes[i])
+	}
+	err := cmd.Wait()
+	wg.Wait()
+	return stdout.Bytes(), err
+}
+
+// writeTempFile writes the given data into a new temporary file.
+func writeTempFile(dir, pattern string, data []byte, zs *zap.SugaredLogger) (*os.File, error) {
+	tempFile, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		zs.Errorf("Failed to create temporary file: %v", err)
+		return nil, err
+	}
+	_, err = tempFile.Write(data)
+	if err != nil {
+		zs.Errorf("Failed to write to temporary file: %v - %v", tempFile.Name(), err)
+		closeFile(tempFile, zs)
+		removeFile(tempFile, zs)
+		return nil, err
+	}
+	closeFile(tempFile, zs)
+	return tempFile, nil
+}
+
+// saveFailedWfp writes the WFP of a failed streamed scan to disk, so that it can be investigated later.
+func saveFailedWfp(wfpLoc, wfp string, zs *zap.SugaredLogger) string {
+	tempFile, err := writeTempFile(wfpLoc, "failed-finger*.wfp", []byte(wfp+"\n"), zs)
+	if err != nil {
+		return ""
+	}
+	zs.Warnf("Saved failed WFP to: %v", tempFile.Name())
+	return tempFile.Name()
+}
@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineInputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		command string
+		paths   []string
+		want    string
+	}{
+		{name: "stdin", mode: engineInputStdin, command: "cat /dev/stdin /dev/fd/3", paths: []string{"/dev/stdin", "/dev/fd/3"}, want: "first\nsecond\n"},
+		{name: "pipes", mode: engineInputPipe, command: "cat /dev/fd/4 /dev/fd/3", paths: []string{"/dev/fd/3", "/dev/fd/4"}, want: "second\nfirst\n"},
+		{name: "unread input", mode: engineInputPipe, command: "echo done", paths: []string{"/dev/fd/3", "/dev/fd/4"}, want: "done\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs := &engineInputs{mode: tt.mode}
+			assert.Equal(t, tt.paths[0], inputs.add([]byte("first\n")))
+			assert.Equal(t, tt.paths[1], inputs.add([]byte("second\n")))
+			assert.NoError(t, inputs.open())
+			defer inputs.close()
+			output, err := inputs.output(exec.Command("sh", "-c", tt.command))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(output))
+		})
+	}
+	// An engine exiting without reading a large input should not block the writers
+	inputs := &engineInputs{mode: engineInputPipe}
+	inputs.add(bytes.Repeat([]byte("x"), 1024*1024))
+	assert.NoError(t, inputs.open())
+	defer inputs.close()
+	_, err := inputs.output(exec.Command("sh", "-c", "exit 1"))
+	assert.Error(t, err)
+}
+
+func TestSubprocessEngineStreamedInput(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	for _, mode := range []string{engineInputStdin, engineInputPipe} {
+		t.Run(mode, func(t *testing.T) {
+			myConfig := setupConfig(t)
+			myConfig.Scanning.WfpLoc = t.TempDir()
+			myConfig.Scanning.TmpFileDelete = false // Any temporary files would be left behind
+			myConfig.Scanning.EngineInput = mode
+			engine := NewSubprocessEngine(myConfig)
+			ctx := context.Background()
+			config := DefaultScanningServiceConfig(myConfig)
+			config.sbomType = sbomIdentify
+			config.sbomFile = `{"components":[{"purl":"pkg:github/scanoss/engine"}]}`
+
+			result, timedOut, err := engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", "", config, zlog.S)
+			assert.NoError(t, err)
+			assert.False(t, timedOut)
+			assert.Contains(t, result, "kb_version")
+			output, err := engine.Attribution(ctx, []byte(config.sbomFile), zlog.S)
+			assert.NoError(t, err)
+			assert.Contains(t, string(output), "attribution: /dev/")
+			entries, err := os.ReadDir(myConfig.Scanning.WfpLoc)
+			assert.NoError(t, err)
+			assert.Empty(t, entries)
+
+			// Failed WFPs can still be kept for investigation
+			myConfig.Scanning.KeepFailedWfps = true
+			config.dbName = "test_kb"
+			_, _, err = engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", "", config, zlog.S)
+			assert.Error(t, err)
+			failed, err := filepath.Glob(filepath.Join(myConfig.Scanning.WfpLoc, "failed-finger*.wfp"))
+			assert.NoError(t, err)
+			if assert.Len(t, failed, 1) {
+				contents, err := os.ReadFile(failed[0])
+				assert.NoError(t, err)
+				assert.Equal(t, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n", string(contents))
+			}
+		})
+	}
+}
+
+func TestSubprocessEngineSbomTempFile(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.WfpLoc = t.TempDir()
+	myConfig.Scanning.TmpFileDelete = false
+	engine := NewSubprocessEngine(myConfig)
+	config := DefaultScanningServiceConfig(myConfig)
+	config.sbomType = sbomBlackList
+	config.sbomFile = `{"components":[]}`
+	// SBOM contents without a file are written to a temporary file for the engine
+	_, _, err = engine.Scan(context.Background(), "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", "", config, zlog.S)
+	assert.NoError(t, err)
+	sboms, err := filepath.Glob(filepath.Join(myConfig.Scanning.WfpLoc, "sbom*.json"))
+	assert.NoError(t, err)
+	if assert.Len(t, sboms, 1) {
+		contents, err := os.ReadFile(sboms[0])
+		assert.NoError(t, err)
+		assert.Equal(t, config.sbomFile+"\n", string(contents))
+	}
+	_, err = writeTempFile(filepath.Join(myConfig.Scanning.WfpLoc, "missing"), "test*.txt", []byte("test"), zlog.S)
+	assert.Error(t, err)
+}
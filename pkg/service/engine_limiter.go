@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errEngineBusy is returned when no engine process is available to service a request.
+var errEngineBusy = errors.New("engine busy")
+
+// engineLimiter limits the number of engine processes running at once across the whole server.
+// Requests waiting for a free process are queued, up to a maximum depth and wait time.
+type engineLimiter struct {
+	slots       chan struct{}
+	waiting     atomic.Int64
+	maxQueue    int64
+	waitTimeout time.Duration
+}
+
+// newEngineLimiter creates a limiter allowing maxProcesses concurrent engine processes and maxQueue waiting requests.
+func newEngineLimiter(maxProcesses, maxQueue int, waitTimeout time.Duration) *engineLimiter {
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	return &engineLimiter{slots: make(chan struct{}, maxProcesses), maxQueue: int64(maxQueue), waitTimeout: waitTimeout}
+}
+
+// engineAdmittedKey marks the context of a request admitted by the engine limiter (see admit).
+type engineAdmittedKey struct{}
+
+// withEngineAdmission returns a context marking the request as admitted, so that its engine calls are never rejected.
+func withEngineAdmission(ctx context.Context) context.Context {
+	return context.WithValue(ctx, engineAdmittedKey{}, true)
+}
+
+// admit decides once whether to accept a request (which may make several engine calls, i.e. one per scan chunk).
+// It is rejected if the engine queue is already full. Otherwise, the returned context lets each of its engine calls
+// wait for a free process for as long as the request lasts, rather than some of them failing part way through.
+func (l *engineLimiter) admit(ctx context.Context) (context.Context, bool) {
+	if l == nil {
+		return ctx, true
+	}
+	if l.full() {
+		return ctx, false
+	}
+	return withEngineAdmission(ctx), true
+}
+
+// acquire waits for a free engine process slot. It fails straight away if the queue is full,
+// or after waiting for the configured timeout, unless the request has been admitted (see admit).
+func (l *engineLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		oltpMetrics.engineQueueWaitHistogram.Record(ctx, 0)
+		return nil
+	default:
+	}
+	admitted, _ := ctx.Value(engineAdmittedKey{}).(bool)
+	if l.waiting.Add(1) > l.maxQueue && !admitted {
+		l.waiting.Add(-1)
+		return fmt.Errorf("%w: engine queue is full", errEngineBusy)
+	}
+	oltpMetrics.engineQueueDepth.Add(ctx, 1)
+	defer func() {
+		l.waiting.Add(-1)
+		oltpMetrics.engineQueueDepth.Add(ctx, -1)
+	}()
+	start := time.Now()
+	var timeout <-chan time.Time
+	if !admitted {
+		timer := time.NewTimer(l.waitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		oltpMetrics.engineQueueWaitHistogram.Record(ctx, time.Since(start).Milliseconds())
+		return nil
+	case <-timeout:
+		return fmt.Errorf("%w: timed out after %v waiting for a free engine process", errEngineBusy, l.waitTimeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees up an engine process slot.
+func (l *engineLimiter) release() {
+	<-l.slots
+}
+
+// full reports if all the engine processes are busy and the queue is at its maximum depth.
+func (l *engineLimiter) full() bool {
+	return len(l.slots) == cap(l.slots) && l.waiting.Load() >= l.maxQueue
+}
+
+// retryAfter returns the number of seconds clients should wait before retrying a rejected request.
+func (l *engineLimiter) retryAfter() int {
+	return max(1, int(l.waitTimeout.Seconds()))
+}
+
+// limitedEngine wraps an Engine, making sure every call holds a process slot from the limiter.
+type limitedEngine struct {
+	engine  Engine
+	limiter *engineLimiter
+}
+
+// Scan waits for a free engine process and runs the scan.
+// Chunks of an admitted scan request wait until a process is free (or the request is cancelled).
+func (e *limitedEngine) Scan(ctx context.Context, wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, bool, error) {
+	if err := e.limiter.acquire(ctx); err != nil {
+		zs.Warnf("Failed to get an engine process for scanning: %v", err)
+		return "", false, err
+	}
+	defer e.limiter.release()
+	return e.engine.Scan(ctx, wfp, sbomFile, config, zs)
+}
+
+// FileContents waits for a free engine process and retrieves the file contents.
+func (e *limitedEngine) FileContents(ctx context.Context, md5 string, zs *zap.SugaredLogger) ([]byte, error) {
+	if err := e.limiter.acquire(ctx); err != nil {
+		zs.Warnf("Failed to get an engine process for file contents: %v", err)
+		return nil, err
+	}
+	defer e.limiter.release()
+	return e.engine.FileContents(ctx, md5, zs)
+}
+
+// LicenseDetails waits for a free engine process and retrieves the license details.
+func (e *limitedEngine) LicenseDetails(ctx context.Context, license string, zs *zap.SugaredLogger) ([]byte, error) {
+	if err := e.limiter.acquire(ctx); err != nil {
+		zs.Warnf("Failed to get an engine process for license details: %v", err)
+		return nil, err
+	}
+	defer e.limiter.release()
+	return e.engine.LicenseDetails(ctx, license, zs)
+}
+
+// Attribution waits for a free engine process and retrieves the attribution notices.
+func (e *limitedEngine) Attribution(ctx context.Context, sbom []byte, zs *zap.SugaredLogger) ([]byte, error) {
+	if err := e.limiter.acquire(ctx); err != nil {
+		zs.Warnf("Failed to get an engine process for attribution: %v", err)
+		return nil, err
+	}
+	defer e.limiter.release()
+	return e.engine.Attribution(ctx, sbom, zs)
+}
+
+// Version waits for a free engine process and retrieves the engine version.
+func (e *limitedEngine) Version(ctx context.Context, zs *zap.SugaredLogger) (string, error) {
+	if err := e.limiter.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer e.limiter.release()
+	return e.engine.Version(ctx, zs)
+}
+
+// Health waits for a free engine process and checks the engine health.
+func (e *limitedEngine) Health(ctx context.Context, zs *zap.SugaredLogger) error {
+	if err := e.limiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer e.limiter.release()
+	return e.engine.Health(ctx, zs)
+}
+
+// Close releases any resources held by the wrapped engine.
+func (e *limitedEngine) Close() {
+	if closer, ok := e.engine.(engineCloser); ok {
+		closer.Close()
+	}
+}
+
+// writeEngineBusy responds with a 503 (Service Unavailable), letting the client know when to retry.
+func (s APIService) writeEngineBusy(w http.ResponseWriter, zs *zap.SugaredLogger) {
+	zs.Warnf("Rejecting request. No engine processes available")
+	retryAfter := 1
+	if s.limiter != nil {
+		retryAfter = s.limiter.retryAfter()
+	}
+	w.Header().Set(RetryAfterKey, strconv.Itoa(retryAfter))
+	writeError(w, codeEngineBusy, "engine busy, please retry later", nil, zs)
+}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineLimiter(t *testing.T) {
+	setupMetrics()
+	ctx := context.Background()
+	limiter := newEngineLimiter(1, 1, 100*time.Millisecond)
+	assert.False(t, limiter.full())
+	assert.NoError(t, limiter.acquire(ctx))
+	assert.False(t, limiter.full()) // all slots busy, but there is still room in the queue
+
+	waited := make(chan error)
+	go func() { waited <- limiter.acquire(ctx) }()
+	assert.Eventually(t, limiter.full, time.Second, time.Millisecond)
+	err := limiter.acquire(ctx) // queue is full
+	assert.True(t, errors.Is(err, errEngineBusy))
+	err = <-waited // queued request times out
+	assert.True(t, errors.Is(err, errEngineBusy))
+	assert.False(t, limiter.full())
+
+	go func() { waited <- limiter.acquire(ctx) }()
+	assert.Eventually(t, limiter.full, time.Second, time.Millisecond)
+	limiter.release()
+	assert.NoError(t, <-waited) // queued request gets the released slot
+	limiter.release()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	assert.NoError(t, limiter.acquire(ctx))
+	go func() { waited <- limiter.acquire(cancelled) }()
+	assert.Eventually(t, limiter.full, time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-waited, context.Canceled)
+	limiter.release()
+	assert.Equal(t, 1, limiter.retryAfter())
+
+	// Admitted requests wait for a free slot, even when the queue is full, for as long as they last
+	assert.NoError(t, limiter.acquire(ctx))
+	admitted, ok := limiter.admit(ctx)
+	assert.True(t, ok)
+	go func() { waited <- limiter.acquire(admitted) }()
+	assert.Eventually(t, limiter.full, time.Second, time.Millisecond)
+	_, ok = limiter.admit(ctx)
+	assert.False(t, ok)                // No new requests while the queue is full
+	time.Sleep(200 * time.Millisecond) // Past the wait timeout
+	limiter.release()
+	assert.NoError(t, <-waited)
+	limiter.release()
+	var noLimiter *engineLimiter
+	_, ok = noLimiter.admit(ctx)
+	assert.True(t, ok)
+}
+
+func TestEngineLimiterAdmission(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.EngineMaxProcesses = 1
+	myConfig.Scanning.EngineQueueSize = 0
+	myConfig.Scanning.EngineQueueTimeout = 5
+	engine := &fakeEngine{block: make(chan struct{})}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,test.py\n4=d5e54c33"
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		apiService.ScanDirect(first, newScanReq(t, "http://localhost/scan/direct", wfp, nil))
+		close(done)
+	}()
+	assert.Eventually(t, apiService.limiter.full, time.Second, time.Millisecond)
+
+	w := httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "http://localhost/scan/direct", wfp, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+	assert.Equal(t, "5", w.Result().Header.Get(RetryAfterKey))
+
+	w = httptest.NewRecorder()
+	apiService.FileContents(w, newReq("GET", "http://localhost/file_contents/{md5}", "", map[string]string{"md5": "37f7cd1e657aa3c30ece35995b4c59e5"}))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+	assert.Equal(t, "5", w.Result().Header.Get(RetryAfterKey))
+
+	w = httptest.NewRecorder()
+	apiService.LicenseDetails(w, newReq("GET", "http://localhost/license/obligations/{license}", "", map[string]string{"license": "MIT"}))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+
+	close(engine.block)
+	<-done
+	assert.Equal(t, http.StatusOK, first.Result().StatusCode)
+	w = httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "http://localhost/scan/direct", wfp, nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestEngineLimiterBusyChunks(t *testing.T) {
+	failures := []scanFailure{{File: "a.py", Reason: failureEngineBusy}, {File: "b.py", Reason: failureEngineBusy}}
+	assert.Equal(t, http.StatusServiceUnavailable, noResultsError(failures).status)
+	failures = append(failures, scanFailure{File: "c.py", Reason: failureTimeout})
+	assert.Equal(t, http.StatusInternalServerError, noResultsError(failures).status)
+	assert.Equal(t, http.StatusInternalServerError, noResultsError(nil).status)
+}
+
+func TestEngineLimiterAdmittedChunks(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.Workers = 2
+	myConfig.Scanning.WfpGrouping = 1
+	myConfig.Scanning.EngineMaxProcesses = 1
+	myConfig.Scanning.EngineQueueSize = 0
+	myConfig.Scanning.EngineQueueTimeout = 1
+	engine := &fakeEngine{block: make(chan struct{})}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=d5e54c33\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,b.py"
+
+	// Both chunks of the admitted request get an engine process in turn, rather than one being rejected as busy
+	time.AfterFunc(100*time.Millisecond, func() { close(engine.block) })
+	w := httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "http://localhost/scan/direct", wfp, nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Empty(t, w.Result().Header.Get(ScanFailedFilesKey))
+	body, _ := io.ReadAll(w.Result().Body)
+	assert.Contains(t, string(body), "a.py")
+	assert.Contains(t, string(body), "b.py")
+}
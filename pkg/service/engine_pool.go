@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"time"
+
+	"go.uber.org/zap"
+	myconfig "scanoss.com/go-api/pkg/config"
+)
+
+// Framing protocol used to talk to persistent engine workers.
+//
+// Each request is a single line JSON header (poolRequest), followed by exactly 'length' bytes of WFP data.
+// Each response is a single line JSON header (poolResponse), followed by exactly 'length' bytes of scan results.
+// The same protocol is used over the stdin/stdout of a long-lived engine process or a local unix socket.
+
+// poolRequest is the header sent to a persistent engine worker.
+type poolRequest struct {
+	Op     string   `json:"op"`
+	Args   []string `json:"args,omitempty"`
+	Length int      `json:"length"`
+}
+
+// poolResponse is the header received from a persistent engine worker.
+type poolResponse struct {
+	Status string `json:"status"`
+	Length int    `json:"length"`
+	Error  string `json:"error,omitempty"`
+}
+
+// errPoolEngine is returned when the engine reports a failure through the framing protocol.
+var errPoolEngine = errors.New("engine worker reported an error")
+
+// poolConn is a single connection to a persistent engine worker (process or socket).
+type poolConn struct {
+	reader *bufio.Reader
+	writer io.Writer
+	closer io.Closer
+	cmd    *exec.Cmd
+}
+
+// close shuts down the worker connection (and process if there is one).
+func (c *poolConn) close() {
+	_ = c.closer.Close()
+	if c.cmd != nil {
+		_ = c.cmd.Process.Kill()
+		_ = c.cmd.Wait()
+	}
+}
+
+// request sends a single framed request to the worker and reads back the framed response.
+func (c *poolConn) request(req poolRequest, payload []byte) ([]byte, error) {
+	header, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal worker request: %w", err)
+	}
+	if _, err = c.writer.Write(append(append(header, '\n'), payload...)); err != nil {
+		return nil, fmt.Errorf("failed to send worker request: %w", err)
+	}
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read worker response: %w", err)
+	}
+	var resp poolResponse
+	if err = json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse worker response header: %w", err)
+	}
+	if resp.Length < 0 {
+		return nil, fmt.Errorf("invalid worker response length: %v", resp.Length)
+	}
+	body := make([]byte, resp.Length)
+	if _, err = io.ReadFull(c.reader, body); err != nil {
+		return nil, fmt.Errorf("failed to read worker response body: %w", err)
+	}
+	if resp.Status != "ok" {
+		return body, fmt.Errorf("%w: %s", errPoolEngine, resp.Error)
+	}
+	return body, nil
+}
+
+// PoolEngine implements Engine using a pool of persistent engine workers for scanning, to avoid forking a process per chunk.
+// Workers are either long-lived engine processes (talking over stdin/stdout) or connections to a local unix socket.
+// All other operations, and any scan which cannot be sent to a worker, use the fallback engine.
+type PoolEngine struct {
+	config   *myconfig.ServerConfig
+	fallback *SubprocessEngine
+	conns    chan *poolConn // nil entries are workers which need (re)starting
+}
+
+// NewPoolEngine creates an engine with a pool of persistent workers, falling back to running a process per request.
+func NewPoolEngine(config *myconfig.ServerConfig, fallback *SubprocessEngine) *PoolEngine {
+	size := max(1, config.Scanning.EnginePoolSize)
+	conns := make(chan *poolConn, size)
+	for i := 0; i < size; i++ {
+		conns <- nil // Workers are started on first use
+	}
+	return &PoolEngine{config: config, fallback: fallback, conns: conns}
+}
+
+// dial starts a new persistent worker process or connects to the engine socket.
+func (p *PoolEngine) dial(zs *zap.SugaredLogger) (*poolConn, error) {
+	if socket := p.config.Scanning.EnginePoolSocket; len(socket) > 0 {
+		zs.Debugf("Connecting to engine socket: %v", socket)
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to engine socket %v: %w", socket, err)
+		}
+		return &poolConn{reader: bufio.NewReader(conn), writer: conn, closer: conn}, nil
+	}
+	binary := p.config.Scanning.ScanBinary
+	args := p.config.Scanning.EnginePoolArgs
+	zs.Debugf("Starting persistent engine worker: %v %v", binary, args)
+	//nolint:gosec
+	cmd := exec.Command(binary, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create engine worker stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create engine worker stdout: %w", err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start engine worker: %w", err)
+	}
+	return &poolConn{reader: bufio.NewReader(stdout), writer: stdin, closer: stdin, cmd: cmd}, nil
+}
+
+// Scan sends the WFP to a persistent engine worker. If no worker can be used, the fallback engine is used instead.
+func (p *PoolEngine) Scan(ctx context.Context, wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, bool, error) {
+	if len(sbomFile) == 0 && len(config.sbomFile) > 0 && len(config.sbomType) > 0 {
+		return p.fallback.Scan(ctx, wfp, sbomFile, config, zs) // Only the WFP can be framed, so SBOM contents need a scan process
+	}
+	var conn *poolConn
+	select {
+	case conn = <-p.conns:
+	case <-ctx.Done():
+		return "", false, ctx.Err()
+	}
+	if conn == nil {
+		var err error
+		if conn, err = p.dial(zs); err != nil {
+			p.conns <- nil
+			zs.Warnf("Engine worker unavailable, falling back to a scan process: %v", err)
+			return p.fallback.Scan(ctx, wfp, sbomFile, config, zs)
+		}
+	}
+	args := p.fallback.baseArgs(config.dbName)
+	args = append(args, p.fallback.scanArgs(sbomFile, config)...)
+	timeout := time.Duration(p.config.Scanning.ScanTimeout) * time.Second
+	type response struct {
+		output []byte
+		err    error
+	}
+	done := make(chan response, 1)
+	go func() {
+		output, err := conn.request(poolRequest{Op: "scan", Args: args, Length: len(wfp) + 1}, []byte(wfp+"\n"))
+		done <- response{output: output, err: err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-done:
+		if resp.err == nil {
+			p.conns <- conn
+			return string(resp.output), false, nil
+		}
+		if errors.Is(resp.err, errPoolEngine) { // The engine failed the scan, but the worker is still usable
+			p.conns <- conn
+			zs.Errorf("Engine worker scan failed: %v - %s", resp.err, resp.output)
+			return "", false, fmt.Errorf("failed to scan WFP: %w", resp.err)
+		}
+		conn.close()
+		p.conns <- nil
+		zs.Warnf("Engine worker failed, falling back to a scan process: %v", resp.err)
+		return p.fallback.Scan(ctx, wfp, sbomFile, config, zs)
+	case <-timer.C:
+		conn.close() // The worker is in an unknown state, so throw it away
+		p.conns <- nil
+		zs.Errorf("Engine worker scan timed out after %v", timeout)
+		return "", true, fmt.Errorf("failed to scan WFP: engine worker timed out after %v", timeout)
+	}
+}
+
+// FileContents retrieves the file contents using the fallback engine.
+func (p *PoolEngine) FileContents(ctx context.Context, md5 string, zs *zap.SugaredLogger) ([]byte, error) {
+	return p.fallback.FileContents(ctx, md5, zs)
+}
+
+// LicenseDetails retrieves the license details using the fallback engine.
+func (p *PoolEngine) LicenseDetails(ctx context.Context, license string, zs *zap.SugaredLogger) ([]byte, error) {
+	return p.fallback.LicenseDetails(ctx, license, zs)
+}
+
+// Attribution retrieves the attribution notices using the fallback engine.
+func (p *PoolEngine) Attribution(ctx context.Context, sbom []byte, zs *zap.SugaredLogger) ([]byte, error) {
+	return p.fallback.Attribution(ctx, sbom, zs)
+}
+
+// Version retrieves the engine version using the fallback engine.
+func (p *PoolEngine) Version(ctx context.Context, zs *zap.SugaredLogger) (string, error) {
+	return p.fallback.Version(ctx, zs)
+}
+
+// Health checks the engine health using the fallback engine.
+func (p *PoolEngine) Health(ctx context.Context, zs *zap.SugaredLogger) error {
+	return p.fallback.Health(ctx, zs)
+}
+
+// Close shuts down all the idle persistent engine workers.
+func (p *PoolEngine) Close() {
+	for {
+		select {
+		case conn := <-p.conns:
+			if conn != nil {
+				conn.close()
+			}
+		default:
+			return
+		}
+	}
+}
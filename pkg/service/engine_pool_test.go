@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+var workerRegex = regexp.MustCompile(`"worker": "(\d+)"`)
+
+// workerID returns the process ID of the simulated engine worker which produced the result.
+func workerID(t *testing.T, result string) string {
+	t.Helper()
+	matches := workerRegex.FindStringSubmatch(result)
+	if len(matches) < 2 {
+		t.Fatalf("no worker ID found in result: %v", result)
+	}
+	return matches[1]
+}
+
+func TestPoolEngineProcesses(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanBinary = "../../test-support/scanoss-pool.sh"
+	myConfig.Scanning.EnginePoolSize = 1
+	myConfig.Scanning.EnginePoolArgs = []string{"--stdio"}
+	engine := NewPoolEngine(myConfig, NewSubprocessEngine(myConfig))
+	defer engine.Close()
+	ctx := context.Background()
+	config := DefaultScanningServiceConfig(myConfig)
+
+	result, timedOut, err := engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,b.py", "", config, zlog.S)
+	assert.NoError(t, err)
+	assert.False(t, timedOut)
+	assert.Contains(t, result, `"a.py":[{"id": "none"`)
+	assert.Contains(t, result, `"b.py":[{"id": "none"`)
+	worker := workerID(t, result)
+
+	result, _, err = engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,c.py", "", config, zlog.S)
+	assert.NoError(t, err)
+	assert.Equal(t, worker, workerID(t, result)) // the same worker process is reused
+
+	_, timedOut, err = engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,engine-error.py", "", config, zlog.S)
+	assert.Error(t, err)
+	assert.False(t, timedOut)
+	result, _, err = engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,d.py", "", config, zlog.S)
+	assert.NoError(t, err)
+	assert.Equal(t, worker, workerID(t, result)) // engine errors keep the worker
+
+	// A crashed worker falls back to a scan process and is restarted on the next request
+	result, _, err = engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,worker-crash.py", "", config, zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, result, "kb_version")
+	result, _, err = engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,e.py", "", config, zlog.S)
+	assert.NoError(t, err)
+	assert.NotEqual(t, worker, workerID(t, result))
+
+	// Everything other than scanning uses the fallback engine
+	version, err := engine.Version(ctx, zlog.S)
+	assert.NoError(t, err)
+	assert.Equal(t, "scanoss-5.4.20", version)
+	assert.NoError(t, engine.Health(ctx, zlog.S))
+	contents, err := engine.FileContents(ctx, "37f7cd1e657aa3c30ece35995b4c59e5", zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "37f7cd1e657aa3c30ece35995b4c59e5")
+	license, err := engine.LicenseDetails(ctx, "MIT", zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, string(license), `"MIT"`)
+	attribution, err := engine.Attribution(ctx, []byte(`{"components":[]}`), zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, string(attribution), "attribution:")
+}
+
+// serveEngineSocket runs a simulated persistent engine on the given unix socket, echoing the request args back in the result.
+func serveEngineSocket(t *testing.T, listener net.Listener) {
+	t.Helper()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			for {
+				line, err := reader.ReadBytes('\n')
+				if err != nil {
+					return
+				}
+				var req poolRequest
+				if err = json.Unmarshal(line, &req); err != nil {
+					return
+				}
+				wfp := make([]byte, req.Length)
+				if _, err = io.ReadFull(reader, wfp); err != nil {
+					return
+				}
+				paths := wfpFilePaths(string(wfp))
+				result := fmt.Sprintf(`{"%s":[{"id":"none","args":"%s"}]}`, strings.Join(paths, ","), strings.Join(req.Args, " "))
+				_, _ = fmt.Fprintf(conn, "{\"status\":\"ok\",\"length\":%d}\n%s", len(result), result)
+			}
+		}(conn)
+	}
+}
+
+func TestPoolEngineSocket(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	socket := filepath.Join(t.TempDir(), "engine.sock")
+	myConfig := setupConfig(t)
+	myConfig.Scanning.EnginePoolSize = 2
+	myConfig.Scanning.EnginePoolSocket = socket
+	engine := NewPoolEngine(myConfig, NewSubprocessEngine(myConfig))
+	defer engine.Close()
+	config := DefaultScanningServiceConfig(myConfig)
+	config.flags = 256
+	config.dbName = "test"
+
+	// No socket available yet, so the scan process fallback is used
+	result, _, err := engine.Scan(context.Background(), "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", "", config, zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, result, "kb_version")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("an error was not expected when listening on a socket: %v", err)
+	}
+	defer listener.Close()
+	go serveEngineSocket(t, listener)
+	result, timedOut, err := engine.Scan(context.Background(), "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", "", config, zlog.S)
+	assert.NoError(t, err)
+	assert.False(t, timedOut)
+	assert.Contains(t, result, `"a.py":[{"id":"none","args":"-d -ntest -F256`)
+
+	// SBOM contents (without a file) can't be framed, so use a scan process
+	sbomConfig := config
+	sbomConfig.sbomType = sbomIdentify
+	sbomConfig.sbomFile = `{"components":[]}`
+	result, _, err = engine.Scan(context.Background(), "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", "", sbomConfig, zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, result, "kb_version")
+
+	// Cancelled requests should not wait for a worker
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < cap(engine.conns); i++ {
+		<-engine.conns
+	}
+	_, _, err = engine.Scan(ctx, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", "", config, zlog.S)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAPIServiceEnginePool(t *testing.T) {
+	myConfig := setupConfig(t)
+	assert.IsType(t, &SubprocessEngine{}, NewAPIService(myConfig).engine)
+	myConfig.Scanning.EnginePoolSize = 2
+	assert.IsType(t, &PoolEngine{}, NewAPIService(myConfig).engine)
+	myConfig.Scanning.EngineMaxProcesses = 2
+	assert.IsType(t, &limitedEngine{}, NewAPIService(myConfig).engine)
+}
+
+func TestAPIServiceClose(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanBinary = "../../test-support/scanoss-pool.sh"
+	myConfig.Scanning.EnginePoolSize = 1
+	myConfig.Scanning.EnginePoolArgs = []string{"--stdio"}
+	myConfig.Scanning.EngineMaxProcesses = 1
+	apiService := NewAPIService(myConfig)
+	limited, _ := apiService.engine.(*limitedEngine)
+	pool, _ := limited.engine.(*PoolEngine)
+	_, _, err = apiService.engine.Scan(context.Background(), "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", "", DefaultScanningServiceConfig(myConfig), zlog.S)
+	assert.NoError(t, err)
+	conn := <-pool.conns
+	assert.NotNil(t, conn) // the worker is running
+	pool.conns <- conn
+
+	apiService.Close() // closes the workers through the engine limiter
+	assert.Empty(t, pool.conns)
+	assert.NotNil(t, conn.cmd.ProcessState) // the worker process has exited
+}
@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// fakeEngine is an in-process Engine implementation which records the calls made to it.
+type fakeEngine struct {
+	mu      sync.Mutex
+	calls   []string
+	scanErr error
+	failOn  string        // fail any scan containing this text
+	failFor int           // fail this many scans before succeeding
+	block   chan struct{} // block scans until closed (or cancelled)
+	missing string        // md5 with no file contents
+}
+
+// setScanErr makes all subsequent scans fail with the given error.
+func (f *fakeEngine) setScanErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scanErr = err
+}
+
+func (f *fakeEngine) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeEngine) Scan(ctx context.Context, wfp, _ string, _ ScanningServiceConfig, _ *zap.SugaredLogger) (string, bool, error) {
+	f.record("scan")
+	if f.block != nil {
+		select {
+		case <-f.block:
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	scanErr := f.scanErr
+	f.mu.Unlock()
+	if scanErr != nil {
+		return "", false, scanErr
+	}
+	f.mu.Lock()
+	failing := f.failFor > 0
+	f.failFor--
+	f.mu.Unlock()
+	if failing {
+		return "", true, fmt.Errorf("engine timed out")
+	}
+	if len(f.failOn) > 0 && strings.Contains(wfp, f.failOn) {
+		return "", false, fmt.Errorf("engine failure on %v", f.failOn)
+	}
+	var results []string
+	for _, line := range strings.Split(wfp, "\n") {
+		if strings.HasPrefix(line, "file=") {
+			parts := strings.SplitN(line, ",", 3)
+			results = append(results, fmt.Sprintf(`"%s":[{"id":"none"}]`, parts[len(parts)-1]))
+		}
+	}
+	return "{" + strings.Join(results, ",") + "}", false, nil
+}
+
+func (f *fakeEngine) FileContents(_ context.Context, md5 string, _ *zap.SugaredLogger) ([]byte, error) {
+	f.record("contents")
+	if md5 == f.missing {
+		return nil, nil
+	}
+	return []byte("contents of " + md5), nil
+}
+
+func (f *fakeEngine) LicenseDetails(_ context.Context, license string, _ *zap.SugaredLogger) ([]byte, error) {
+	f.record("license")
+	return []byte(fmt.Sprintf(`{"%s": {}}`, license)), nil
+}
+
+func (f *fakeEngine) Attribution(_ context.Context, sbom []byte, _ *zap.SugaredLogger) ([]byte, error) {
+	f.record("attribution")
+	return sbom, nil
+}
+
+func (f *fakeEngine) Version(_ context.Context, _ *zap.SugaredLogger) (string, error) {
+	f.record("version")
+	return "fake-1.0.0", nil
+}
+
+func (f *fakeEngine) Health(_ context.Context, _ *zap.SugaredLogger) error {
+	f.record("health")
+	return nil
+}
+
+func TestSubprocessEngine(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	engine := NewSubprocessEngine(myConfig)
+	ctx := context.Background()
+
+	ver, err := engine.Version(ctx, zlog.S)
+	assert.NoError(t, err)
+	assert.Equal(t, "scanoss-5.4.20", ver)
+	assert.NoError(t, engine.Health(ctx, zlog.S))
+	contents, err := engine.FileContents(ctx, "37f7cd1e657aa3c30ece35995b4c59e5", zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "37f7cd1e657aa3c30ece35995b4c59e5")
+	license, err := engine.LicenseDetails(ctx, "MIT", zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, string(license), `"MIT"`)
+	attribution, err := engine.Attribution(ctx, []byte(`{"components":[]}`), zlog.S)
+	assert.NoError(t, err)
+	assert.Contains(t, string(attribution), "attribution:")
+	result, timedOut, err := engine.Scan(ctx, "file=7c53a2de7dfeaa20d057db98468d6670,2321,path/to/file.txt", "",
+		DefaultScanningServiceConfig(myConfig), zlog.S)
+	assert.NoError(t, err)
+	assert.False(t, timedOut)
+	assert.Contains(t, result, "kb_version")
+
+	myConfig.Scanning.ScanBinary = ".scan-binary-does-not-exist.sh"
+	myConfig.Scanning.KeepFailedWfps = true
+	myConfig.Scanning.WfpLoc = t.TempDir() // Don't leave failed WFPs behind
+	_, err = engine.Version(ctx, zlog.S)
+	assert.Error(t, err)
+	assert.Error(t, engine.Health(ctx, zlog.S))
+	_, err = engine.FileContents(ctx, "37f7cd1e657aa3c30ece35995b4c59e5", zlog.S)
+	assert.Error(t, err)
+	_, err = engine.LicenseDetails(ctx, "MIT", zlog.S)
+	assert.Error(t, err)
+	_, err = engine.Attribution(ctx, []byte(`{"components":[]}`), zlog.S)
+	assert.Error(t, err)
+	_, timedOut, err = engine.Scan(ctx, "file=7c53a2de7dfeaa20d057db98468d6670,2321,path/to/file.txt", "",
+		DefaultScanningServiceConfig(myConfig), zlog.S)
+	assert.Error(t, err)
+	assert.False(t, timedOut)
+	failed, err := filepath.Glob(filepath.Join(myConfig.Scanning.WfpLoc, "failed-finger*.wfp"))
+	assert.NoError(t, err)
+	assert.Len(t, failed, 1)
+}
+
+func TestSubprocessEngineScanArgs(t *testing.T) {
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanDebug = true
+	myConfig.Scanning.RankingAllowed = true
+	engine := NewSubprocessEngine(myConfig)
+	config := DefaultScanningServiceConfig(myConfig)
+	config.flags = 256
+	config.sbomType = sbomBlackList
+	config.rankingEnabled = true
+	config.rankingThreshold = 5
+	config.minSnippetHits = 2
+	config.minSnippetLines = 3
+	config.honourFileExts = false
+	assert.Equal(t, []string{"-d", "-noss"}, engine.baseArgs("oss"))
+	assert.Equal(t, []string{"-F256", "-b", "sbom.json", "-r5", "--min-snippet-hits=2", "--min-snippet-lines=3", "--ignore-file-ext"},
+		engine.scanArgs("sbom.json", config))
+	myConfig.Scanning.ScanDebug = false
+	assert.Empty(t, engine.baseArgs(""))
+}
+
+func TestAPIServiceWithFakeEngine(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanBinary = ".scan-binary-does-not-exist.sh" // make sure the binary is never used
+	myConfig.Scanning.Workers = 2
+	myConfig.Scanning.WfpGrouping = 1
+	engine := &fakeEngine{}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	assert.NoError(t, apiService.TestEngine())
+
+	contents, err := os.ReadFile("./tests/fingers.wfp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newScanReq(t, "http://localhost/scan/direct", string(contents), nil)
+	w := httptest.NewRecorder()
+	apiService.ScanDirect(w, req)
+	resp := w.Result()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("an error was not expected when reading from request: %v", err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"scancodedeps-test.py":[{"id":"none"}]`)
+
+	engine.setScanErr(fmt.Errorf("engine failure"))
+	req = newScanReq(t, "http://localhost/scan/direct", "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,test.py\n6=d5e54c33", nil)
+	w = httptest.NewRecorder()
+	apiService.ScanDirect(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+
+	req = newReq("GET", "http://localhost/license/obligations/{license}", "", map[string]string{"license": "MIT"})
+	w = httptest.NewRecorder()
+	apiService.LicenseDetails(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Contains(t, engine.calls, "health")
+	assert.Contains(t, engine.calls, "version")
+	assert.Contains(t, engine.calls, "scan")
+	assert.Contains(t, engine.calls, "license")
+}
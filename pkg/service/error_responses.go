@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Error codes reported in the "code" field of error responses (and file contents batch items).
+// Clients rely on these, so once released a code must not be renamed or reused for a different error.
+// Each code is listed, with the status it is reported with, in the Error Responses section of the README.
+const (
+	codeInvalidRequest      = "invalid_request"       // Missing or invalid request parameters/contents
+	codeInvalidMD5          = "invalid_md5"           // Not an md5 (32 hex digits)
+	codeInvalidWfp          = "invalid_wfp"           // WFP which cannot be parsed
+	codeInvalidRange        = "invalid_range"         // Invalid lines range
+	codeInvalidEncoding     = "invalid_encoding"      // Unsupported charset requested, or a request body which cannot be decompressed
+	codeUnauthorized        = "unauthorized"          // Missing, invalid or expired API key/token
+	codeFeatureDisabled     = "feature_disabled"      // Disabled on this server (file contents or HPSM)
+	codeInsufficientScope   = "insufficient_scope"    // Caller not granted the scope the endpoint requires
+	codeNotFound            = "not_found"             // Unknown endpoint, scan job or file contents
+	codeMethodNotAllowed    = "method_not_allowed"    // HTTP method not supported by the endpoint
+	codeLimitExceeded       = "limit_exceeded"        // Request exceeds one of the configured limits
+	codeOverLimit           = "over_limit"            // File contents larger than the file contents limit
+	codeUnsupportedEncoding = "unsupported_encoding"  // Request body Content-Encoding other than gzip
+	codeRangeNotSatisfiable = "range_not_satisfiable" // Range or lines beyond the end of the file
+	codeRateLimited         = "rate_limited"          // Too many requests from the client
+	codeQuotaExceeded       = "quota_exceeded"        // Client's daily file quota used up
+	codeEngineError         = "engine_error"          // Engine failed to process the request
+	codeInternalError       = "internal_error"        // Unexpected failure in the service
+	codeEngineBusy          = "engine_busy"           // No engine processes available
+	codeQueueFull           = "queue_full"            // Scan job queue is full
+	codeEngineTimeout       = "engine_timeout"        // Engine took too long to respond
+)
+
+// errorCodes is the catalogue of error codes, with the HTTP status each is reported with.
+var errorCodes = map[string]int{
+	codeInvalidRequest:      http.StatusBadRequest,
+	codeInvalidMD5:          http.StatusBadRequest,
+	codeInvalidWfp:          http.StatusBadRequest,
+	codeInvalidRange:        http.StatusBadRequest,
+	codeInvalidEncoding:     http.StatusBadRequest,
+	codeUnauthorized:        http.StatusUnauthorized,
+	codeFeatureDisabled:     http.StatusForbidden,
+	codeInsufficientScope:   http.StatusForbidden,
+	codeNotFound:            http.StatusNotFound,
+	codeMethodNotAllowed:    http.StatusMethodNotAllowed,
+	codeLimitExceeded:       http.StatusRequestEntityTooLarge,
+	codeOverLimit:           http.StatusRequestEntityTooLarge,
+	codeUnsupportedEncoding: http.StatusUnsupportedMediaType,
+	codeRangeNotSatisfiable: http.StatusRequestedRangeNotSatisfiable,
+	codeRateLimited:         http.StatusTooManyRequests,
+	codeQuotaExceeded:       http.StatusTooManyRequests,
+	codeEngineError:         http.StatusInternalServerError,
+	codeInternalError:       http.StatusInternalServerError,
+	codeEngineBusy:          http.StatusServiceUnavailable,
+	codeQueueFull:           http.StatusServiceUnavailable,
+	codeEngineTimeout:       http.StatusGatewayTimeout,
+}
+
+// errorResponse is the JSON body returned by every failed request.
+type errorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+	Details   any    `json:"details,omitempty"`
+}
+
+// limitDetails describes the limit exceeded by a request (limit_exceeded).
+type limitDetails struct {
+	Limit string `json:"limit"`
+	Max   int64  `json:"max"`
+	Unit  string `json:"unit"`
+}
+
+// wfpDetails points at the line of an invalid WFP (invalid_wfp).
+type wfpDetails struct {
+	Line int `json:"line"`
+}
+
+// scopeDetails names the scope required by the endpoint (insufficient_scope).
+type scopeDetails struct {
+	Scope string `json:"scope"`
+}
+
+// failureDetails reports how many files failed to scan (engine_error).
+type failureDetails struct {
+	FailedFiles int `json:"failed_files"`
+}
+
+// writeError responds with the status of the given error code and a JSON error body.
+// The request ID reported is the one (from getReqID) already set in the response header.
+func writeError(w http.ResponseWriter, code, message string, details any, zs *zap.SugaredLogger) {
+	status, found := errorCodes[code]
+	if !found {
+		zs.Errorf("Unknown error code %v. Reporting it as %v", code, codeInternalError)
+		code, status = codeInternalError, http.StatusInternalServerError
+	}
+	writeErrorStatus(w, status, code, message, details, zs)
+}
+
+// writeErrorStatus responds with the given status and a JSON error body. Only needed for errors which carry
+// their own status (i.e. scan and range errors), otherwise use writeError.
+func writeErrorStatus(w http.ResponseWriter, status int, code, message string, details any, zs *zap.SugaredLogger) {
+	resp := errorResponse{Code: code, Message: message, RequestID: w.Header().Get(ResponseIDKey), Details: details}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false) // Keep messages (i.e. file=<md5>) readable
+	if err := encoder.Encode(resp); err != nil {
+		zs.Errorf("Failed to marshal error response details: %v", err)
+		data.Reset()
+		resp.Details = nil
+		_ = encoder.Encode(resp)
+	}
+	header := w.Header()
+	header.Del(ContentLengthKey) // Might have been set for the content the request failed to return
+	header.Set(ContentTypeKey, ApplicationJSON)
+	header.Set(ErrorCodeKey, code)
+	w.WriteHeader(status)
+	printResponse(w, data.String(), zs, true)
+}
+
+// NotFound responds with a not_found error for requests to unknown endpoints.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(ResponseIDKey, getReqID(r))
+	zlog.S.Debugf("%v request from %v to unknown endpoint %v", r.Method, r.RemoteAddr, r.URL.Path)
+	writeError(w, codeNotFound, "endpoint not found", nil, zlog.S)
+}
+
+// MethodNotAllowed responds with a method_not_allowed error for requests using a method the endpoint does not support.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(ResponseIDKey, getReqID(r))
+	zlog.S.Debugf("%v request from %v to %v not allowed", r.Method, r.RemoteAddr, r.URL.Path)
+	writeError(w, codeMethodNotAllowed, "method "+r.Method+" not allowed", nil, zlog.S)
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeError decodes an error response body, and its details into the given value (if not nil).
+func decodeError(t *testing.T, body []byte, details any) errorResponse {
+	t.Helper()
+	var resp struct {
+		errorResponse
+		Details json.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("invalid error response %q: %v", body, err)
+	}
+	if details != nil {
+		if err := json.Unmarshal(resp.Details, details); err != nil {
+			t.Fatalf("invalid error response details %q: %v", resp.Details, err)
+		}
+	}
+	return resp.errorResponse
+}
+
+func TestErrorCatalogue(t *testing.T) {
+	readme, err := os.ReadFile("../../README.md")
+	if err != nil {
+		t.Fatalf("failed to read the README: %v", err)
+	}
+	for code, status := range errorCodes {
+		assert.GreaterOrEqual(t, status, http.StatusBadRequest, code)
+		assert.Contains(t, string(readme), fmt.Sprintf("| `%s` | %d |", code, status), "error code %v is not documented in the README", code)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	w := httptest.NewRecorder()
+	w.Header().Set(ResponseIDKey, "req-1")
+	w.Header().Set(ContentLengthKey, "1000")
+	writeError(w, codeLimitExceeded, "request exceeds the maximum wfp_files (2 files)", limitDetails{Limit: limitWfpFiles, Max: 2, Unit: "files"}, zlog.S)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, ApplicationJSON, w.Header().Get(ContentTypeKey))
+	assert.Equal(t, codeLimitExceeded, w.Header().Get(ErrorCodeKey))
+	assert.Empty(t, w.Header().Get(ContentLengthKey))
+	assert.JSONEq(t, `{"code":"limit_exceeded","message":"request exceeds the maximum wfp_files (2 files)","request_id":"req-1",`+
+		`"details":{"limit":"wfp_files","max":2,"unit":"files"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	writeError(w, codeNotFound, "scan job not found", nil, zlog.S)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"code":"not_found","message":"scan job not found","request_id":""}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	writeError(w, "unknown", "something failed", nil, zlog.S) // Unknown codes are reported as internal errors
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, codeInternalError, decodeError(t, w.Body.Bytes(), nil).Code)
+}
+
+func TestErrorResponses(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.Workers = 1
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{})
+	failingService := NewAPIServiceWithEngine(myConfig, &fakeEngine{scanErr: errors.New("engine failure")})
+	disabledConfig := setupConfig(t)
+	disabledConfig.Scanning.FileContents = false
+	disabledService := NewAPIServiceWithEngine(disabledConfig, &fakeEngine{})
+	router := mux.NewRouter()
+	router.HandleFunc("/health", HealthCheck).Methods(http.MethodGet)
+	router.NotFoundHandler = http.HandlerFunc(NotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(MethodNotAllowed)
+	const md5 = "37f7cd1e657aa3c30ece35995b4c59e5"
+	contentsReq := func(query, md5 string) *http.Request {
+		return newReq(http.MethodGet, "/file_contents/{md5}"+query, "", map[string]string{"md5": md5})
+	}
+	gzipReq := newScanReq(t, "/scan/direct", "file="+md5+",3114,a.py\n", nil)
+	gzipReq.Header.Set(ContentEncodingKey, "br")
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+		status  int
+		code    string
+	}{
+		{name: "disabled", handler: disabledService.FileContents, req: contentsReq("", md5), status: http.StatusForbidden, code: codeFeatureDisabled},
+		{name: "invalid md5", handler: apiService.FileContents, req: contentsReq("", "abc"), status: http.StatusBadRequest, code: codeInvalidMD5},
+		{name: "invalid charset", handler: apiService.FileContents, req: contentsReq("?encoding=latin1", md5), status: http.StatusBadRequest, code: codeInvalidEncoding},
+		{name: "invalid lines", handler: apiService.FileContents, req: contentsReq("?lines=x", md5), status: http.StatusBadRequest, code: codeInvalidRange},
+		{name: "lines beyond the end", handler: apiService.FileContents, req: contentsReq("?lines=5", md5), status: http.StatusRequestedRangeNotSatisfiable,
+			code: codeRangeNotSatisfiable},
+		{name: "no license", handler: apiService.LicenseDetails, req: newReq(http.MethodGet, "/license/obligations/", "", nil), status: http.StatusBadRequest,
+			code: codeInvalidRequest},
+		{name: "invalid wfp", handler: apiService.ScanDirect, req: newScanReq(t, "/scan/direct", "file=abc,3114,a.py\n", nil), status: http.StatusBadRequest,
+			code: codeInvalidWfp},
+		{name: "engine failure", handler: failingService.ScanDirect, req: newScanReq(t, "/scan/direct", "file="+md5+",3114,a.py\n", nil),
+			status: http.StatusInternalServerError, code: codeEngineError},
+		{name: "unsupported encoding", handler: apiService.ScanDirect, req: gzipReq, status: http.StatusUnsupportedMediaType, code: codeUnsupportedEncoding},
+		{name: "unknown job", handler: apiService.ScanJobStatus, req: newReq(http.MethodGet, "/scan/jobs/{id}", "", map[string]string{"id": "unknown"}),
+			status: http.StatusNotFound, code: codeNotFound},
+		{name: "unknown metrics", handler: MetricsHandler, req: newReq(http.MethodGet, "/metrics/{type}", "", map[string]string{"type": "unknown"}),
+			status: http.StatusBadRequest, code: codeInvalidRequest},
+		{name: "unknown endpoint", handler: router.ServeHTTP, req: httptest.NewRequest(http.MethodGet, "/unknown", nil), status: http.StatusNotFound, code: codeNotFound},
+		{name: "method not allowed", handler: router.ServeHTTP, req: httptest.NewRequest(http.MethodPost, "/health", nil), status: http.StatusMethodNotAllowed,
+			code: codeMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Header.Set(RequestIDKey, "req-"+tt.name)
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Equal(t, ApplicationJSON, w.Header().Get(ContentTypeKey))
+			assert.Equal(t, tt.code, w.Header().Get(ErrorCodeKey))
+			resp := decodeError(t, w.Body.Bytes(), nil)
+			assert.Equal(t, tt.code, resp.Code)
+			assert.NotEmpty(t, resp.Message)
+			assert.Equal(t, "req-"+tt.name, resp.RequestID)
+			assert.Equal(t, resp.RequestID, w.Header().Get(ResponseIDKey))
+			assert.Equal(t, tt.status, errorCodes[resp.Code])
+		})
+	}
+	// Invalid WFPs point at the line with the problem
+	w := httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "/scan/direct", "file="+md5+",3114,a.py\n4=579a7cd6\n5=zz\n", nil))
+	var details wfpDetails
+	decodeError(t, w.Body.Bytes(), &details)
+	assert.Equal(t, 3, details.Line)
+}
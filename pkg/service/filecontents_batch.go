@@ -0,0 +1,268 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// emptyFileMD5 is the md5 of a file with no contents (which is not reported as missing).
+const emptyFileMD5 = "d41d8cd98f00b204e9800998ecf8427e"
+
+// MultipartMixed is the content type for batch responses sent as one part per file.
+const MultipartMixed = "multipart/mixed"
+
+// fileContentsBatchRequest is the JSON body of a file contents batch request.
+type fileContentsBatchRequest struct {
+	MD5s []string `json:"md5s"`
+}
+
+// fileContentsBatchError describes why a file in a batch could not be returned (using the error response codes).
+type fileContentsBatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// fileContentsBatchItem is the result for a single file in a batch.
+type fileContentsBatchItem struct {
+	MD5      string                  `json:"md5"`
+	Size     int64                   `json:"size"`
+	Charset  string                  `json:"charset,omitempty"`
+	Contents []byte                  `json:"contents,omitempty"` // Base64 encoded in JSON responses
+	Error    *fileContentsBatchError `json:"error,omitempty"`
+}
+
+// fileContentsBatchResponse is the JSON body of a file contents batch response.
+type fileContentsBatchResponse struct {
+	Files []fileContentsBatchItem `json:"files"`
+}
+
+// FileContentsBatch handles retrieval of several source files in a single request.
+// The md5s are looked up in parallel (up to SCANOSS_FILE_CONTENTS_BATCH_WORKERS at a time) and returned in the order requested,
+// either as JSON (default) or as a multipart/mixed response. Files which cannot be returned are reported with an error code.
+func (s APIService) FileContentsBatch(w http.ResponseWriter, r *http.Request) {
+	counters.incRequest("file_contents_batch")
+	reqID := getReqID(r)
+	w.Header().Set(ResponseIDKey, reqID)
+	var logContext context.Context
+	if s.config.Telemetry.Enabled {
+		_, logContext = getSpan(r.Context(), reqID)
+		oltpMetrics.fileContentsCounter.Add(logContext, 1)
+	} else {
+		logContext = requestContext(r.Context(), reqID, "", "")
+	}
+	zs := sugaredLogger(logContext) // Setup logger with context
+	logRequestDetails(r, zs)
+	if !s.config.Scanning.FileContents {
+		zs.Warn("File contents retrieval is disabled.")
+		writeError(w, codeFeatureDisabled, "file contents disabled", nil, zs)
+		return
+	}
+	s.limitRequestBody(w, r)
+	var batch fileContentsBatchRequest
+	err := json.NewDecoder(r.Body).Decode(&batch)
+	if isMaxBytesError(err) {
+		writeLimitExceeded(w, limitUploadSize, s.config.Scanning.MaxUploadSize, "MB", zs)
+		return
+	}
+	if err != nil {
+		zs.Errorf("Failed to parse file contents batch request: %v", err)
+		writeError(w, codeInvalidRequest, "invalid file contents batch request. Expected JSON: {\"md5s\": [...]}", nil, zs)
+		return
+	}
+	if len(batch.MD5s) == 0 {
+		zs.Errorf("No md5s submitted in file contents batch request")
+		writeError(w, codeInvalidRequest, "no md5s submitted", nil, zs)
+		return
+	}
+	maxFiles := s.config.Scanning.FileContentsBatchSize
+	if maxFiles > 0 && len(batch.MD5s) > maxFiles {
+		writeLimitExceeded(w, limitBatchSize, int64(maxFiles), "md5s", zs)
+		return
+	}
+	if !s.chargeQuota(w, r, s.contentsLimits, batchLookups(batch.MD5s), zs) { // Only files looked up count towards the quota
+		return
+	}
+	zs.Debugf("Retrieving contents for %v files", len(batch.MD5s))
+	items := s.fileContentsBatch(logContext, batch.MD5s, zs)
+	s.contentsLimits.refund(s.clientID(r), failedLookups(items)) // Files the engine failed to retrieve don't count towards the quota
+	if strings.Contains(r.Header.Get(AcceptKey), MultipartMixed) {
+		writeFileContentsMultipart(w, items, zs)
+		return
+	}
+	data, err := json.Marshal(fileContentsBatchResponse{Files: items})
+	if err != nil {
+		zs.Errorf("Failed to marshal file contents batch response: %v", err)
+		writeError(w, codeInternalError, "failed to produce file contents batch response", nil, zs)
+		return
+	}
+	w.Header().Set(ContentTypeKey, ApplicationJSON)
+	printResponse(w, string(data)+"\n", zs, false)
+}
+
+// batchLookups returns the number of distinct valid md5s in a batch (i.e. the number of files which need looking up).
+func batchLookups(md5s []string) int64 {
+	distinct := make(map[string]bool, len(md5s))
+	for _, md5 := range md5s {
+		if isMD5(md5) {
+			distinct[strings.ToLower(md5)] = true
+		}
+	}
+	return int64(len(distinct))
+}
+
+// fileContentsBatch retrieves the contents of each of the given md5s, running up to the configured number of lookups in parallel.
+// Each distinct md5 is only looked up once. Files which would take the response over the batch limit are reported as over_limit,
+// and no more lookups are started once the contents retrieved so far fill the response.
+func (s APIService) fileContentsBatch(ctx context.Context, md5s []string, zs *zap.SugaredLogger) []fileContentsBatchItem {
+	workers := max(s.config.Scanning.FileContentsBatchWorkers, 1)
+	limit := s.config.Scanning.FileContentsBatchLimit * 1024 * 1024
+	results := make(map[string]*fileContentsBatchItem, len(md5s))
+	occurrences := make(map[string]int64, len(md5s))
+	for _, md5 := range md5s {
+		occurrences[strings.ToLower(md5)]++
+	}
+	var fetched atomic.Int64 // Response size of the contents retrieved so far
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+	for _, md5 := range md5s {
+		key := strings.ToLower(md5)
+		if _, found := results[key]; found {
+			continue
+		}
+		item := &fileContentsBatchItem{MD5: key}
+		results[key] = item
+		if !isMD5(md5) {
+			item.MD5 = md5
+			item.Error = &fileContentsBatchError{Code: codeInvalidMD5, Message: fmt.Sprintf("invalid md5: %q", md5)}
+			continue
+		}
+		slots <- struct{}{}
+		if limit > 0 && fetched.Load() >= limit { // The response is already full, so don't retrieve any more contents
+			<-slots
+			item.Error = &fileContentsBatchError{Code: codeOverLimit,
+				Message: fmt.Sprintf("batch response limit (%d MB) reached", s.config.Scanning.FileContentsBatchLimit)}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			s.lookupBatchItem(ctx, item, zs)
+			if item.Error == nil {
+				fetched.Add(item.Size * occurrences[key]) // Duplicates are repeated in the response
+			}
+		}()
+	}
+	wg.Wait()
+	var total int64
+	items := make([]fileContentsBatchItem, 0, len(md5s))
+	for _, md5 := range md5s {
+		item := *results[strings.ToLower(md5)]
+		if limit > 0 && item.Error == nil {
+			if total+item.Size > limit {
+				item.Contents, item.Charset = nil, ""
+				item.Error = &fileContentsBatchError{Code: codeOverLimit,
+					Message: fmt.Sprintf("file contents size (%d bytes) exceeds the remaining batch response limit (%d MB)", item.Size, s.config.Scanning.FileContentsBatchLimit)}
+			} else {
+				total += item.Size
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// failedLookups returns the number of distinct files in a batch which the engine failed (or was too busy) to look up.
+func failedLookups(items []fileContentsBatchItem) int64 {
+	failed := make(map[string]bool)
+	for _, item := range items {
+		if item.Error != nil && (item.Error.Code == codeEngineBusy || item.Error.Code == codeEngineError) {
+			failed[item.MD5] = true
+		}
+	}
+	return int64(len(failed))
+}
+
+// lookupBatchItem retrieves the contents of a single file in a batch, recording any error in the item.
+func (s APIService) lookupBatchItem(ctx context.Context, item *fileContentsBatchItem, zs *zap.SugaredLogger) {
+	output, err := s.fileContents(ctx, item.MD5, zs)
+	switch {
+	case errors.Is(err, errEngineBusy):
+		item.Error = &fileContentsBatchError{Code: codeEngineBusy, Message: "no engine processes available"}
+		return
+	case err != nil:
+		zs.Warnf("Failed to retrieve contents for %v: %v", item.MD5, err)
+		item.Error = &fileContentsBatchError{Code: codeEngineError, Message: "failed to recover file contents"}
+		return
+	}
+	item.Size = int64(len(output))
+	switch {
+	case item.Size == 0 && item.MD5 != emptyFileMD5:
+		item.Error = &fileContentsBatchError{Code: codeNotFound, Message: "file contents not found"}
+	case s.fileContentslimitBytes > 0 && item.Size > s.fileContentslimitBytes:
+		item.Error = &fileContentsBatchError{Code: codeOverLimit,
+			Message: fmt.Sprintf("file contents size (%d bytes) exceeds the maximum allowed limit (%d MB)", item.Size, s.config.Scanning.FileContentsLimit)}
+	default:
+		item.Contents = output
+		item.Charset = detectCharset(output)
+	}
+}
+
+// writeFileContentsMultipart sends the batch back to the client as a multipart/mixed response, with a part per file.
+// Files which could not be returned have an X-Error-Code header and the error message as their contents.
+func writeFileContentsMultipart(w http.ResponseWriter, items []fileContentsBatchItem, zs *zap.SugaredLogger) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set(ContentTypeKey, fmt.Sprintf("%s; boundary=%s", MultipartMixed, mw.Boundary()))
+	for _, item := range items {
+		header := textproto.MIMEHeader{}
+		header.Set(FileMD5Key, item.MD5)
+		contents := item.Contents
+		if item.Error != nil {
+			header.Set(ContentTypeKey, TextPlain)
+			header.Set(ErrorCodeKey, item.Error.Code)
+			contents = []byte(item.Error.Message)
+		} else {
+			header.Set(ContentTypeKey, fmt.Sprintf("text/plain; charset=%s", item.Charset))
+			header.Set(CharsetDetectedKey, item.Charset)
+		}
+		header.Set(ContentLengthKey, fmt.Sprintf("%d", len(contents)))
+		part, err := mw.CreatePart(header)
+		if err == nil {
+			_, err = part.Write(contents)
+		}
+		if err != nil {
+			zs.Errorf("Failed to write file contents batch response: %v", err)
+			return
+		}
+	}
+	if err := mw.Close(); err != nil {
+		zs.Errorf("Failed to write file contents batch response: %v", err)
+		return
+	}
+	zs.Infof("responded")
+}
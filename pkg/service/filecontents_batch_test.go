@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const (
+	batchFound   = "37f7cd1e657aa3c30ece35995b4c59e5"
+	batchMissing = "00000000000000000000000000000000"
+	batchLarge   = "11111111111111111111111111111111"
+	batchFailing = "22222222222222222222222222222222"
+)
+
+// batchEngine is a fake engine returning different file contents depending on the md5, and tracking how many lookups run at once.
+type batchEngine struct {
+	fakeEngine
+	running atomic.Int64
+	peak    atomic.Int64
+}
+
+func (e *batchEngine) FileContents(ctx context.Context, md5 string, zs *zap.SugaredLogger) ([]byte, error) {
+	running := e.running.Add(1)
+	defer e.running.Add(-1)
+	for {
+		peak := e.peak.Load()
+		if running <= peak || e.peak.CompareAndSwap(peak, running) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	switch md5 {
+	case batchMissing:
+		e.record("contents")
+		return nil, nil
+	case batchLarge:
+		e.record("contents")
+		return []byte(strings.Repeat("A", 1024*1024+1)), nil
+	case batchFailing:
+		e.record("contents")
+		return nil, errors.New("engine failure")
+	}
+	return e.fakeEngine.FileContents(ctx, md5, zs)
+}
+
+func newBatchReq(t *testing.T, body string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/file_contents/batch", strings.NewReader(body))
+	req.Header.Set(ContentTypeKey, ApplicationJSON)
+	return req
+}
+
+func TestFileContentsBatch(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.FileContentsLimit = 1 // 1 MB
+	myConfig.Scanning.FileContentsBatchWorkers = 2
+	engine := &batchEngine{}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	md5s := []string{batchFound, batchMissing, "not-an-md5", batchLarge, batchFailing, strings.ToUpper(batchFound), emptyFileMD5}
+	body, _ := json.Marshal(fileContentsBatchRequest{MD5s: md5s})
+	w := httptest.NewRecorder()
+	apiService.FileContentsBatch(w, newBatchReq(t, string(body)))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, ApplicationJSON, w.Header().Get(ContentTypeKey))
+	var resp fileContentsBatchResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid batch response %v: %v", w.Body.String(), err)
+	}
+	if !assert.Len(t, resp.Files, len(md5s)) {
+		return
+	}
+	codes := make([]string, 0, len(resp.Files))
+	for _, item := range resp.Files {
+		code := ""
+		if item.Error != nil {
+			code = item.Error.Code
+		}
+		codes = append(codes, code)
+	}
+	assert.Equal(t, []string{"", codeNotFound, codeInvalidMD5, codeOverLimit, codeEngineError, "", ""}, codes)
+	assert.Equal(t, "contents of "+batchFound, string(resp.Files[0].Contents))
+	assert.Equal(t, int64(len("contents of "+batchFound)), resp.Files[0].Size)
+	assert.NotEmpty(t, resp.Files[0].Charset)
+	assert.Equal(t, resp.Files[0], resp.Files[5]) // Duplicates are only looked up once
+	assert.Equal(t, int64(1024*1024+1), resp.Files[3].Size)
+	assert.Empty(t, resp.Files[3].Contents)
+	assert.Equal(t, "contents of "+emptyFileMD5, string(resp.Files[6].Contents))
+	assert.Len(t, engine.calls, 5)
+	assert.LessOrEqual(t, engine.peak.Load(), int64(2))
+}
+
+func TestFileContentsBatchResponseLimit(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.FileContentsLimit = 2      // 2 MB
+	myConfig.Scanning.FileContentsBatchLimit = 3 // 3 MB
+	apiService := NewAPIServiceWithEngine(myConfig, &batchEngine{})
+	items := apiService.fileContentsBatch(context.Background(), []string{batchLarge, batchLarge, batchFound, batchLarge}, zlog.S)
+	if !assert.Len(t, items, 4) {
+		return
+	}
+	for _, item := range items[:3] { // Files which fit in the response are returned
+		assert.Nil(t, item.Error)
+		assert.NotEmpty(t, item.Contents)
+	}
+	if assert.NotNil(t, items[3].Error) { // The third large file would take the response over 3 MB
+		assert.Equal(t, codeOverLimit, items[3].Error.Code)
+	}
+	assert.Empty(t, items[3].Contents)
+	assert.Equal(t, int64(1024*1024+1), items[3].Size)
+}
+
+func TestFileContentsBatchSkipLookups(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.FileContentsLimit = 2      // 2 MB
+	myConfig.Scanning.FileContentsBatchLimit = 1 // 1 MB
+	myConfig.Scanning.FileContentsBatchWorkers = 1
+	engine := &batchEngine{}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	items := apiService.fileContentsBatch(context.Background(), []string{batchLarge, batchFound, emptyFileMD5}, zlog.S)
+	if !assert.Len(t, items, 3) {
+		return
+	}
+	for _, item := range items { // The first file fills the response, so the others are never looked up
+		if assert.NotNil(t, item.Error, item.MD5) {
+			assert.Equal(t, codeOverLimit, item.Error.Code)
+		}
+		assert.Empty(t, item.Contents)
+	}
+	assert.Len(t, engine.calls, 1)
+}
+
+func TestFileContentsBatchMultipart(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	apiService := NewAPIServiceWithEngine(myConfig, &batchEngine{})
+	req := newBatchReq(t, `{"md5s": ["`+batchFound+`", "`+batchMissing+`"]}`)
+	req.Header.Set(AcceptKey, MultipartMixed)
+	w := httptest.NewRecorder()
+	apiService.FileContentsBatch(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get(ContentTypeKey))
+	assert.NoError(t, err)
+	assert.Equal(t, MultipartMixed, mediaType)
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if !assert.NoError(t, err) {
+		return
+	}
+	contents, _ := io.ReadAll(part)
+	assert.Equal(t, "contents of "+batchFound, string(contents))
+	assert.Equal(t, batchFound, part.Header.Get(FileMD5Key))
+	assert.True(t, strings.HasPrefix(part.Header.Get(ContentTypeKey), "text/plain; charset="))
+	assert.Empty(t, part.Header.Get(ErrorCodeKey))
+	part, err = mr.NextPart()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, batchMissing, part.Header.Get(FileMD5Key))
+	assert.Equal(t, codeNotFound, part.Header.Get(ErrorCodeKey))
+	_, err = mr.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestFileContentsBatchInvalid(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.FileContentsBatchSize = 2
+	tests := []struct {
+		name     string
+		body     string
+		disabled bool
+		want     int
+	}{
+		{name: "invalid json", body: `{"md5s": `, want: http.StatusBadRequest},
+		{name: "no md5s", body: `{"md5s": []}`, want: http.StatusBadRequest},
+		{name: "too many md5s", body: `{"md5s": ["a", "b", "c"]}`, want: http.StatusRequestEntityTooLarge},
+		{name: "disabled", body: `{"md5s": ["` + batchFound + `"]}`, disabled: true, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myConfig.Scanning.FileContents = !tt.disabled
+			engine := &batchEngine{}
+			apiService := NewAPIServiceWithEngine(myConfig, engine)
+			w := httptest.NewRecorder()
+			apiService.FileContentsBatch(w, newBatchReq(t, tt.body))
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+			assert.Empty(t, engine.calls)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wlynxg/chardet"
+	"github.com/wlynxg/chardet/consts"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// Outcomes reported in the X-Charset-Transcoding header when UTF-8 contents are requested.
+const (
+	transcodingUnchanged = "unchanged"  // Already UTF-8 (or ASCII)
+	transcodingDone      = "transcoded" // Converted from the detected charset
+	transcodingFallback  = "fallback"   // Could not be converted, so the original contents are returned
+)
+
+// Reasons for falling back to the original contents.
+const (
+	fallbackLowConfidence = "low-confidence"      // Charset could not be reliably detected
+	fallbackUnsupported   = "unsupported-charset" // No decoder for the detected charset
+	fallbackInvalid       = "invalid-data"        // Contents could not be decoded from the detected charset
+)
+
+// charsetAliases maps detected charsets to the (superset) decoders used for them.
+var charsetAliases = map[string]encoding.Encoding{
+	consts.GB2312: simplifiedchinese.GB18030,
+	"GBK":         simplifiedchinese.GB18030,
+	"GB18030":     simplifiedchinese.GB18030,
+	consts.CP932:  japanese.ShiftJIS,
+	consts.CP949:  korean.EUCKR,
+}
+
+// charsetTranscoding describes the result of converting file contents to UTF-8.
+type charsetTranscoding struct {
+	detected string // Charset detected in the original contents
+	charset  string // Charset of the returned contents
+	status   string // X-Charset-Transcoding header value
+}
+
+// wantsUTF8 reports if the client asked for the contents in UTF-8, using the encoding query parameter or the Accept-Charset header.
+// Only UTF-8 can be requested with the encoding parameter, so anything else is returned as an error.
+func wantsUTF8(r *http.Request) (bool, error) {
+	if values, found := r.URL.Query()["encoding"]; found {
+		value := strings.ToLower(strings.TrimSpace(values[0]))
+		if value != "utf-8" && value != "utf8" {
+			return false, fmt.Errorf("unsupported encoding: %q (only utf-8 is supported)", values[0])
+		}
+		return true, nil
+	}
+	return preferredCharset(r.Header.Get(AcceptCharsetKey)) == "utf-8", nil
+}
+
+// preferredCharset returns the charset (in lower case) with the highest quality value in an Accept-Charset header, ignoring wildcards.
+func preferredCharset(acceptCharset string) string {
+	preferred, best := "", 0.0
+	for _, entry := range strings.Split(acceptCharset, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			var err error
+			if quality, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if len(name) > 0 && name != "*" && quality > best {
+			preferred, best = name, quality
+		}
+	}
+	return preferred
+}
+
+// charsetDecoder returns the decoder for the given detected charset (if supported).
+func charsetDecoder(charset string) encoding.Encoding {
+	if enc, found := charsetAliases[strings.ToUpper(charset)]; found {
+		return enc
+	}
+	if enc, err := ianaindex.IANA.Encoding(charset); err == nil && enc != nil {
+		return enc
+	}
+	if enc, err := htmlindex.Get(charset); err == nil {
+		return enc
+	}
+	return nil
+}
+
+// transcodeToUTF8 converts the contents from their detected charset to UTF-8.
+// If the charset cannot be reliably detected or decoded, the original contents are returned, and the fallback reason reported.
+func transcodeToUTF8(contents []byte) ([]byte, charsetTranscoding) {
+	result := chardet.Detect(contents)
+	detected := result.Encoding
+	switch {
+	case detected == consts.UTF8SIG:
+		return bytes.TrimPrefix(contents, []byte(consts.UTF8BOM)), charsetTranscoding{detected: detected, charset: "UTF-8", status: transcodingUnchanged}
+	case detected == consts.UTF8 || detected == consts.Ascii || (result.Confidence < CharSetMinConfidence && utf8.Valid(contents)):
+		if detected != consts.Ascii {
+			detected = "UTF-8"
+		}
+		return contents, charsetTranscoding{detected: detected, charset: "UTF-8", status: transcodingUnchanged}
+	case result.Confidence < CharSetMinConfidence:
+		return contents, fallbackTranscoding("UTF-8", fallbackLowConfidence) // Reported the same way as detectCharset
+	}
+	enc := charsetDecoder(detected)
+	if enc == nil {
+		return contents, fallbackTranscoding(detected, fallbackUnsupported)
+	}
+	decoded, err := enc.NewDecoder().Bytes(contents)
+	if err != nil || !utf8.Valid(decoded) {
+		return contents, fallbackTranscoding(detected, fallbackInvalid)
+	}
+	return decoded, charsetTranscoding{detected: detected, charset: "UTF-8", status: fmt.Sprintf("%s; from=%s", transcodingDone, detected)}
+}
+
+// fallbackTranscoding reports contents which could not be converted to UTF-8, and so are returned in their original charset.
+func fallbackTranscoding(charset, reason string) charsetTranscoding {
+	return charsetTranscoding{detected: charset, charset: charset, status: fmt.Sprintf("%s; reason=%s", transcodingFallback, reason)}
+}
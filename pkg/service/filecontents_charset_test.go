@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+const (
+	japaneseText = "// 日本語のコメントです。このファイルはテスト用のソースコードです。\n" +
+		"// 文字コードの変換が正しく行われることを確認します。\nint main() { return 0; }\n"
+	chineseText = "// 这是一个中文注释。这个文件是用于测试的源代码。\n" +
+		"// 检查字符编码转换是否正确完成。我们需要更多的中文文本来检测编码。\nint main() { return 0; }\n"
+	latinText = "/* Código de prueba: configuración, función, número, también, después, años, señal.\n" +
+		" * Détection de l'encodage: première, français, élève, garçon, très, où, déjà. */\n"
+)
+
+// encodeText converts the given UTF-8 text into the given charset.
+func encodeText(t *testing.T, text string, enc encoding.Encoding) []byte {
+	t.Helper()
+	encoded, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatalf("failed to encode test text: %v", err)
+	}
+	return encoded
+}
+
+func TestTranscodeToUTF8(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		want     string
+		status   string
+		detected []string
+	}{
+		{name: "utf-8", input: []byte(japaneseText), want: japaneseText, status: transcodingUnchanged, detected: []string{"UTF-8"}},
+		{name: "ascii", input: []byte("int main() { return 0; }\n"), want: "int main() { return 0; }\n", status: transcodingUnchanged},
+		{name: "utf-8 bom", input: []byte("\xEF\xBB\xBF" + japaneseText), want: japaneseText, status: transcodingUnchanged},
+		{name: "shift_jis", input: encodeText(t, japaneseText, japanese.ShiftJIS), want: japaneseText, status: "transcoded; from=", detected: []string{"SHIFT_JIS", "CP932"}},
+		{name: "gb18030", input: encodeText(t, chineseText, simplifiedchinese.GB18030), want: chineseText, status: "transcoded; from=", detected: []string{"GB2312", "GB18030"}},
+		{name: "iso-8859-1", input: encodeText(t, latinText, charmap.ISO8859_1), want: latinText, status: "transcoded; from=",
+			detected: []string{"ISO-8859-1", "Windows-1252"}},
+		{name: "binary", input: []byte{0x00, 0xff, 0xfe, 0x81, 0x00, 0x9f, 0xc1, 0x02}, status: "fallback; reason="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, transcoding := transcodeToUTF8(tt.input)
+			assert.True(t, strings.HasPrefix(transcoding.status, tt.status), transcoding.status)
+			if strings.HasPrefix(tt.status, transcodingFallback) {
+				assert.Equal(t, tt.input, output)
+				return
+			}
+			assert.Equal(t, tt.want, string(output))
+			assert.Equal(t, "UTF-8", transcoding.charset)
+			if len(tt.detected) > 0 {
+				assert.Contains(t, tt.detected, transcoding.detected)
+			}
+		})
+	}
+}
+
+func TestWantsUTF8(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		acceptCharset string
+		want          bool
+		wantErr       bool
+	}{
+		{name: "nothing requested"},
+		{name: "encoding", query: "?encoding=utf-8", want: true},
+		{name: "encoding upper case", query: "?encoding=UTF8", want: true},
+		{name: "unsupported encoding", query: "?encoding=latin1", wantErr: true},
+		{name: "accept charset", acceptCharset: "utf-8", want: true},
+		{name: "accept charset preference", acceptCharset: "iso-8859-1;q=0.5, UTF-8", want: true},
+		{name: "accept charset other preference", acceptCharset: "iso-8859-1, utf-8;q=0.7"},
+		{name: "accept charset wildcard", acceptCharset: "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/file_contents/37f7cd1e657aa3c30ece35995b4c59e5"+tt.query, nil)
+			if len(tt.acceptCharset) > 0 {
+				req.Header.Set(AcceptCharsetKey, tt.acceptCharset)
+			}
+			got, err := wantsUTF8(req)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// charsetEngine is a fake engine returning Shift_JIS file contents.
+type charsetEngine struct {
+	fakeEngine
+	contents []byte
+}
+
+func (e *charsetEngine) FileContents(_ context.Context, _ string, _ *zap.SugaredLogger) ([]byte, error) {
+	e.record("contents")
+	return e.contents, nil
+}
+
+func TestFileContentsTranscoding(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	shiftJIS := encodeText(t, japaneseText, japanese.ShiftJIS)
+	apiService := NewAPIServiceWithEngine(myConfig, &charsetEngine{contents: shiftJIS})
+	const md5 = "37f7cd1e657aa3c30ece35995b4c59e5"
+	get := func(query string, headers map[string]string) *httptest.ResponseRecorder {
+		req := newReq("GET", "http://localhost/file_contents/{md5}"+query, "", map[string]string{"md5": md5})
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		apiService.FileContents(w, req)
+		return w
+	}
+
+	w := get("", nil) // Raw contents by default
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, shiftJIS, w.Body.Bytes())
+	assert.Empty(t, w.Header().Get(TranscodingKey))
+	assert.Equal(t, `"`+md5+`"`, w.Header().Get(ETagKey))
+
+	for _, w = range []*httptest.ResponseRecorder{get("?encoding=utf-8", nil), get("", map[string]string{AcceptCharsetKey: "utf-8"})} {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, japaneseText, w.Body.String())
+		assert.Equal(t, "text/plain; charset=UTF-8", w.Header().Get(ContentTypeKey))
+		assert.Contains(t, []string{"SHIFT_JIS", "CP932"}, w.Header().Get(CharsetDetectedKey))
+		assert.True(t, strings.HasPrefix(w.Header().Get(TranscodingKey), "transcoded; from="))
+		assert.Equal(t, `"`+md5+`-utf8"`, w.Header().Get(ETagKey))
+		assert.Equal(t, AcceptCharsetKey, w.Header().Get(VaryKey))
+	}
+
+	// Lines are selected from the transcoded contents
+	w = get("?encoding=utf-8&lines=3", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "int main() { return 0; }\n", w.Body.String())
+
+	w = get("?encoding=latin1", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Contents which cannot be transcoded are returned as they are
+	binary := []byte{0x00, 0xff, 0xfe, 0x81, 0x00, 0x9f, 0xc1, 0x02}
+	apiService = NewAPIServiceWithEngine(myConfig, &charsetEngine{contents: binary})
+	w = get("?encoding=utf-8", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, binary, w.Body.Bytes())
+	assert.True(t, strings.HasPrefix(w.Header().Get(TranscodingKey), "fallback; reason="), w.Header().Get(TranscodingKey))
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// contentsRangeError reports a line or byte range which cannot be returned to the client.
+type contentsRangeError struct {
+	status int    // HTTP status to respond with (400 or 416)
+	code   string // Error response code (invalid_range or range_not_satisfiable)
+	msg    string
+}
+
+func (e *contentsRangeError) Error() string {
+	return e.msg
+}
+
+// parseRangeBounds parses a "start-end" range, where either bound may be left out (returned as -1).
+func parseRangeBounds(value string) (int64, int64, bool) {
+	startValue, endValue, found := strings.Cut(strings.TrimSpace(value), "-")
+	if !found || (len(startValue) == 0 && len(endValue) == 0) {
+		return 0, 0, false
+	}
+	start, end := int64(-1), int64(-1)
+	var err error
+	if len(startValue) > 0 {
+		if start, err = strconv.ParseInt(startValue, 10, 64); err != nil || start < 0 {
+			return 0, 0, false
+		}
+	}
+	if len(endValue) > 0 {
+		if end, err = strconv.ParseInt(endValue, 10, 64); err != nil || end < 0 {
+			return 0, 0, false
+		}
+	}
+	if start >= 0 && end >= 0 && end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// selectLines returns the given (1-based, inclusive) range of lines from the contents, i.e. "10-20", "10-" or "10".
+// It also returns the lines selected, as "start-end/total".
+func selectLines(contents []byte, lines string) ([]byte, string, error) {
+	start, end, ok := parseRangeBounds(lines)
+	if !ok {
+		if n, err := strconv.ParseInt(strings.TrimSpace(lines), 10, 64); err == nil {
+			start, end, ok = n, n, true
+		}
+	}
+	if !ok || start < 1 {
+		return nil, "", &contentsRangeError{status: http.StatusBadRequest, code: codeInvalidRange, msg: fmt.Sprintf("invalid lines range: %v", lines)}
+	}
+	total := int64(bytes.Count(contents, []byte("\n")))
+	if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+		total++ // Last line has no line feed
+	}
+	if start > total {
+		return nil, "", &contentsRangeError{status: http.StatusRequestedRangeNotSatisfiable, code: codeRangeNotSatisfiable,
+			msg: fmt.Sprintf("lines range %v is beyond the end of the file (%d lines)", lines, total)}
+	}
+	if end < 0 || end > total {
+		end = total
+	}
+	selected := contents[lineOffset(contents, start):lineOffset(contents, end+1)]
+	return selected, fmt.Sprintf("%d-%d/%d", start, end, total), nil
+}
+
+// lineOffset returns the offset of the start of the given (1-based) line, or the end of the contents if there are fewer lines.
+func lineOffset(contents []byte, line int64) int {
+	offset := 0
+	for ; line > 1; line-- {
+		next := bytes.IndexByte(contents[offset:], '\n')
+		if next < 0 {
+			return len(contents)
+		}
+		offset += next + 1
+	}
+	return offset
+}
+
+// selectBytes returns the range of bytes from the contents requested in a Range header (i.e. "bytes=0-499" or "bytes=-500"),
+// along with its Content-Range. Only single ranges are supported. Anything else leaves the contents as they are (returning no Content-Range).
+func selectBytes(contents []byte, rangeHeader string) ([]byte, string, error) {
+	unit, value, found := strings.Cut(rangeHeader, "=")
+	if !found || strings.TrimSpace(unit) != "bytes" || strings.Contains(value, ",") {
+		return contents, "", nil
+	}
+	start, end, ok := parseRangeBounds(value)
+	if !ok {
+		return contents, "", nil
+	}
+	size := int64(len(contents))
+	switch {
+	case start < 0: // Suffix range: the last 'end' bytes
+		if end == 0 || size == 0 {
+			return nil, "", &contentsRangeError{status: http.StatusRequestedRangeNotSatisfiable, code: codeRangeNotSatisfiable, msg: "requested range not satisfiable"}
+		}
+		start = max(size-end, 0)
+		end = size - 1
+	case start >= size:
+		return nil, "", &contentsRangeError{status: http.StatusRequestedRangeNotSatisfiable, code: codeRangeNotSatisfiable, msg: "requested range not satisfiable"}
+	case end < 0 || end >= size:
+		end = size - 1
+	}
+	return contents[start : end+1], fmt.Sprintf("bytes %d-%d/%d", start, end, size), nil
+}
+
+// selectContents returns the part of the file contents requested by the client (using the lines query parameter and/or Range header).
+// The Range applies to the selected lines, if any. It also returns the HTTP status to respond with (200 or 206).
+func selectContents(w http.ResponseWriter, r *http.Request, contents []byte) ([]byte, int, error) {
+	selected := contents
+	if lines := r.URL.Query().Get("lines"); len(lines) > 0 {
+		var linesRange string
+		var err error
+		if selected, linesRange, err = selectLines(contents, lines); err != nil {
+			return nil, 0, err
+		}
+		w.Header().Set(ContentLinesKey, linesRange)
+	}
+	w.Header().Set(AcceptRangesKey, "bytes")
+	rangeHeader := r.Header.Get(RangeKey)
+	if len(rangeHeader) == 0 {
+		return selected, http.StatusOK, nil
+	}
+	ranged, contentRange, err := selectBytes(selected, rangeHeader)
+	if err != nil {
+		w.Header().Set(ContentRangeKey, fmt.Sprintf("bytes */%d", len(selected)))
+		return nil, 0, err
+	}
+	if len(contentRange) == 0 {
+		return selected, http.StatusOK, nil
+	}
+	w.Header().Set(ContentRangeKey, contentRange)
+	return ranged, http.StatusPartialContent, nil
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectLines(t *testing.T) {
+	const contents = "line 1\nline 2\nline 3\nline 4"
+	tests := []struct {
+		lines  string
+		want   string
+		ranged string
+		status int
+	}{
+		{lines: "2-3", want: "line 2\nline 3\n", ranged: "2-3/4"},
+		{lines: "3-", want: "line 3\nline 4", ranged: "3-4/4"},
+		{lines: "1", want: "line 1\n", ranged: "1-1/4"},
+		{lines: "4-10", want: "line 4", ranged: "4-4/4"},
+		{lines: "-2", status: http.StatusBadRequest},
+		{lines: "0-2", status: http.StatusBadRequest},
+		{lines: "3-2", status: http.StatusBadRequest},
+		{lines: "a-b", status: http.StatusBadRequest},
+		{lines: "5-6", status: http.StatusRequestedRangeNotSatisfiable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lines, func(t *testing.T) {
+			selected, ranged, err := selectLines([]byte(contents), tt.lines)
+			if tt.status != 0 {
+				var rangeErr *contentsRangeError
+				if assert.ErrorAs(t, err, &rangeErr) {
+					assert.Equal(t, tt.status, rangeErr.status)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(selected))
+			assert.Equal(t, tt.ranged, ranged)
+		})
+	}
+}
+
+func TestSelectBytes(t *testing.T) {
+	const contents = "0123456789"
+	tests := []struct {
+		header string
+		want   string
+		ranged string
+		status int
+	}{
+		{header: "bytes=0-3", want: "0123", ranged: "bytes 0-3/10"},
+		{header: "bytes=5-", want: "56789", ranged: "bytes 5-9/10"},
+		{header: "bytes=-3", want: "789", ranged: "bytes 7-9/10"},
+		{header: "bytes=-30", want: "0123456789", ranged: "bytes 0-9/10"},
+		{header: "bytes=8-20", want: "89", ranged: "bytes 8-9/10"},
+		{header: "bytes=0-1,4-5", want: contents}, // Multiple ranges are ignored
+		{header: "lines=0-1", want: contents},     // Unknown units are ignored
+		{header: "bytes=5-2", want: contents},     // Invalid ranges are ignored
+		{header: "bytes=10-", status: http.StatusRequestedRangeNotSatisfiable},
+		{header: "bytes=-0", status: http.StatusRequestedRangeNotSatisfiable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			selected, ranged, err := selectBytes([]byte(contents), tt.header)
+			if tt.status != 0 {
+				var rangeErr *contentsRangeError
+				if assert.ErrorAs(t, err, &rangeErr) {
+					assert.Equal(t, tt.status, rangeErr.status)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(selected))
+			assert.Equal(t, tt.ranged, ranged)
+		})
+	}
+}
+
+func TestFileContentsRange(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanBinary = "../../test-support/scanoss.sh"
+	myConfig.Scanning.FileContentsLimit = 1 // 1 MB
+	apiService := NewAPIService(myConfig)
+	const md5 = "37f7cd1e657aa3c30ece35995b4c59e5"
+	tests := []struct {
+		name    string
+		md5     string
+		query   string
+		header  string
+		status  int
+		body    string
+		headers map[string]string
+	}{
+		{name: "bytes", md5: md5, header: "bytes=0-12", status: http.StatusPartialContent, body: "file contents",
+			headers: map[string]string{ContentRangeKey: "bytes 0-12/62", AcceptRangesKey: "bytes", ContentLengthKey: "13"}},
+		{name: "lines", md5: md5, query: "?lines=2-3", status: http.StatusOK, body: "line 2\nline 3\n",
+			headers: map[string]string{ContentLinesKey: "2-3/3", ContentLengthKey: "14"}},
+		{name: "lines & bytes", md5: md5, query: "?lines=2-3", header: "bytes=-7", status: http.StatusPartialContent, body: "line 3\n",
+			headers: map[string]string{ContentLinesKey: "2-3/3", ContentRangeKey: "bytes 7-13/14"}},
+		{name: "bytes not satisfiable", md5: md5, header: "bytes=100-", status: http.StatusRequestedRangeNotSatisfiable,
+			headers: map[string]string{ContentRangeKey: "bytes */62"}},
+		{name: "lines not satisfiable", md5: md5, query: "?lines=4-", status: http.StatusRequestedRangeNotSatisfiable},
+		{name: "invalid lines", md5: md5, query: "?lines=x", status: http.StatusBadRequest},
+		{name: "oversized file", md5: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", status: http.StatusRequestEntityTooLarge},
+		{name: "slice of an oversized file", md5: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", header: "bytes=1000-1004", status: http.StatusPartialContent, body: "AAAAA",
+			headers: map[string]string{ContentRangeKey: "bytes 1000-1004/1100000"}},
+		{name: "lines of an oversized file", md5: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", query: "?lines=1", status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newReq("GET", "http://localhost/file_contents/{md5}"+tt.query, "", map[string]string{"md5": tt.md5})
+			if len(tt.header) > 0 {
+				req.Header.Set(RangeKey, tt.header)
+			}
+			w := httptest.NewRecorder()
+			apiService.FileContents(w, req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			if len(tt.body) > 0 {
+				assert.Equal(t, tt.body, w.Body.String())
+				assert.True(t, strings.HasPrefix(w.Header().Get(ContentTypeKey), "text/plain; charset="))
+			}
+			for key, value := range tt.headers {
+				assert.Equal(t, value, w.Header().Get(key), key)
+			}
+		})
+	}
+}
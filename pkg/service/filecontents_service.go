@@ -17,19 +17,29 @@
 package service
 
 import (
-	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
-	"os/exec"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
 	"github.com/wlynxg/chardet"
+	"go.uber.org/zap"
+	myconfig "scanoss.com/go-api/pkg/config"
 )
 
+// Cache headers sent with file contents, which never change for a given md5.
+const fileContentsCacheControl = "public, max-age=31536000, immutable"
+
 // FileContents handles retrieval of sources file for a client.
+// Part of a file can be requested using a byte Range header and/or the lines (start-end) query parameter.
+// Contents can be converted to UTF-8 by requesting encoding=utf-8 (or Accept-Charset: utf-8), with the outcome reported in X-Charset-Transcoding.
+// Contents are tagged with their md5 (ETag) and cached (if enabled). Requests for contents the client already has are answered with 304,
+// while unknown md5s are answered with 404.
+// As HPSM lookups from the engine fetch contents from SCANOSS_FILE_CONTENTS_URL, pointing it at this server lets them share the cache.
 func (s APIService) FileContents(w http.ResponseWriter, r *http.Request) {
 	counters.incRequest("file_contents")
 	reqID := getReqID(r)
@@ -45,60 +55,191 @@ func (s APIService) FileContents(w http.ResponseWriter, r *http.Request) {
 	logRequestDetails(r, zs)
 	if !s.config.Scanning.FileContents {
 		zs.Warn("File contents retrieval is disabled.")
-		http.Error(w, "ERROR file contents disabled", http.StatusForbidden)
+		writeError(w, codeFeatureDisabled, "file contents disabled", nil, zs)
+		return
 	}
 	vars := mux.Vars(r)
 	zs.Debugf("%v request from %v - %v", r.URL.Path, r.RemoteAddr, vars)
 	if len(vars) == 0 {
 		zs.Errorf("Failed to retrieve request variables")
-		http.Error(w, "ERROR no request variables submitted", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no request variables submitted", nil, zs)
+		return
 	}
 	md5, ok := vars["md5"]
 	if !ok {
 		zs.Errorf("Failed to retrieve md5 request variable from: %v", vars)
-		http.Error(w, "ERROR no md5 request variable submitted", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no md5 request variable submitted", nil, zs)
+		return
+	}
+	if !isMD5(md5) {
+		zs.Errorf("Invalid md5 request variable: %v", md5)
+		writeError(w, codeInvalidMD5, "invalid md5 request variable submitted", nil, zs)
+		return
+	}
+	toUTF8, err := wantsUTF8(r)
+	if err != nil {
+		zs.Errorf("Invalid encoding requested: %v", err)
+		writeError(w, codeInvalidEncoding, err.Error(), nil, zs)
+		return
+	}
+	md5 = strings.ToLower(md5)
+	etag := fmt.Sprintf("%q", md5)
+	if toUTF8 {
+		etag = fmt.Sprintf("%q", md5+"-utf8") // Transcoded contents are a different representation of the file
+	}
+	w.Header().Set(ETagKey, etag)
+	w.Header().Set(CacheControlKey, fileContentsCacheControl)
+	w.Header().Add(VaryKey, AcceptCharsetKey)
+	ifNoneMatch := r.Header.Get(IfNoneMatchKey)
+	if etagMatches(ifNoneMatch, etag) {
+		zs.Debugf("Contents for %v not modified", md5)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if !s.chargeQuota(w, r, s.contentsLimits, 1, zs) {
+		clearCacheHeaders(w)
+		return
 	}
 	zs.Debugf("Retrieving contents for %v", md5)
-	var args []string
-	if s.config.Scanning.ScanDebug {
-		args = append(args, "-d")
+	output, err := s.fileContents(logContext, md5, zs)
+	if err != nil { // The contents were never retrieved, so don't count them towards the quota
+		s.contentsLimits.refund(s.clientID(r), 1)
+		clearCacheHeaders(w)
 	}
-
-	// Set Database name if declared
-	if len(s.config.Scanning.ScanKbName) > 0 {
-		args = append(args, fmt.Sprintf("-n%s", s.config.Scanning.ScanKbName))
+	if errors.Is(err, errEngineBusy) {
+		s.writeEngineBusy(w, zs)
+		return
 	}
-
-	args = append(args, "-k", md5)
-	zs.Debugf("Executing %v %v", s.config.Scanning.ScanBinary, strings.Join(args, " "))
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second) // put a timeout on the scan execution
-	defer cancel()
-	//nolint:gosec
-	output, err := exec.CommandContext(ctx, s.config.Scanning.ScanBinary, args...).Output()
 	if err != nil {
-		zs.Errorf("Contents command (%v %v) failed: %v", s.config.Scanning.ScanBinary, args, err)
-		zs.Errorf("Command output: %s", bytes.TrimSpace(output))
-		http.Error(w, "ERROR recovering file contents", http.StatusInternalServerError)
+		writeError(w, codeEngineError, "failed to recover file contents", nil, zs)
+		return
+	}
+	if len(output) == 0 && md5 != emptyFileMD5 {
+		zs.Warnf("Contents for %v not found", md5)
+		clearCacheHeaders(w)
+		writeError(w, codeNotFound, "file contents not found", nil, zs)
 		return
 	}
-	outputLen := int64(len(output))
+	if etagWildcard(ifNoneMatch) { // Only known once the contents have been found
+		zs.Debugf("Contents for %v not modified", md5)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	var transcoding *charsetTranscoding
+	if toUTF8 {
+		var converted charsetTranscoding
+		output, converted = transcodeToUTF8(output)
+		transcoding = &converted
+		zs.Debugf("Transcoding contents for %v to UTF-8: %v", md5, converted.status)
+	}
+	selected, status, err := selectContents(w, r, output)
+	var rangeErr *contentsRangeError
+	if errors.As(err, &rangeErr) {
+		zs.Warnf("Cannot return the requested range of %v: %v", md5, err)
+		clearCacheHeaders(w)
+		writeErrorStatus(w, rangeErr.status, rangeErr.code, rangeErr.msg, nil, zs)
+		return
+	}
+	outputLen := int64(len(selected))
 	// unlimited for FileContentsLimit <= 0
 	if s.fileContentslimitBytes > 0 && outputLen > s.fileContentslimitBytes {
 		zs.Warnf("File contents size %d bytes exceeds limit %d MB for md5 %s", outputLen, s.config.Scanning.FileContentsLimit, md5)
-		http.Error(w, fmt.Sprintf("file contents size (%d bytes) exceeds the maximum allowed limit (%d MB)",
-			outputLen, s.config.Scanning.FileContentsLimit), http.StatusRequestEntityTooLarge)
+		clearCacheHeaders(w)
+		w.Header().Del(ContentRangeKey)
+		writeError(w, codeOverLimit, fmt.Sprintf("file contents size (%d bytes) exceeds the maximum allowed limit (%d MB). Request part of the file using a Range header or lines parameter",
+			outputLen, s.config.Scanning.FileContentsLimit), limitDetails{Limit: limitFileContentsSize, Max: s.config.Scanning.FileContentsLimit, Unit: "MB"}, zs)
 		return
 	}
-	charset := detectCharset(output)
+	charset, detected := s.contentsCharset(output, selected, transcoding)
+	if transcoding != nil {
+		w.Header().Set(TranscodingKey, transcoding.status)
+	}
 	if s.config.App.Trace {
-		zs.Debugf("Sending back contents: %v - '%s'", outputLen, output)
+		zs.Debugf("Sending back contents: %v - '%s'", outputLen, selected)
 	} else {
 		zs.Debugf("Sending back contents: %v", outputLen)
 	}
 	w.Header().Set(ContentTypeKey, fmt.Sprintf("text/plain; charset=%s", charset))
-	w.Header().Set(CharsetDetectedKey, charset)
+	w.Header().Set(CharsetDetectedKey, detected)
 	w.Header().Set(ContentLengthKey, fmt.Sprintf("%d", outputLen))
-	printResponse(w, string(output), zs, false)
+	w.WriteHeader(status)
+	printResponse(w, string(selected), zs, false)
+}
+
+// contentsCharset returns the charset of the contents being returned, along with the charset detected in the original file.
+// The charset of the whole file is detected, so that every part of it is reported the same way (unless it's too large to return).
+func (s APIService) contentsCharset(output, selected []byte, transcoding *charsetTranscoding) (string, string) {
+	if transcoding != nil {
+		return transcoding.charset, transcoding.detected
+	}
+	charset := detectCharset(output)
+	if s.fileContentslimitBytes > 0 && int64(len(output)) > s.fileContentslimitBytes {
+		charset = detectCharset(selected)
+	}
+	return charset, charset
+}
+
+// fileContents returns the contents of the given md5, from the cache if possible, otherwise from the engine.
+// Contents larger than the file contents limit (or not found) are never cached.
+// The engine is stopped if the context is cancelled (i.e. the client disconnects).
+func (s APIService) fileContents(ctx context.Context, md5 string, zs *zap.SugaredLogger) ([]byte, error) {
+	if s.contents != nil {
+		if output, found := s.contents.get(md5); found {
+			zs.Debugf("Using cached contents for %v", md5)
+			counters.incRequest("contents_cache_hits")
+			return output, nil
+		}
+		counters.incRequest("contents_cache_misses")
+	}
+	output, err := s.engine.FileContents(ctx, md5, zs)
+	if err != nil {
+		return nil, err
+	}
+	found := len(output) > 0 || md5 == emptyFileMD5
+	if s.contents != nil && found && (s.fileContentslimitBytes <= 0 || int64(len(output)) <= s.fileContentslimitBytes) {
+		s.contents.set(md5, output)
+	}
+	return output, nil
+}
+
+// newFileContentsCache creates the file contents cache configured for the server, or nil if caching is disabled.
+func newFileContentsCache(config *myconfig.ServerConfig) cacheBackend {
+	cache := newCacheBackend("contents", config.Scanning.FileContentsCache, 0, config.Scanning.FileContentsCacheSize*1024*1024,
+		config.Scanning.FileContentsCacheDir)
+	if cache != nil {
+		zlog.S.Infof("Caching file contents (%v, %v MB)", config.Scanning.FileContentsCache, config.Scanning.FileContentsCacheSize)
+	}
+	return cache
+}
+
+// isMD5 reports if the given string is an md5 (32 hex digits).
+func isMD5(md5 string) bool {
+	if len(md5) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(md5)
+	return err == nil
+}
+
+// etagMatches reports if the given If-None-Match header value lists the ETag (ignoring any weak validator prefix).
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWildcard reports if the given If-None-Match header value is * (matching any contents which exist).
+func etagWildcard(ifNoneMatch string) bool {
+	return strings.TrimSpace(ifNoneMatch) == "*"
+}
+
+// clearCacheHeaders removes the caching headers from a response which failed to return any file contents.
+func clearCacheHeaders(w http.ResponseWriter) {
+	w.Header().Del(ETagKey)
+	w.Header().Del(CacheControlKey)
 }
 
 // detectCharset detects charset for a given text in a buffer.
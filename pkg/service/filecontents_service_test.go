@@ -154,8 +154,118 @@ func TestFileContentsLimitExceeded(t *testing.T) {
 		t.Fatalf("an error was not expected when reading from request: %v", err)
 	}
 	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
-	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	assert.Equal(t, ApplicationJSON, resp.Header.Get("Content-Type"))
 	assert.Contains(t, string(body), "exceeds the maximum allowed limit")
+	assert.Equal(t, codeOverLimit, decodeError(t, body, nil).Code)
+}
+
+func TestFileContentsCache(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	const md5 = "37f7cd1e657aa3c30ece35995b4c59e5"
+	const missing = "7c53a2de7dfeaa20d057db98468d6670"
+	tests := []struct {
+		name  string
+		cache string
+		calls int
+	}{
+		{name: "no cache", cache: cacheNone, calls: 4},
+		{name: "memory cache", cache: cacheMemory, calls: 1},
+		{name: "disk cache", cache: cacheDisk, calls: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			myConfig := setupConfig(t)
+			myConfig.Scanning.FileContentsCache = test.cache
+			myConfig.Scanning.FileContentsCacheDir = t.TempDir()
+			engine := &fakeEngine{missing: missing}
+			apiService := NewAPIServiceWithEngine(myConfig, engine)
+			get := func(md5 string, headers map[string]string) *httptest.ResponseRecorder {
+				req := newReq("GET", "http://localhost/file_contents/{md5}", "", map[string]string{"md5": md5})
+				for key, value := range headers {
+					req.Header.Set(key, value)
+				}
+				w := httptest.NewRecorder()
+				apiService.FileContents(w, req)
+				return w
+			}
+			for i := 0; i < 3; i++ {
+				w := get(md5, nil)
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, "contents of "+md5, w.Body.String())
+				assert.Equal(t, `"`+md5+`"`, w.Header().Get(ETagKey))
+				assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get(CacheControlKey))
+			}
+			// Conditional requests for a known ETag never reach the engine
+			for _, tag := range []string{`"` + md5 + `"`, `W/"` + md5 + `"`, `"other", "` + md5 + `"`} {
+				w := get(md5, map[string]string{IfNoneMatchKey: tag})
+				assert.Equal(t, http.StatusNotModified, w.Code, tag)
+				assert.Empty(t, w.Body.String())
+				assert.Equal(t, `"`+md5+`"`, w.Header().Get(ETagKey))
+			}
+			// Any ETag (*) only matches once the contents have been found
+			w := get(md5, map[string]string{IfNoneMatchKey: "*"})
+			assert.Equal(t, http.StatusNotModified, w.Code)
+			assert.Empty(t, w.Body.String())
+			assert.Len(t, engine.calls, test.calls)
+			w = get(md5, map[string]string{IfNoneMatchKey: `"d41d8cd98f00b204e9800998ecf8427e"`})
+			assert.Equal(t, http.StatusOK, w.Code)
+			// Unknown contents are not found (and not cached)
+			for _, headers := range []map[string]string{nil, {IfNoneMatchKey: "*"}} {
+				w = get(missing, headers)
+				assert.Equal(t, http.StatusNotFound, w.Code)
+				assert.Empty(t, w.Header().Get(ETagKey))
+			}
+			if apiService.contents != nil {
+				_, found := apiService.contents.get(missing)
+				assert.False(t, found)
+			}
+		})
+	}
+}
+
+func TestFileContentsInvalidMD5(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.FileContentsCache = cacheMemory
+	engine := &fakeEngine{}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	for _, md5 := range []string{"37f7cd1e657aa3c3", "../../../../etc/passwd", "zzf7cd1e657aa3c30ece35995b4c59e5"} {
+		req := newReq("GET", "http://localhost/file_contents/{md5}", "", map[string]string{"md5": md5})
+		w := httptest.NewRecorder()
+		apiService.FileContents(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, md5)
+		assert.Empty(t, w.Header().Get(ETagKey))
+	}
+	assert.Empty(t, engine.calls)
+}
+
+func TestFileContentsCacheLimit(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanBinary = "../../test-support/scanoss.sh"
+	myConfig.Scanning.FileContentsLimit = 1 // 1 MB
+	myConfig.Scanning.FileContentsCache = cacheMemory
+	apiService := NewAPIService(myConfig)
+	// Contents over the limit are rejected and not cached
+	req := newReq("GET", "http://localhost/file_contents/{md5}", "", map[string]string{"md5": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+	w := httptest.NewRecorder()
+	apiService.FileContents(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Empty(t, w.Header().Get(ETagKey))
+	_, found := apiService.contents.get("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	assert.False(t, found)
 }
 
 func TestDetectCharset(t *testing.T) {
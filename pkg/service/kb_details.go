@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-co-op/gocron"
@@ -44,6 +45,16 @@ type matchStructure []struct {
 var kbDetails string     // KB Details JSON string
 var engineVersion string // Version of the engine in use
 
+var kbVersion atomic.Value // KB version (monthly/daily) string, used to invalidate cached scan results
+
+// currentKBVersion returns the latest KB version loaded from the engine (or unknown if it has not been loaded).
+func currentKBVersion() string {
+	if loaded, ok := kbVersion.Load().(string); ok {
+		return loaded
+	}
+	return "unknown"
+}
+
 // validateEngineVersion validates that the current engine version meets the minimum requirement.
 // Logs a critical error if the version is below minimum, or an info message if it meets the requirement.
 func validateEngineVersion(zs *zap.SugaredLogger, currentEngineVersion, minEngineVersion string) {
@@ -104,14 +115,15 @@ func (s APIService) KBDetails(w http.ResponseWriter, r *http.Request) {
 
 // loadKBDetails attempts to scan a file to load the latest KB details from the server.
 func (s APIService) loadKBDetails() {
-	zs := sugaredLogger(context.TODO()) // Set up a logger without context
+	ctx := context.TODO()
+	zs := sugaredLogger(ctx) // Set up a logger without context
 	zs.Debugf("Loading latest KB details...")
 	if len(engineVersion) == 0 {
 		engineVersion = "unknown"
 	}
 	// Load a random (hopefully non-existent) file match to extract the KB version details
 	emptyConfig := DefaultScanningServiceConfig(s.config)
-	result, _, err := s.scanWfp("file=7c53a2de7dfeaa20d057db98468d6670,2321,path/to/dummy/file.txt", "", emptyConfig, zs)
+	result, _, err := s.scanWfp(ctx, "file=7c53a2de7dfeaa20d057db98468d6670,2321,path/to/dummy/file.txt", "", emptyConfig, zs)
 	if err != nil {
 		zs.Warnf("Failed to detect KB version from eninge: %v", err)
 		return
@@ -146,6 +158,7 @@ func (s APIService) loadKBDetails() {
 		}
 		if len(ms) > 0 {
 			kbDetails = fmt.Sprintf(`{"kb_version": { "monthly": "%v", "daily": "%v"}}`, ms[0].Server.KbVersion.Monthly, ms[0].Server.KbVersion.Daily)
+			kbVersion.Store(ms[0].Server.KbVersion.Monthly + "/" + ms[0].Server.KbVersion.Daily)
 			engineVersion = ms[0].Server.Version
 			validateEngineVersion(zs, engineVersion, minEngineVersion)
 		}
@@ -17,13 +17,9 @@
 package service
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
-	"os/exec"
-	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -46,33 +42,23 @@ func (s APIService) LicenseDetails(w http.ResponseWriter, r *http.Request) {
 	zs.Debugf("%v request from %v - %v", r.URL.Path, r.RemoteAddr, vars)
 	if len(vars) == 0 {
 		zs.Errorf("Failed to retrieve request variables")
-		http.Error(w, "ERROR no request variables submitted", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no request variables submitted", nil, zs)
+		return
 	}
 	license, ok := vars["license"]
 	if !ok {
 		zs.Errorf("Failed to retrieve license request variable from: %v", vars)
-		http.Error(w, "ERROR no license request variable submitted", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no license request variable submitted", nil, zs)
+		return
 	}
 	zs.Debugf("Retrieving license details for for %v", license)
-	var args []string
-	if s.config.Scanning.ScanDebug {
-		args = append(args, "-d")
-	}
-	// Set Database name if declared
-	if len(s.config.Scanning.ScanKbName) > 0 {
-		args = append(args, fmt.Sprintf("-n%s", s.config.Scanning.ScanKbName))
+	output, err := s.engine.LicenseDetails(context.Background(), license, zs)
+	if errors.Is(err, errEngineBusy) {
+		s.writeEngineBusy(w, zs)
+		return
 	}
-
-	args = append(args, "-l", license)
-	zs.Debugf("Executing %v %v", s.config.Scanning.ScanBinary, strings.Join(args, " "))
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second) // put a timeout on the scan execution
-	defer cancel()
-	//nolint:gosec
-	output, err := exec.CommandContext(ctx, s.config.Scanning.ScanBinary, args...).Output()
 	if err != nil {
-		zs.Errorf("License Details command (%v %v) failed: %v", s.config.Scanning.ScanBinary, args, err)
-		zs.Errorf("Command output: %s", bytes.TrimSpace(output))
-		http.Error(w, "ERROR engine license details failed", http.StatusInternalServerError)
+		writeError(w, codeEngineError, "engine license details failed", nil, zs)
 		return
 	}
 	if s.config.App.Trace {
@@ -0,0 +1,313 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Classes of request with their own rate limits and quotas.
+const (
+	rateClassScan     = "scan"     // Scan requests (direct and asynchronous jobs)
+	rateClassContents = "contents" // File contents requests (single and batch)
+)
+
+// clientUsage tracks the request rate (token bucket) and daily file usage of a single client.
+type clientUsage struct {
+	tokens   float64   // Requests available
+	updated  time.Time // Last time the tokens were refilled
+	files    int64     // Files used today
+	rejected int64     // Requests rejected today
+}
+
+// rateLimiter enforces the per-client request rate limit and daily file quota for a class of request.
+// Clients are identified by their API key, token subject or client certificate (if any) or source IP.
+type rateLimiter struct {
+	class     string
+	perMinute int64   // Requests per minute (0 = unlimited)
+	burst     float64 // Requests a client can send at once
+	quota     int64   // Files per day (0 = unlimited)
+	mu        sync.Mutex
+	clients   map[string]*clientUsage
+	day       string // Day (UTC) the usage is being tracked for
+	now       func() time.Time
+}
+
+// newRateLimiter creates a rate limiter for the given class of request. Nil is returned if it has no limits.
+func newRateLimiter(class string, perMinute, burst int, quota int64) *rateLimiter {
+	if perMinute <= 0 && quota <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute // Default to allowing a minute's worth of requests at once
+	}
+	return &rateLimiter{class: class, perMinute: int64(max(perMinute, 0)), burst: float64(max(burst, 1)), quota: max(quota, 0),
+		clients: make(map[string]*clientUsage), now: time.Now}
+}
+
+// client returns the usage of the given client, starting a new day's usage if the day (UTC) has changed.
+// Must be called with the lock held.
+func (rl *rateLimiter) client(id string, now time.Time) *clientUsage {
+	if day := now.UTC().Format(time.DateOnly); day != rl.day {
+		rl.day = day
+		rl.clients = make(map[string]*clientUsage)
+	}
+	usage, found := rl.clients[id]
+	if !found {
+		usage = &clientUsage{tokens: rl.burst, updated: now}
+		rl.clients[id] = usage
+	}
+	return usage
+}
+
+// allow takes a request from the client's token bucket, reporting if the request is allowed, the number of requests
+// remaining and how long until the bucket is full again (or the next request is allowed, if rejected).
+func (rl *rateLimiter) allow(id string) (bool, int64, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	usage := rl.client(id, now)
+	rate := float64(rl.perMinute) / 60 // Requests per second
+	usage.tokens = math.Min(rl.burst, usage.tokens+now.Sub(usage.updated).Seconds()*rate)
+	usage.updated = now
+	if usage.tokens < 1 {
+		usage.rejected++
+		return false, 0, time.Duration((1 - usage.tokens) / rate * float64(time.Second))
+	}
+	usage.tokens--
+	return true, int64(usage.tokens), time.Duration((rl.burst - usage.tokens) / rate * float64(time.Second))
+}
+
+// charge adds the given number of files to the client's daily usage, failing with a quotaError if that would exceed the quota.
+func (rl *rateLimiter) charge(id string, files int64) error {
+	if rl == nil || rl.quota <= 0 {
+		return nil
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	usage := rl.client(id, now)
+	if usage.files+files > rl.quota {
+		usage.rejected++
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return &quotaError{class: rl.class, quota: rl.quota, remaining: rl.quota - usage.files, reset: midnight.Sub(now)}
+	}
+	usage.files += files
+	return nil
+}
+
+// refund takes the given number of files back off the client's daily usage, i.e. when a request charged as it was read fails.
+func (rl *rateLimiter) refund(id string, files int64) {
+	if rl == nil || rl.quota <= 0 || files <= 0 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	usage := rl.client(id, rl.now())
+	usage.files = max(usage.files-files, 0)
+}
+
+// quotaError reports that a request exceeded the client's daily file quota.
+type quotaError struct {
+	class     string
+	quota     int64         // Files per day
+	remaining int64         // Files left today
+	reset     time.Duration // Time until the quota is reset
+}
+
+// Error returns the quota error message.
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("request exceeds the daily %v quota (%v files, %v remaining)", e.class, e.quota, e.remaining)
+}
+
+// setRateLimitHeaders reports a client's limit, what it has remaining and when (in seconds) it will be reset.
+func setRateLimitHeaders(w http.ResponseWriter, limit, remaining int64, reset time.Duration) {
+	header := w.Header()
+	header.Set(RateLimitKey, strconv.FormatInt(limit, 10))
+	header.Set(RateRemainingKey, strconv.FormatInt(remaining, 10))
+	header.Set(RateResetKey, strconv.FormatInt(int64(math.Ceil(reset.Seconds())), 10))
+}
+
+// clientID identifies the client making a request for rate limiting: its API key ID or token subject if authenticated,
+// or its client certificate (mTLS), otherwise its IP.
+// The forwarded IP (see forwardedIP) is only used if the proxy is trusted (SCAN_TRUST_PROXY).
+func (s APIService) clientID(r *http.Request) string {
+	if caller := callerID(r.Context()); len(caller) > 0 {
+		return caller
+	}
+	if s.config.Filtering.TrustProxy {
+		if ip := forwardedIP(r); len(ip) > 0 {
+			return "ip:" + ip
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return "ip:" + host
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// forwardedIP returns the client IP reported by the proxy: the last X-Forwarded-For entry (the one added by the proxy,
+// as any before it can be supplied by the client), otherwise the X-Real-IP (or CF-Connecting-IP) header.
+func forwardedIP(r *http.Request) string {
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		entries := strings.Split(values[len(values)-1], ",")
+		if last := strings.TrimSpace(entries[len(entries)-1]); len(last) > 0 {
+			return last
+		}
+	}
+	for _, header := range []string{"X-Real-IP", "CF-Connecting-IP"} {
+		if ip := strings.TrimSpace(r.Header.Get(header)); len(ip) > 0 {
+			return ip
+		}
+	}
+	return ""
+}
+
+// requestLimiter returns the rate limiter for the class of the given request (nil if it has none).
+func (s APIService) requestLimiter(r *http.Request) *rateLimiter {
+	switch routeName(r.URL.Path) {
+	case "scan":
+		if r.Method == http.MethodPost { // Only new scans, not job status/result polling
+			return s.scanLimits
+		}
+	case "file_contents":
+		return s.contentsLimits
+	}
+	return nil
+}
+
+// RateLimit is middleware limiting the rate of scan and file contents requests per client (SCAN_RATE_LIMIT and
+// SCANOSS_FILE_CONTENTS_RATE_LIMIT). Requests over the limit are rejected with a 429 (Too Many Requests).
+func (s APIService) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := s.requestLimiter(r)
+		if limiter == nil || limiter.perMinute <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := s.clientID(r)
+		allowed, remaining, reset := limiter.allow(client)
+		setRateLimitHeaders(w, limiter.perMinute, remaining, reset)
+		if !allowed {
+			w.Header().Set(ResponseIDKey, getReqID(r))
+			w.Header().Set(RetryAfterKey, strconv.FormatInt(int64(math.Ceil(reset.Seconds())), 10))
+			zlog.S.Warnf("%v request from %v exceeded the %v rate limit (%v per minute)", r.URL.Path, client, limiter.class, limiter.perMinute)
+			writeError(w, codeRateLimited, fmt.Sprintf("too many %v requests (limit %v per minute)", limiter.class, limiter.perMinute),
+				limitDetails{Limit: limiter.class + "_requests", Max: limiter.perMinute, Unit: "requests/minute"}, zlog.S)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// chargeQuota charges the given number of files to the client's daily quota, writing a 429 response if it would be exceeded.
+func (s APIService) chargeQuota(w http.ResponseWriter, r *http.Request, limiter *rateLimiter, files int64, zs *zap.SugaredLogger) bool {
+	if err := limiter.charge(s.clientID(r), files); err != nil {
+		zs.Warnf("Request from %v rejected: %v", s.clientID(r), err)
+		writeQuotaExceeded(w, err, zs)
+		return false
+	}
+	return true
+}
+
+// writeQuotaExceeded responds with a 429 (Too Many Requests), reporting the daily quota exceeded.
+func writeQuotaExceeded(w http.ResponseWriter, err error, zs *zap.SugaredLogger) {
+	var quotaErr *quotaError
+	if !errors.As(err, &quotaErr) {
+		writeError(w, codeInternalError, "failed to check the daily quota", nil, zs)
+		return
+	}
+	setRateLimitHeaders(w, quotaErr.quota, quotaErr.remaining, quotaErr.reset)
+	w.Header().Set(RetryAfterKey, strconv.FormatInt(int64(math.Ceil(quotaErr.reset.Seconds())), 10))
+	writeError(w, codeQuotaExceeded, quotaErr.Error(), limitDetails{Limit: quotaErr.class + "_files", Max: quotaErr.quota, Unit: "files/day"}, zs)
+}
+
+// activeLimits holds the rate limiters of the latest API service, for reporting by MetricsHandler.
+var activeLimits struct {
+	mu       sync.Mutex
+	limiters []*rateLimiter
+}
+
+// setActiveLimits records the given rate limiters (ignoring those with no limits) for reporting.
+func setActiveLimits(limiters ...*rateLimiter) {
+	activeLimits.mu.Lock()
+	defer activeLimits.mu.Unlock()
+	activeLimits.limiters = nil
+	for _, limiter := range limiters {
+		if limiter != nil {
+			activeLimits.limiters = append(activeLimits.limiters, limiter)
+		}
+	}
+}
+
+// clientQuota is the usage of a single client reported by the quotas metrics.
+type clientQuota struct {
+	Files     int64  `json:"files"`
+	Remaining *int64 `json:"remaining,omitempty"` // Files remaining today (if there is a quota)
+	Rejected  int64  `json:"rejected"`
+}
+
+// classQuotas is the usage of a class of request reported by the quotas metrics.
+type classQuotas struct {
+	RateLimit  int64                  `json:"rate_limit"`  // Requests per minute
+	DailyQuota int64                  `json:"daily_quota"` // Files per day
+	Clients    map[string]clientQuota `json:"clients"`
+}
+
+// quotaMetrics returns the configured limits, and today's usage by each client, as JSON.
+func quotaMetrics() (string, error) {
+	activeLimits.mu.Lock()
+	limiters := activeLimits.limiters
+	activeLimits.mu.Unlock()
+	metrics := map[string]any{"date": time.Now().UTC().Format(time.DateOnly)}
+	for _, limiter := range limiters {
+		metrics[limiter.class] = limiter.usage()
+	}
+	data, err := json.Marshal(metrics)
+	return string(data), err
+}
+
+// usage returns the limits and today's usage by each client.
+func (rl *rateLimiter) usage() classQuotas {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	quotas := classQuotas{RateLimit: rl.perMinute, DailyQuota: rl.quota, Clients: make(map[string]clientQuota, len(rl.clients))}
+	if rl.day != rl.now().UTC().Format(time.DateOnly) {
+		return quotas // Nothing used today
+	}
+	for id, usage := range rl.clients {
+		client := clientQuota{Files: usage.files, Rejected: usage.rejected}
+		if rl.quota > 0 {
+			remaining := max(rl.quota-usage.files, 0)
+			client.Remaining = &remaining
+		}
+		quotas.Clients[id] = client
+	}
+	return quotas
+}
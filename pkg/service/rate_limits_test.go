@@ -0,0 +1,277 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(rateClassScan, 0, 10, 0)) // No limits
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(rateClassScan, 60, 2, 0)
+	limiter.now = func() time.Time { return now }
+
+	allowed, remaining, _ := limiter.allow("a")
+	assert.True(t, allowed)
+	assert.Equal(t, int64(1), remaining)
+	allowed, remaining, reset := limiter.allow("a")
+	assert.True(t, allowed)
+	assert.Equal(t, int64(0), remaining)
+	assert.Equal(t, 2*time.Second, reset) // Until the bucket is full again
+	allowed, _, reset = limiter.allow("a")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, reset) // Until the next request is allowed
+	allowed, _, _ = limiter.allow("b")  // Each client has its own bucket
+	assert.True(t, allowed)
+
+	now = now.Add(time.Second)
+	allowed, _, _ = limiter.allow("a")
+	assert.True(t, allowed)
+	allowed, _, _ = limiter.allow("a")
+	assert.False(t, allowed)
+	assert.Equal(t, int64(2), limiter.usage().Clients["a"].Rejected)
+	assert.NoError(t, limiter.charge("a", 1000)) // No quota
+
+	limiter = newRateLimiter(rateClassScan, 1, 0, 0) // Burst defaults to the rate limit
+	limiter.now = func() time.Time { return now }
+	allowed, _, _ = limiter.allow("a")
+	assert.True(t, allowed)
+	allowed, _, reset = limiter.allow("a")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Minute, reset)
+}
+
+func TestDailyQuota(t *testing.T) {
+	now := time.Date(2026, 1, 2, 18, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(rateClassContents, 0, 0, 5)
+	limiter.now = func() time.Time { return now }
+	assert.NoError(t, limiter.charge("a", 3))
+	err := limiter.charge("a", 3)
+	var quotaErr *quotaError
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("expected a quota error, got: %v", err)
+	}
+	assert.Equal(t, int64(2), quotaErr.remaining)
+	assert.Equal(t, 6*time.Hour, quotaErr.reset) // Midnight UTC
+	assert.NoError(t, limiter.charge("a", 2))
+	assert.NoError(t, limiter.charge("b", 5))
+	usage := limiter.usage()
+	assert.Equal(t, int64(5), usage.Clients["a"].Files)
+	assert.Equal(t, int64(0), *usage.Clients["a"].Remaining)
+	assert.Equal(t, int64(1), usage.Clients["a"].Rejected)
+
+	now = now.Add(6 * time.Hour) // The quota is reset every day
+	assert.Empty(t, limiter.usage().Clients)
+	assert.NoError(t, limiter.charge("a", 5))
+	assert.Error(t, limiter.charge("a", 1))
+	limiter.refund("a", 3) // Files can be refunded if a request fails after being charged
+	assert.NoError(t, limiter.charge("a", 3))
+	assert.Error(t, limiter.charge("a", 1))
+	var unlimited *rateLimiter
+	assert.NoError(t, unlimited.charge("a", 1))
+	unlimited.refund("a", 1)
+}
+
+func TestClientID(t *testing.T) {
+	myConfig := setupConfig(t)
+	apiService := NewAPIService(myConfig)
+	req := httptest.NewRequest(http.MethodPost, "/scan/direct", nil)
+	req.RemoteAddr = "10.0.0.1:51234"
+	req.Header.Set("X-Forwarded-For", "192.168.1.10")
+	assert.Equal(t, "ip:10.0.0.1", apiService.clientID(req))
+	myConfig.Filtering.TrustProxy = true
+	assert.Equal(t, "ip:192.168.1.10", apiService.clientID(req))
+
+	// Clients can't spoof their ID, as the proxy adds their real IP to the end of any forwarded IPs they send
+	for _, spoofed := range []string{"203.0.113.1", "203.0.113.2, 198.51.100.7"} {
+		req.Header.Set("X-Forwarded-For", spoofed+", 192.168.1.10")
+		assert.Equal(t, "ip:192.168.1.10", apiService.clientID(req))
+	}
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	req.Header.Add("X-Forwarded-For", "192.168.1.10") // Added as a separate header by the proxy
+	assert.Equal(t, "ip:192.168.1.10", apiService.clientID(req))
+	req.Header.Del("X-Forwarded-For")
+	req.Header.Set("X-Real-IP", "192.168.1.11")
+	assert.Equal(t, "ip:192.168.1.11", apiService.clientID(req))
+	req = req.WithContext(context.WithValue(req.Context(), KeyIDContextKey{}, "ci"))
+	assert.Equal(t, "key:ci", apiService.clientID(req))
+}
+
+func TestRateLimit(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanRateLimit = 2
+	myConfig.Scanning.ContentsRateLimit = 1
+	apiService := NewAPIService(myConfig)
+	router := mux.NewRouter()
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/api/scan/direct", ok).Methods(http.MethodPost)
+	router.HandleFunc("/api/scan/jobs/{id}", ok).Methods(http.MethodGet)
+	router.HandleFunc("/file_contents/{md5}", ok).Methods(http.MethodGet)
+	router.HandleFunc("/license/obligations/{license}", ok).Methods(http.MethodGet)
+	router.Use(apiService.RateLimit)
+	send := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := send(http.MethodPost, "/api/scan/direct", "10.0.0.1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get(RateLimitKey))
+		assert.NotEmpty(t, w.Header().Get(RateRemainingKey))
+	}
+	w := send(http.MethodPost, "/api/scan/direct", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get(RateRemainingKey))
+	assert.Equal(t, "30", w.Header().Get(RateResetKey))
+	assert.Equal(t, "30", w.Header().Get(RetryAfterKey))
+	var details limitDetails
+	assert.Equal(t, codeRateLimited, decodeError(t, w.Body.Bytes(), &details).Code)
+	assert.Equal(t, limitDetails{Limit: "scan_requests", Max: 2, Unit: "requests/minute"}, details)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/api/scan/direct", "10.0.0.2").Code)        // Another client
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/api/scan/jobs/1", "10.0.0.1").Code)         // Polling jobs isn't limited
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/license/obligations/MIT", "10.0.0.1").Code) // Nor are other requests
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/file_contents/abc", "10.0.0.1").Code)       // Contents have their own limit
+	assert.Equal(t, http.StatusTooManyRequests, send(http.MethodGet, "/file_contents/abc", "10.0.0.1").Code)
+}
+
+func TestQuotaRequests(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	const md5 = "37f7cd1e657aa3c30ece35995b4c59e5"
+	wfp := "file=" + md5 + ",3114,a.py\nfile=" + md5[1:] + "0,3114,b.py\n"
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanDailyQuota = 3
+	myConfig.Scanning.ContentsDailyQuota = 2
+	for _, workers := range []int{1, 2} { // Single requests and streamed uploads
+		myConfig.Scanning.Workers = workers
+		apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{})
+		w := httptest.NewRecorder()
+		apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, nil))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = httptest.NewRecorder()
+		apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, nil)) // Only one file left
+		assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+		assert.Equal(t, codeQuotaExceeded, decodeError(t, w.Body.Bytes(), nil).Code)
+		assert.Equal(t, "3", w.Header().Get(RateLimitKey))
+		assert.NotEmpty(t, w.Header().Get(RetryAfterKey))
+	}
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{})
+	contentsReq := func() *http.Request {
+		return newReq(http.MethodGet, "/file_contents/{md5}", "", map[string]string{"md5": md5})
+	}
+	w := httptest.NewRecorder()
+	apiService.FileContents(w, contentsReq())
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	apiService.FileContentsBatch(w, newReq(http.MethodPost, "/file_contents/batch", `{"md5s": ["`+md5+`", "`+md5[1:]+`0"]}`, nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	w = httptest.NewRecorder() // Duplicate and invalid md5s are not charged
+	apiService.FileContentsBatch(w, newReq(http.MethodPost, "/file_contents/batch",
+		`{"md5s": ["`+md5+`", "`+strings.ToUpper(md5)+`", "not-an-md5"]}`, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	apiService.FileContents(w, contentsReq())
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, codeQuotaExceeded, w.Header().Get(ErrorCodeKey))
+	assert.Empty(t, w.Header().Get(ETagKey))
+
+	// Usage is reported in the quotas metrics
+	w = httptest.NewRecorder()
+	MetricsHandler(w, newReq(http.MethodGet, "/metrics/{type}", "", map[string]string{"type": "quotas"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var metrics struct {
+		Date     string      `json:"date"`
+		Scan     classQuotas `json:"scan"`
+		Contents classQuotas `json:"contents"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("invalid quotas metrics %q: %v", w.Body.String(), err)
+	}
+	assert.Equal(t, time.Now().UTC().Format(time.DateOnly), metrics.Date)
+	assert.Equal(t, int64(3), metrics.Scan.DailyQuota)
+	assert.Empty(t, metrics.Scan.Clients)
+	assert.Equal(t, int64(2), metrics.Contents.DailyQuota)
+	client := metrics.Contents.Clients["ip:192.0.2.1"] // httptest's remote address
+	assert.Equal(t, int64(2), client.Files)
+	assert.Equal(t, int64(2), client.Rejected)
+	assert.True(t, strings.Contains(w.Body.String(), `"remaining":0`), w.Body.String())
+}
+
+func TestQuotaRefunds(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\n"
+	myConfig := setupConfig(t)
+	myConfig.Scanning.ScanDailyQuota = 2
+	myConfig.Scanning.ContentsDailyQuota = 1
+	for _, workers := range []int{1, 2} {
+		myConfig.Scanning.Workers = workers
+		engine := &fakeEngine{}
+		apiService := NewAPIServiceWithEngine(myConfig, engine)
+		engine.setScanErr(errors.New("engine failure"))
+		w := httptest.NewRecorder()
+		apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+		engine.setScanErr(nil)
+		w = httptest.NewRecorder()
+		apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, nil)) // The failed scan was refunded
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = httptest.NewRecorder()
+		apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	}
+	apiService := NewAPIServiceWithEngine(myConfig, &batchEngine{})
+	w := httptest.NewRecorder()
+	apiService.FileContents(w, newReq(http.MethodGet, "/file_contents/{md5}", "", map[string]string{"md5": batchFailing}))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = httptest.NewRecorder()
+	apiService.FileContentsBatch(w, newReq(http.MethodPost, "/file_contents/batch", `{"md5s": ["`+batchFailing+`"]}`, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder() // Neither failed lookup was charged
+	apiService.FileContents(w, newReq(http.MethodGet, "/file_contents/{md5}", "", map[string]string{"md5": batchFound}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	apiService.FileContents(w, newReq(http.MethodGet, "/file_contents/{md5}", "", map[string]string{"md5": batchFound}))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
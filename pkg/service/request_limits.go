@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Names of the request limits reported back to clients.
+const (
+	limitUploadSize       = "upload_size"        // Total request body size
+	limitWfpFiles         = "wfp_files"          // Number of WFP (file=) entries
+	limitSbomSize         = "sbom_size"          // Size of the supplied SBOM
+	limitBatchSize        = "batch_size"         // Number of md5s in a file contents batch
+	limitDecompressedSize = "decompressed_size"  // Size of a compressed request body once decompressed
+	limitFileContentsSize = "file_contents_size" // Size of the file contents returned
+)
+
+// limitRequestBody caps the size of the request body to the configured upload limit (if any).
+func (s APIService) limitRequestBody(w http.ResponseWriter, r *http.Request) {
+	if s.config.Scanning.MaxUploadSize > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.Scanning.MaxUploadSize*1024*1024)
+	}
+}
+
+// isMaxBytesError reports if the given error was caused by the request body exceeding its size limit.
+func isMaxBytesError(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// isBodyLimitError reports if the given error was caused by the request body exceeding its upload or decompressed size limit.
+func isBodyLimitError(err error) bool {
+	var limitErr *limitError
+	return isMaxBytesError(err) || errors.As(err, &limitErr)
+}
+
+// writeBodyLimitExceeded writes a 413 response if reading the request body failed because it exceeded its upload or
+// decompressed size limit, reporting if it did so.
+func (s APIService) writeBodyLimitExceeded(w http.ResponseWriter, err error, zs *zap.SugaredLogger) bool {
+	var limitErr *limitError
+	switch {
+	case isMaxBytesError(err):
+		writeLimitExceeded(w, limitUploadSize, s.config.Scanning.MaxUploadSize, "MB", zs)
+	case errors.As(err, &limitErr):
+		writeLimitExceeded(w, limitErr.limit, limitErr.maxValue, limitErr.unit, zs)
+	default:
+		return false
+	}
+	return true
+}
+
+// checkSbomSize reports if an SBOM of the given length is within the configured size limit, writing a 413 response if not.
+func (s APIService) checkSbomSize(w http.ResponseWriter, sbomLen int, zs *zap.SugaredLogger) bool {
+	maxSize := s.config.Scanning.MaxSbomSize
+	if maxSize > 0 && int64(sbomLen) > maxSize*1024*1024 {
+		zs.Warnf("SBOM size %d bytes exceeds limit %d MB", sbomLen, maxSize)
+		writeLimitExceeded(w, limitSbomSize, maxSize, "MB", zs)
+		return false
+	}
+	return true
+}
+
+// writeLimitExceeded responds with a 413 (Request Entity Too Large), reporting which limit was exceeded in the error details.
+func writeLimitExceeded(w http.ResponseWriter, limit string, maxValue int64, unit string, zs *zap.SugaredLogger) {
+	zs.Warnf("Rejecting request. Exceeded %v limit of %v %v", limit, maxValue, unit)
+	writeError(w, codeLimitExceeded, fmt.Sprintf("request exceeds the maximum %v (%v %v)", limit, maxValue, unit),
+		limitDetails{Limit: limit, Max: maxValue, Unit: unit}, zs)
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLimits(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.MaxUploadSize = 1
+	myConfig.Scanning.MaxWfpFiles = 2
+	myConfig.Scanning.MaxSbomSize = 1
+	apiService := NewAPIService(myConfig)
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n"
+	large := strings.Repeat("x", 1024*1024+1)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		wfp     string
+		fields  map[string]string
+		want    int
+		limit   string
+	}{
+		{name: "within limits", handler: apiService.ScanDirect, path: "/scan/direct", wfp: wfp + wfp, want: http.StatusOK},
+		{name: "paths containing file=", handler: apiService.ScanDirect, path: "/scan/direct",
+			wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,dir/file=a.py\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,file=b.py\n", want: http.StatusOK},
+		{name: "too many files", handler: apiService.ScanDirect, path: "/scan/direct", wfp: wfp + wfp + wfp, want: http.StatusRequestEntityTooLarge, limit: limitWfpFiles},
+		{name: "upload too large", handler: apiService.ScanDirect, path: "/scan/direct", wfp: wfp + large, want: http.StatusRequestEntityTooLarge, limit: limitUploadSize},
+		{name: "job upload too large", handler: apiService.SubmitScanJob, path: "/scan/jobs", wfp: wfp + large, want: http.StatusRequestEntityTooLarge, limit: limitUploadSize},
+		{name: "assets within limits", handler: apiService.ScanDirect, path: "/scan/direct", wfp: wfp, fields: map[string]string{"type": "identify", "assets": large[:512*1024]},
+			want: http.StatusOK},
+		{name: "attribution too large", handler: apiService.SbomAttribution, path: "/sbom/attribution", wfp: large, want: http.StatusRequestEntityTooLarge, limit: limitUploadSize},
+		{name: "attribution", handler: apiService.SbomAttribution, path: "/sbom/attribution", wfp: `{"components":[]}`, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, newScanReq(t, tt.path, tt.wfp, tt.fields))
+			resp := w.Result()
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, resp.StatusCode, string(body))
+			if len(tt.limit) > 0 {
+				assert.Equal(t, ApplicationJSON, resp.Header.Get(ContentTypeKey))
+				var details limitDetails
+				errResp := decodeError(t, body, &details)
+				assert.Equal(t, codeLimitExceeded, errResp.Code)
+				assert.Equal(t, tt.limit, details.Limit)
+				assert.Contains(t, errResp.Message, tt.limit)
+			}
+		})
+	}
+	// SBOM assets are checked separately from the overall upload size
+	myConfig.Scanning.MaxUploadSize = 0
+	w := httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, map[string]string{"type": "identify", "assets": large}))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), `"limit":"sbom_size"`)
+}
+
+func TestCheckSbomSize(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.MaxSbomSize = 1
+	apiService := NewAPIService(myConfig)
+	w := httptest.NewRecorder()
+	assert.True(t, apiService.checkSbomSize(w, 1024*1024, zlog.S))
+	assert.False(t, apiService.checkSbomSize(w, 1024*1024+1, zlog.S))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.JSONEq(t, `{"code":"limit_exceeded","message":"request exceeds the maximum sbom_size (1 MB)","request_id":"",`+
+		`"details":{"limit":"sbom_size","max":1,"unit":"MB"}}`, w.Body.String())
+	myConfig.Scanning.MaxSbomSize = 0 // unlimited
+	assert.True(t, apiService.checkSbomSize(httptest.NewRecorder(), 100*1024*1024, zlog.S))
+}
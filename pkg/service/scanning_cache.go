@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	myconfig "scanoss.com/go-api/pkg/config"
+	"scanoss.com/go-api/pkg/wfp"
+)
+
+// resultCache caches the scan result of each file, keyed on its WFP, the scanning configuration and the KB version.
+type resultCache struct {
+	backend   cacheBackend
+	mu        sync.Mutex
+	kbVersion string // KB version the cached results were produced with
+}
+
+// newResultCache creates the scan result cache configured for the server, or nil if caching is disabled.
+func newResultCache(config *myconfig.ServerConfig) *resultCache {
+	backend := newCacheBackend("result", config.Scanning.ResultCache, config.Scanning.ResultCacheSize, 0, config.Scanning.ResultCacheDir)
+	if backend == nil {
+		return nil
+	}
+	if !config.Scanning.LoadKbDetails {
+		zlog.S.Warnf("KB details are not being loaded (SCANOSS_LOAD_KB_DETAILS). Cached scan results will not be refreshed when the KB changes")
+	}
+	zlog.S.Infof("Caching scan results (%v)", config.Scanning.ResultCache)
+	return &resultCache{backend: backend}
+}
+
+// checkVersion clears the cache if the KB version has changed since the results were cached, and returns the current version.
+func (c *resultCache) checkVersion() string {
+	version := currentKBVersion()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.kbVersion) > 0 && c.kbVersion != version {
+		zlog.S.Infof("KB version changed from %v to %v. Clearing cached scan results", c.kbVersion, version)
+		c.backend.purge()
+	}
+	c.kbVersion = version
+	return version
+}
+
+// scanConfigKey returns the part of the cache key covering the scanning configuration and KB version used to produce a result.
+func scanConfigKey(config ScanningServiceConfig, kbVersion string) string {
+	sbomHash := sha256.Sum256([]byte(config.sbomFile))
+	return fmt.Sprintf("%v|%v|%v|%v|%x|%v|%v|%v|%v|%v", kbVersion, config.flags, config.dbName, config.sbomType, sbomHash,
+		config.rankingEnabled, config.rankingThreshold, config.minSnippetHits, config.minSnippetLines, config.honourFileExts)
+}
+
+// cachedScan tracks the cached results used by a scan request, and the files whose results need caching once scanned.
+type cachedScan struct {
+	cache     *resultCache
+	configKey string
+	keys      map[string]string // Path of each file sent to the engine -> its cache key
+	hits      []fileResult      // Cached results of the files which don't need scanning
+}
+
+// newScan starts looking up results in the cache for a scan with the given configuration.
+func (c *resultCache) newScan(config ScanningServiceConfig) *cachedScan {
+	if c == nil {
+		return nil
+	}
+	return &cachedScan{cache: c, configKey: scanConfigKey(config, c.checkVersion()), keys: make(map[string]string)}
+}
+
+// lookup reports if the result of the given WFP entry is cached, recording it as a hit if so.
+func (cs *cachedScan) lookup(entry string) bool {
+	file, err := wfp.NewReader(strings.NewReader(entry)).Next()
+	if err != nil {
+		return false // Leave anything we can't parse for the engine to deal with
+	}
+	sum := sha256.Sum256([]byte(cs.configKey + "\n" + file.String()))
+	key := hex.EncodeToString(sum[:])
+	if value, found := cs.cache.backend.get(key); found {
+		cs.hits = append(cs.hits, fileResult{File: file.Path, Result: value})
+		return true
+	}
+	cs.keys[file.Path] = key
+	return false
+}
+
+// filter wraps the given source, skipping any files with a cached result.
+func (cs *cachedScan) filter(source wfpSource) wfpSource {
+	return func() (string, error) {
+		for {
+			entry, err := source()
+			if err != nil {
+				return "", err
+			}
+			if !cs.lookup(entry) {
+				return entry, nil
+			}
+		}
+	}
+}
+
+// complete reports if every file in the scan had a cached result (so there is nothing to send to the engine).
+func (cs *cachedScan) complete() bool {
+	return cs != nil && len(cs.keys) == 0 && len(cs.hits) > 0
+}
+
+// recoverEmpty replaces the error from a scan which produced no results with an empty result, if there are cached results to return instead.
+// Any files which failed to scan are still reported alongside the cached results.
+func (cs *cachedScan) recoverEmpty(result string, failures []scanFailure, err error) (string, error) {
+	if err != nil && cs != nil && len(cs.hits) > 0 && (len(cs.keys) == 0 || len(failures) > 0) {
+		return "{}", nil
+	}
+	return result, err
+}
+
+// store caches the results of the scanned files in the given (JSON object) scan result.
+func (cs *cachedScan) store(result string, zs *zap.SugaredLogger) {
+	if cs == nil || len(cs.keys) == 0 || len(result) == 0 {
+		return
+	}
+	files, err := splitScanResult(result)
+	if err != nil {
+		zs.Warnf("Failed to parse scan result to cache: %v", err)
+		return
+	}
+	for _, file := range files {
+		if key, found := cs.keys[file.File]; found {
+			cs.cache.backend.set(key, file.Result)
+		}
+	}
+}
+
+// finish caches the newly scanned results and adds the cached results to the given scan result.
+// It also returns the number of cached results added.
+func (cs *cachedScan) finish(result string, zs *zap.SugaredLogger) (string, int) {
+	if cs == nil {
+		return result, 0
+	}
+	cs.store(result, zs)
+	return appendFileResults(result, cs.hits), len(cs.hits)
+}
+
+// cacheRequest removes any files with a cached result from a (fully read) scan request, so that only the rest are scanned.
+func (s APIService) cacheRequest(req *scanRequest, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) {
+	cached := s.results.newScan(req.config)
+	if cached == nil {
+		return
+	}
+	source := cached.filter(sliceWfpSource(req.wfps))
+	var remaining []string
+	for entry, err := source(); err == nil; entry, err = source() {
+		remaining = append(remaining, entry)
+	}
+	s.recordCacheStats(cached, zs, context, span)
+	req.cached = cached
+	if len(cached.hits) > 0 {
+		req.setWfps(remaining)
+	}
+}
+
+// recordCacheStats records the number of scan result cache hits & misses for metrics.
+func (s APIService) recordCacheStats(cached *cachedScan, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) {
+	if cached == nil {
+		return
+	}
+	hits, misses := int64(len(cached.hits)), int64(len(cached.keys))
+	zs.Debugf("Scan result cache hits: %v, misses: %v", hits, misses)
+	counters.incRequestAmount("cache_hits", hits)
+	counters.incRequestAmount("cache_misses", misses)
+	if s.config.Telemetry.Enabled {
+		oltpMetrics.scanCacheHitCounter.Add(context, hits)
+		oltpMetrics.scanCacheMissCounter.Add(context, misses)
+		span.SetAttributes(attribute.Int64("scan.cache_hits", hits), attribute.Int64("scan.cache_misses", misses))
+	}
+}
@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewResultCache(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	assert.Nil(t, newResultCache(myConfig))
+	myConfig.Scanning.ResultCache = "unknown"
+	assert.Nil(t, newResultCache(myConfig))
+	myConfig.Scanning.ResultCache = cacheMemory
+	cache := newResultCache(myConfig)
+	if assert.NotNil(t, cache) {
+		assert.IsType(t, &memoryCache{}, cache.backend)
+	}
+	myConfig.Scanning.ResultCache = cacheDisk
+	myConfig.Scanning.ResultCacheDir = t.TempDir()
+	myConfig.Scanning.ResultCacheSize = 2
+	cache = newResultCache(myConfig)
+	if assert.NotNil(t, cache) {
+		assert.IsType(t, &diskCache{}, cache.backend)
+		for _, key := range []string{"0123456789abcdef", "1123456789abcdef", "2123456789abcdef"} {
+			cache.backend.set(key, []byte(`[{"id":"none"}]`))
+		}
+		_, found := cache.backend.get("0123456789abcdef") // The entry limit applies to the disk cache too
+		assert.False(t, found)
+		_, found = cache.backend.get("2123456789abcdef")
+		assert.True(t, found)
+	}
+	myConfig.Scanning.ResultCacheDir = "/dev/null/cache" // Cannot be created
+	assert.Nil(t, newResultCache(myConfig))
+	var none *resultCache
+	assert.Nil(t, none.newScan(ScanningServiceConfig{}))
+}
+
+func TestResultCacheKeys(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	defer kbVersion.Store(currentKBVersion())
+	kbVersion.Store("24.01/24.01.01")
+	cache := &resultCache{backend: newMemoryCache(0, 0)}
+	config := ScanningServiceConfig{flags: 16, dbName: "oss"}
+	const entry = "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=579a7cd6"
+
+	scan := cache.newScan(config)
+	assert.False(t, scan.lookup(entry))
+	scan.store(`{"a.py":[{"id":"file"}]}`, zlog.S)
+	assert.True(t, cache.newScan(config).lookup(entry))
+	assert.True(t, cache.newScan(config).lookup("file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\r\n\r\n4=579a7cd6\n")) // Same WFP
+
+	assert.False(t, cache.newScan(config).lookup("file=37f7cd1e657aa3c30ece35995b4c59e5,3114,b.py\n4=579a7cd6"))
+	assert.False(t, cache.newScan(ScanningServiceConfig{flags: 32, dbName: "oss"}).lookup(entry))
+	assert.False(t, cache.newScan(ScanningServiceConfig{flags: 16, dbName: "oss", sbomType: sbomIdentify, sbomFile: `{"components":[]}`}).lookup(entry))
+	assert.False(t, cache.newScan(ScanningServiceConfig{flags: 16, dbName: "oss", rankingEnabled: true}).lookup(entry))
+	assert.False(t, cache.newScan(ScanningServiceConfig{flags: 16, dbName: "oss", minSnippetHits: 3}).lookup(entry))
+
+	// A new KB version clears the cache
+	kbVersion.Store("24.01/24.01.02")
+	scan = cache.newScan(config)
+	assert.False(t, scan.lookup(entry))
+	assert.Equal(t, 0, cache.backend.(*memoryCache).order.Len())
+
+	// Cached results are added to the scanned ones
+	scan.store(`{"a.py":[{"id":"file"}]}`, zlog.S)
+	scan = cache.newScan(config)
+	assert.True(t, scan.lookup(entry))
+	assert.False(t, scan.lookup("file=7c53a2de7dfeaa20d057db98468d6670,2321,b.py"))
+	assert.False(t, scan.complete())
+	result, hits := scan.finish(`{"b.py":[{"id":"none"}]}`, zlog.S)
+	assert.Equal(t, 1, hits)
+	assert.JSONEq(t, `{"b.py":[{"id":"none"}],"a.py":[{"id":"file"}]}`, result)
+	result, err = scan.recoverEmpty("", []scanFailure{{File: "b.py", Reason: failureTimeout}}, &scanError{status: http.StatusInternalServerError})
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", result)
+	scan = cache.newScan(config)
+	assert.True(t, scan.lookup(entry))
+	assert.True(t, scan.complete())
+	result, _ = scan.finish("{}", zlog.S)
+	assert.JSONEq(t, `{"a.py":[{"id":"file"}]}`, result)
+}
+
+func TestScanDirectCache(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.WfpGrouping = 2
+	myConfig.Scanning.ResultCache = cacheMemory
+	myConfig.Scanning.DedupeFiles = true // Duplicates are not scanned (or cached) separately
+	files := []string{"vendor/a/lib.py", "main.py", "vendor/b/lib.py", "vendor/c/lib.py", "other.py"}
+
+	tests := []struct {
+		name    string
+		workers int
+		stream  bool
+		ndjson  bool
+	}{
+		{name: "single worker", workers: 1},
+		{name: "multiple workers", workers: 2},
+		{name: "streamed upload", workers: 2, stream: true},
+		{name: "ndjson", workers: 2, ndjson: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myConfig.Scanning.Workers = tt.workers
+			myConfig.Scanning.StreamUploads = tt.stream
+			engine := &countingEngine{}
+			apiService := NewAPIServiceWithEngine(myConfig, engine)
+			scan := func(wfp string) []string {
+				req := newScanReq(t, "/scan/direct", wfp, map[string]string{"flags": "16"})
+				if tt.ndjson {
+					req.Header.Set(AcceptKey, ApplicationNDJSON)
+				}
+				w := httptest.NewRecorder()
+				apiService.ScanDirect(w, req)
+				assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				var scanned []string
+				if tt.ndjson {
+					dec := json.NewDecoder(w.Body)
+					for dec.More() {
+						var line fileResult
+						if err := dec.Decode(&line); err != nil {
+							t.Fatal(err)
+						}
+						if len(line.File) > 0 {
+							scanned = append(scanned, line.File)
+						}
+					}
+					return scanned
+				}
+				var result map[string]json.RawMessage
+				if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+					t.Fatalf("invalid scan result %v: %v", w.Body.String(), err)
+				}
+				for file := range result {
+					scanned = append(scanned, file)
+				}
+				return scanned
+			}
+			assert.ElementsMatch(t, files, scan(dedupeWfp))
+			assert.Equal(t, int64(3), engine.files.Load())
+			assert.ElementsMatch(t, files, scan(dedupeWfp)) // Every result is cached now
+			assert.Equal(t, int64(3), engine.files.Load())
+			assert.ElementsMatch(t, append(files, "new.py"), scan(dedupeWfp+"file=d41d8cd98f00b204e9800998ecf8427e,0,new.py\n"))
+			assert.Equal(t, int64(4), engine.files.Load())
+		})
+	}
+}
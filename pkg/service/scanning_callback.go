@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Scan job callback headers.
+const (
+	CallbackSignatureKey = "X-Scanoss-Signature"  // HMAC-SHA256 signature of the callback timestamp and payload
+	CallbackTimestampKey = "X-Scanoss-Timestamp"  // Time the callback was signed (Unix seconds)
+	CallbackJobIDKey     = "X-Scanoss-Job-Id"     // ID of the scan job the callback refers to
+	CallbackJobStatusKey = "X-Scanoss-Job-Status" // Final status of the scan job (completed/failed)
+)
+
+// Scan job callback states.
+const (
+	callbackPending   = "pending"
+	callbackDelivered = "delivered"
+	callbackFailed    = "failed"
+)
+
+// isSafeCallbackURL checks if the provided callback URL uses HTTP(S) and belongs to the server's list of allowed callback hosts.
+func (s APIService) isSafeCallbackURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	for _, host := range s.config.Scanning.CallbackAllowedHosts {
+		if strings.EqualFold(u.Hostname(), strings.TrimSpace(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCallbackURL checks that callbacks can be signed (SCAN_CALLBACK_SECRET is set) and the callback URL is allowed.
+// On failure, the error is written to the response and false is returned.
+func (s APIService) checkCallbackURL(w http.ResponseWriter, callbackURL string, zs *zap.SugaredLogger, span oteltrace.Span) bool {
+	if len(s.config.Scanning.CallbackSecret) == 0 {
+		zs.Errorf("Callback URL supplied, but no callback secret is configured to sign it: %v", callbackURL)
+		writeError(w, codeFeatureDisabled, "scan job callbacks are disabled (no callback secret configured)", nil, zs)
+		setSpanError(span, "Callbacks disabled.")
+		return false
+	}
+	if !s.isSafeCallbackURL(callbackURL) {
+		zs.Errorf("Disallowed callback URL: %v", callbackURL)
+		writeError(w, codeInvalidRequest, "callback url is not in the allowed list of hosts", nil, zs)
+		setSpanError(span, "Disallowed callback URL.")
+		return false
+	}
+	return true
+}
+
+// signCallbackPayload returns the HMAC-SHA256 signature header value for the given timestamp and payload,
+// signed as "<timestamp>.<payload>", so that receivers can reject replayed callbacks.
+func signCallbackPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendScanJobCallback posts the outcome of a finished scan job to the callback URL supplied with the request.
+// Completed jobs send the merged scan results, while failed jobs send the job status (including the error).
+// Delivery (including any retries) stops if the context is cancelled.
+func (s APIService) sendScanJobCallback(ctx context.Context, job *scanJob, zs *zap.SugaredLogger) {
+	details := job.statusDetails()
+	job.mu.Lock()
+	callbackURL, result := job.request.config.callbackURL, job.result
+	job.mu.Unlock()
+	var payload []byte
+	if details.Status == jobCompleted {
+		payload = []byte(result)
+	} else {
+		var err error
+		if payload, err = json.Marshal(details); err != nil {
+			zs.Errorf("Failed to marshal scan job %v callback status: %v", job.id, err)
+			job.setCallbackStatus(callbackFailed)
+			return
+		}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := map[string]string{ContentTypeKey: ApplicationJSON, CallbackJobIDKey: job.id, CallbackJobStatusKey: details.Status,
+		CallbackTimestampKey: timestamp, CallbackSignatureKey: signCallbackPayload(s.config.Scanning.CallbackSecret, timestamp, payload)}
+	if err := s.deliverCallback(ctx, callbackURL, payload, headers, zs); err != nil {
+		zs.Errorf("Failed to deliver scan job %v callback to %v: %v", job.id, callbackURL, err)
+		job.setCallbackStatus(callbackFailed)
+		return
+	}
+	zs.Infof("Delivered scan job %v callback to %v", job.id, callbackURL)
+	job.setCallbackStatus(callbackDelivered)
+}
+
+// setCallbackStatus records the state of the job completion callback.
+func (job *scanJob) setCallbackStatus(status string) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.callback = status
+}
+
+// deliverCallback posts the payload to the given URL, retrying with an exponential backoff on failure,
+// until the context is cancelled.
+func (s APIService) deliverCallback(ctx context.Context, callbackURL string, payload []byte, headers map[string]string, zs *zap.SugaredLogger) error {
+	client := &http.Client{
+		Timeout: time.Duration(s.config.Scanning.CallbackTimeout) * time.Second,
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse // Do not follow redirects away from the allowed host
+		},
+	}
+	backoff := time.Duration(s.config.Scanning.CallbackBackoff) * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= s.config.Scanning.CallbackRetries; attempt++ {
+		if attempt > 0 {
+			zs.Debugf("Retrying callback to %v in %v (attempt %v): %v", callbackURL, backoff, attempt, err)
+			if !sleepContext(ctx, backoff) {
+				return fmt.Errorf("callback cancelled: %w (last error: %v)", ctx.Err(), err)
+			}
+			backoff *= 2
+		}
+		var retry bool
+		retry, err = postCallback(ctx, client, callbackURL, payload, headers)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// postCallback makes a single callback attempt, reporting if a failure is worth retrying.
+func postCallback(ctx context.Context, client *http.Client, callbackURL string, payload []byte, headers map[string]string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		return false, fmt.Errorf("failed to create callback request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	//nolint:gosec // The callback URL has been checked against the allowed list of hosts
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("callback request failed: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return true, nil
+	}
+	// Client errors (other than timeouts & rate limiting) will not succeed on retry
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("callback responded with status: %v", resp.Status)
+}
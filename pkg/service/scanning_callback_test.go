@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// callbackReceiver records the callbacks posted to it, failing the first few attempts.
+type callbackReceiver struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (c *callbackReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+	if c.attempts <= c.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	c.bodies = append(c.bodies, string(body))
+	c.headers = append(c.headers, r.Header.Clone())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *callbackReceiver) delivered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.bodies)
+}
+
+func TestScanJobCallback(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	receiver := &callbackReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	myConfig := setupConfig(t)
+	myConfig.Scanning.JobWorkers = 1
+	myConfig.Scanning.CallbackAllowedHosts = []string{"127.0.0.1"}
+	myConfig.Scanning.CallbackSecret = "test-secret"
+	myConfig.Scanning.CallbackBackoff = 1
+	engine := &fakeEngine{}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	t.Cleanup(apiService.SetupScanJobs())
+
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,test.py\n4=d5e54c33"
+	req := newScanReq(t, "http://localhost/scan/jobs", wfp, map[string]string{"callback_url": server.URL + "/hook"})
+	w := httptest.NewRecorder()
+	apiService.SubmitScanJob(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	var submitted scanJobStatus
+	assert.NoError(t, json.NewDecoder(w.Result().Body).Decode(&submitted))
+	assert.Equal(t, callbackPending, submitted.Callback)
+
+	assert.Eventually(t, func() bool { return receiver.delivered() == 1 }, 5*time.Second, 10*time.Millisecond)
+	receiver.mu.Lock()
+	body, headers := receiver.bodies[0], receiver.headers[0]
+	assert.Equal(t, 3, receiver.attempts)
+	receiver.mu.Unlock()
+	assert.Equal(t, `{"test.py":[{"id":"none"}]}`, body)
+	assert.Equal(t, submitted.ID, headers.Get(CallbackJobIDKey))
+	assert.Equal(t, jobCompleted, headers.Get(CallbackJobStatusKey))
+	timestamp, err := strconv.ParseInt(headers.Get(CallbackTimestampKey), 10, 64)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), time.Unix(timestamp, 0), time.Minute)
+	assert.Equal(t, signCallbackPayload("test-secret", headers.Get(CallbackTimestampKey), []byte(body)), headers.Get(CallbackSignatureKey))
+	assert.NotEqual(t, signCallbackPayload("test-secret", "0", []byte(body)), headers.Get(CallbackSignatureKey))
+	assert.Eventually(t, func() bool {
+		code, body := getJob(t, apiService.ScanJobStatus, "http://localhost/scan/jobs/{id}", submitted.ID)
+		return code == http.StatusOK && jsonField(body, "callback") == callbackDelivered
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Failed scans should post the job status (including the error)
+	engine.setScanErr(fmt.Errorf("engine failure"))
+	req = newScanReq(t, "http://localhost/scan/jobs", wfp, map[string]string{"callback_url": server.URL + "/hook"})
+	w = httptest.NewRecorder()
+	apiService.SubmitScanJob(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	assert.Eventually(t, func() bool { return receiver.delivered() == 2 }, 5*time.Second, 10*time.Millisecond)
+	receiver.mu.Lock()
+	body, headers = receiver.bodies[1], receiver.headers[1]
+	receiver.mu.Unlock()
+	assert.Equal(t, jobFailed, headers.Get(CallbackJobStatusKey))
+	var failed scanJobStatus
+	assert.NoError(t, json.Unmarshal([]byte(body), &failed))
+	assert.Equal(t, jobFailed, failed.Status)
+	assert.Equal(t, "engine scan failed", failed.Error)
+
+	// Callbacks to hosts outside the allowed list should be rejected up front
+	req = newScanReq(t, "http://localhost/scan/jobs", wfp, map[string]string{"callback_url": "http://example.com/hook"})
+	w = httptest.NewRecorder()
+	apiService.SubmitScanJob(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	// Callbacks cannot be sent unsigned, so are refused without a secret
+	myConfig.Scanning.CallbackSecret = ""
+	req = newScanReq(t, "http://localhost/scan/jobs", wfp, map[string]string{"callback_url": server.URL + "/hook"})
+	w = httptest.NewRecorder()
+	apiService.SubmitScanJob(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	// Synchronous scans ignore the callback, so shouldn't reject it
+	engine.setScanErr(nil)
+	req = newScanReq(t, "http://localhost/scan/direct", wfp, map[string]string{"callback_url": "http://example.com/hook"})
+	w = httptest.NewRecorder()
+	apiService.ScanDirect(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestScanJobCallbackGiveUp(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.CallbackRetries = 2
+	myConfig.Scanning.CallbackBackoff = 1
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{})
+
+	receiver := &callbackReceiver{failures: 10}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	err = apiService.deliverCallback(context.Background(), server.URL, []byte("{}"), nil, zlog.S)
+	assert.Error(t, err)
+	assert.Equal(t, 3, receiver.attempts) // initial attempt plus two retries
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	err = apiService.deliverCallback(context.Background(), notFound.URL, []byte("{}"), nil, zlog.S)
+	assert.Error(t, err) // client errors are not retried
+
+	// Retries stop as soon as the context is cancelled
+	myConfig.Scanning.CallbackBackoff = 60000
+	receiver = &callbackReceiver{failures: 10}
+	retrying := httptest.NewServer(receiver)
+	defer retrying.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err = apiService.deliverCallback(ctx, retrying.URL, []byte("{}"), nil, zlog.S)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 1, receiver.attempts)
+}
+
+func TestIsSafeCallbackURL(t *testing.T) {
+	myConfig := setupConfig(t)
+	myConfig.Scanning.CallbackAllowedHosts = []string{"ci.example.com", " 127.0.0.1 "}
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{})
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://ci.example.com/hooks/scan", true},
+		{"http://CI.example.com:8080/hook", true},
+		{"http://127.0.0.1:9000/hook", true},
+		{"https://other.example.com/hook", false},
+		{"https://ci.example.com.evil.com/hook", false},
+		{"ftp://ci.example.com/hook", false},
+		{"ci.example.com/hook", false},
+		{"://bad-url", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			assert.Equal(t, tt.want, apiService.isSafeCallbackURL(tt.url))
+		})
+	}
+	myConfig.Scanning.CallbackAllowedHosts = nil
+	assert.False(t, apiService.isSafeCallbackURL("https://ci.example.com/hooks/scan"))
+}
+
+// jsonField returns the string value of the given top level field in a JSON document.
+func jsonField(body, field string) string {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		return ""
+	}
+	value, _ := fields[field].(string)
+	return value
+}
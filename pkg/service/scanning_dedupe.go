@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"scanoss.com/go-api/pkg/wfp"
+)
+
+// wfpDeduper filters identical files (same md5 & fingerprints) out of the WFPs to scan,
+// remembering which scanned file each duplicate should share its result with.
+// When file extensions are honoured, the engine filters snippet matches by extension, so only files with the same extension are identical.
+type wfpDeduper struct {
+	seen       map[string]string   // Fingerprint of each scanned file -> its path
+	duplicates map[string][]string // Path of each scanned file -> paths of its duplicates
+	count      int                 // Number of duplicate files removed
+	fileExts   bool                // File extensions are part of the fingerprint
+}
+
+// newWfpDeduper creates an empty WFP deduplicator, including file extensions in the fingerprint if they are honoured.
+func newWfpDeduper(honourFileExts bool) *wfpDeduper {
+	return &wfpDeduper{seen: make(map[string]string), duplicates: make(map[string][]string), fileExts: honourFileExts}
+}
+
+// wfpFingerprint returns the key shared by identical WFP file entries (everything but the path, plus the file extension if requested),
+// along with the path itself.
+func wfpFingerprint(entry string, fileExt bool) (string, string, error) {
+	file, err := wfp.NewReader(strings.NewReader(entry)).Next()
+	if err != nil {
+		return "", "", err
+	}
+	filePath := file.Path
+	file.Path = ""
+	if fileExt {
+		file.Path = path.Ext(filePath)
+	}
+	return file.String(), filePath, nil
+}
+
+// duplicate reports if the given WFP entry is identical to a file already seen, recording it against that file if so.
+func (d *wfpDeduper) duplicate(entry string) bool {
+	key, filePath, err := wfpFingerprint(entry, d.fileExts)
+	if err != nil {
+		return false // Leave anything we can't parse for the engine to deal with
+	}
+	original, found := d.seen[key]
+	if !found {
+		d.seen[key] = filePath
+		return false
+	}
+	if filePath != original { // The same file listed twice only needs one result
+		d.duplicates[original] = append(d.duplicates[original], filePath)
+	}
+	d.count++
+	return true
+}
+
+// filter wraps the given source, skipping any files identical to one already returned.
+func (d *wfpDeduper) filter(source wfpSource) wfpSource {
+	return func() (string, error) {
+		for {
+			entry, err := source()
+			if err != nil {
+				return "", err
+			}
+			if !d.duplicate(entry) {
+				return entry, nil
+			}
+		}
+	}
+}
+
+// expandFiles returns a copy of the result of each of the given scanned files for each of its duplicates.
+func (d *wfpDeduper) expandFiles(files []fileResult) []fileResult {
+	if d == nil {
+		return nil
+	}
+	var extra []fileResult
+	for _, file := range files {
+		for _, path := range d.duplicates[file.File] {
+			extra = append(extra, fileResult{File: path, Result: file.Result})
+		}
+	}
+	return extra
+}
+
+// expandResult adds the results of the duplicate files to the given (JSON object) scan result.
+// It also returns the number of results added.
+func (d *wfpDeduper) expandResult(result string, zs *zap.SugaredLogger) (string, int) {
+	if d == nil || len(d.duplicates) == 0 || len(result) == 0 {
+		return result, 0
+	}
+	files, err := splitScanResult(result)
+	if err != nil {
+		zs.Warnf("Failed to parse scan result to copy to duplicate files: %v", err)
+		return result, 0
+	}
+	extra := d.expandFiles(files)
+	return appendFileResults(result, extra), len(extra)
+}
+
+// expandFailures adds a failure for each duplicate of the given failed files.
+func (d *wfpDeduper) expandFailures(failures []scanFailure) []scanFailure {
+	if d == nil || len(d.duplicates) == 0 {
+		return failures
+	}
+	expanded := failures
+	for _, failure := range failures {
+		for _, path := range d.duplicates[failure.File] {
+			expanded = append(expanded, scanFailure{File: path, Reason: failure.Reason})
+		}
+	}
+	return expanded
+}
+
+// dedupeRequest removes any duplicate files from a (fully read) scan request, so that each unique file is only scanned once.
+func (s APIService) dedupeRequest(req *scanRequest, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) {
+	if !s.config.Scanning.DedupeFiles {
+		return
+	}
+	deduper := newWfpDeduper(req.config.honourFileExts)
+	source := deduper.filter(sliceWfpSource(req.wfps))
+	var unique []string
+	for entry, err := source(); err == nil; entry, err = source() {
+		unique = append(unique, entry)
+	}
+	s.recordDedupe(deduper, zs, context, span)
+	if deduper.count == 0 {
+		return
+	}
+	req.dedupe = deduper
+	req.setWfps(unique)
+}
+
+// recordDedupe records the number of duplicate files removed from a scan request for metrics.
+func (s APIService) recordDedupe(deduper *wfpDeduper, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) {
+	if deduper.count == 0 {
+		return
+	}
+	zs.Infof("Removed %v duplicate files from the scan", deduper.count)
+	counters.incRequestAmount("dedupe_files", int64(deduper.count))
+	if s.config.Telemetry.Enabled {
+		oltpMetrics.scanDedupeCounter.Add(context, int64(deduper.count))
+		span.SetAttributes(attribute.Int("scan.dedupe_files", deduper.count))
+	}
+}
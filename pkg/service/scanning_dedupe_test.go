@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// countingEngine is a fake engine which counts the number of files it is asked to scan.
+type countingEngine struct {
+	fakeEngine
+	files atomic.Int64
+}
+
+func (c *countingEngine) Scan(ctx context.Context, wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, bool, error) {
+	c.files.Add(int64(strings.Count(wfp, "file=")))
+	return c.fakeEngine.Scan(ctx, wfp, sbomFile, config, zs)
+}
+
+const dedupeWfp = "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,vendor/a/lib.py\n4=579a7cd6\n" +
+	"file=7c53a2de7dfeaa20d057db98468d6670,2321,main.py\n" +
+	"file=37f7cd1e657aa3c30ece35995b4c59e5,3114,vendor/b/lib.py\n4=579a7cd6\n" +
+	"file=37f7cd1e657aa3c30ece35995b4c59e5,3114,vendor/c/lib.py\n4=579a7cd6\n" +
+	"file=37f7cd1e657aa3c30ece35995b4c59e5,3114,other.py\n4=579a7cd6\n8=8a5e2b7c\n"
+
+func TestWfpDeduper(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	deduper := newWfpDeduper(false)
+	source := deduper.filter(sliceWfpSource(strings.Split(dedupeWfp, "file=")))
+	var paths []string
+	for entry, err := source(); err == nil; entry, err = source() {
+		paths = append(paths, strings.SplitN(strings.Split(entry, "\n")[0], ",", 3)[2])
+	}
+	assert.Equal(t, []string{"vendor/a/lib.py", "main.py", "other.py"}, paths) // Same md5, different snippets is still scanned
+	assert.Equal(t, 2, deduper.count)
+	assert.Equal(t, map[string][]string{"vendor/a/lib.py": {"vendor/b/lib.py", "vendor/c/lib.py"}}, deduper.duplicates)
+	assert.True(t, deduper.duplicate("file=7c53a2de7dfeaa20d057db98468d6670,2321,main.py"))
+	assert.Len(t, deduper.duplicates, 1) // The same path twice only needs one result
+	assert.False(t, deduper.duplicate("not a wfp"))
+
+	result, added := deduper.expandResult(`{"vendor/a/lib.py":[{"id":"file"}],"main.py":[{"id":"none"}]}`, zlog.S)
+	assert.Equal(t, 2, added)
+	assert.JSONEq(t, `{"vendor/a/lib.py":[{"id":"file"}],"main.py":[{"id":"none"}],"vendor/b/lib.py":[{"id":"file"}],"vendor/c/lib.py":[{"id":"file"}]}`, result)
+	result, added = deduper.expandResult(`{"main.py":[{"id":"none"}]}`, zlog.S)
+	assert.Equal(t, 0, added)
+	assert.Equal(t, `{"main.py":[{"id":"none"}]}`, result)
+	result, _ = deduper.expandResult("not json", zlog.S)
+	assert.Equal(t, "not json", result)
+	assert.Equal(t, []scanFailure{{File: "vendor/a/lib.py", Reason: failureTimeout}, {File: "vendor/b/lib.py", Reason: failureTimeout},
+		{File: "vendor/c/lib.py", Reason: failureTimeout}}, deduper.expandFailures([]scanFailure{{File: "vendor/a/lib.py", Reason: failureTimeout}}))
+
+	var none *wfpDeduper
+	result, added = none.expandResult(`{"main.py":[]}`, zlog.S)
+	assert.Equal(t, `{"main.py":[]}`, result)
+	assert.Equal(t, 0, added)
+	assert.Nil(t, none.expandFiles([]fileResult{{File: "main.py"}}))
+	assert.Empty(t, none.expandFailures(nil))
+}
+
+func TestWfpDeduperFileExts(t *testing.T) {
+	entry := func(path string) string {
+		return "file=37f7cd1e657aa3c30ece35995b4c59e5,3114," + path + "\n4=579a7cd6"
+	}
+	// Snippet matches are filtered by extension when honoured, so only files with the same extension share a result
+	deduper := newWfpDeduper(true)
+	assert.False(t, deduper.duplicate(entry("src/a.c")))
+	assert.False(t, deduper.duplicate(entry("src/a.h")))
+	assert.True(t, deduper.duplicate(entry("other/b.c")))
+	assert.True(t, deduper.duplicate(entry("other/b.h")))
+	assert.False(t, deduper.duplicate(entry("Makefile")))
+	assert.Equal(t, map[string][]string{"src/a.c": {"other/b.c"}, "src/a.h": {"other/b.h"}}, deduper.duplicates)
+
+	deduper = newWfpDeduper(false)
+	assert.False(t, deduper.duplicate(entry("src/a.c")))
+	assert.True(t, deduper.duplicate(entry("src/a.h")))
+}
+
+func TestScanDirectDedupe(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.WfpGrouping = 2
+
+	tests := []struct {
+		name    string
+		workers int
+		ndjson  bool
+		dedupe  bool
+		scanned int64
+	}{
+		{name: "single worker", workers: 1, dedupe: true, scanned: 3},
+		{name: "multiple workers", workers: 2, dedupe: true, scanned: 3},
+		{name: "ndjson", workers: 2, ndjson: true, dedupe: true, scanned: 3},
+		{name: "disabled", workers: 2, scanned: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myConfig.Scanning.Workers = tt.workers
+			myConfig.Scanning.DedupeFiles = tt.dedupe
+			engine := &countingEngine{}
+			apiService := NewAPIServiceWithEngine(myConfig, engine)
+			req := newScanReq(t, "/scan/direct", dedupeWfp, nil)
+			if tt.ndjson {
+				req.Header.Set(AcceptKey, ApplicationNDJSON)
+			}
+			w := httptest.NewRecorder()
+			apiService.ScanDirect(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Equal(t, tt.scanned, engine.files.Load())
+			var files []string
+			if tt.ndjson {
+				dec := json.NewDecoder(w.Body)
+				for {
+					var line fileResult
+					if err := dec.Decode(&line); err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatal(err)
+					}
+					if len(line.File) > 0 {
+						files = append(files, line.File)
+					}
+				}
+			} else {
+				var result map[string]json.RawMessage
+				if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+					t.Fatalf("invalid scan result %v: %v", w.Body.String(), err)
+				}
+				for file := range result {
+					files = append(files, file)
+				}
+			}
+			assert.ElementsMatch(t, []string{"vendor/a/lib.py", "main.py", "vendor/b/lib.py", "vendor/c/lib.py", "other.py"}, files)
+		})
+	}
+	// Failed files are reported against their duplicates too
+	myConfig.Scanning.Workers = 2
+	myConfig.Scanning.DedupeFiles = true
+	myConfig.Scanning.ScanRetries = 0
+	apiService := NewAPIServiceWithEngine(myConfig, &countingEngine{fakeEngine: fakeEngine{failOn: "vendor/a/lib.py"}})
+	w := httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "/scan/direct", dedupeWfp, nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "4", w.Header().Get(ScanFailedFilesKey)) // The failed chunk also holds main.py
+	failed := w.Header().Get(ScanFailuresKey)
+	for _, file := range []string{"vendor/a/lib.py", "vendor/b/lib.py", "vendor/c/lib.py"} {
+		assert.Contains(t, failed, url.QueryEscape(file))
+	}
+}
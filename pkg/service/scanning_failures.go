@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Reasons for a file failing to scan.
+const (
+	failureTimeout     = "timeout"
+	failureEngineError = "engine_error"
+	failureEngineBusy  = "engine_busy"
+)
+
+const maxFailureHeaderLen = 4096 // Maximum length of the failed files header value
+
+// scanFailure records a file which could not be scanned and why.
+type scanFailure struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// chunkResult is the outcome of scanning a single chunk of WFPs in a worker.
+type chunkResult struct {
+	result   string        // Scan result without the surrounding brackets
+	failures []scanFailure // Files which failed to scan
+}
+
+// chunkStats summarises the outcome of a multi-worker scan.
+type chunkStats struct {
+	requests int           // Number of chunks sent to the workers
+	received int           // Number of chunks returning results
+	failures []scanFailure // Files which failed to scan
+	err      error         // Failure reading the WFPs to scan (if any)
+}
+
+// partialScanResponse is the JSON body returned for a partially failed scan (when not using a 200 status).
+type partialScanResponse struct {
+	Results  json.RawMessage `json:"results"`
+	Failures []scanFailure   `json:"failures"`
+}
+
+// failureReason returns the failure reason for a failed engine invocation.
+func failureReason(timedOut bool) string {
+	if timedOut {
+		return failureTimeout
+	}
+	return failureEngineError
+}
+
+// noResultsError returns the error to report when a scan produced no results at all.
+// If every file failed because the engine was busy, the client is told to retry later.
+func noResultsError(failures []scanFailure) *scanError {
+	if len(failures) == 0 {
+		return &scanError{status: http.StatusInternalServerError, code: codeEngineError, message: "engine scan failed"}
+	}
+	for _, failure := range failures {
+		if failure.Reason != failureEngineBusy {
+			return &scanError{status: http.StatusInternalServerError, code: codeEngineError, message: "engine scan failed"}
+		}
+	}
+	return &scanError{status: http.StatusServiceUnavailable, code: codeEngineBusy, message: "engine busy, please retry later"}
+}
+
+// wfpFilePaths returns the paths of all the files (file=<md5>,<size>,<path>) in the given WFP.
+func wfpFilePaths(wfp string) []string {
+	var paths []string
+	for _, line := range strings.Split(wfp, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "file=") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(line, "file="), ",", 3)
+		if len(parts) == 3 {
+			paths = append(paths, parts[2])
+		} else {
+			paths = append(paths, parts[0]) // Fall back to the MD5 if there is no path
+		}
+	}
+	return paths
+}
+
+// chunkFailures returns a failure with the given reason for every file in the WFP chunk.
+func chunkFailures(wfp, reason string) []scanFailure {
+	paths := wfpFilePaths(wfp)
+	failures := make([]scanFailure, 0, len(paths))
+	for _, path := range paths {
+		failures = append(failures, scanFailure{File: path, Reason: reason})
+	}
+	return failures
+}
+
+// setFailureHeaders adds the count and list of failed files (if any) to the response headers.
+// The list contains comma separated, URL encoded, path=reason entries and is truncated if too long.
+func setFailureHeaders(w http.ResponseWriter, failures []scanFailure) {
+	if len(failures) == 0 {
+		return
+	}
+	w.Header().Set(ScanFailedFilesKey, strconv.Itoa(len(failures)))
+	var sb strings.Builder
+	for _, failure := range failures {
+		entry := url.QueryEscape(failure.File) + "=" + failure.Reason
+		if sb.Len()+len(entry)+1 > maxFailureHeaderLen {
+			break
+		}
+		if sb.Len() > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(entry)
+	}
+	w.Header().Set(ScanFailuresKey, sb.String())
+}
+
+// writePartialScanResponse reports a partially failed scan using the configured status code.
+// A 207 (Multi-Status) returns both the results and failures in the body, anything else is reported as an error.
+func (s APIService) writePartialScanResponse(w http.ResponseWriter, result string, failures []scanFailure, zs *zap.SugaredLogger) {
+	zs.Warnf("Scan partially failed. %v file(s) were not scanned", len(failures))
+	if s.config.Scanning.PartialFailureStatus != http.StatusMultiStatus {
+		writeError(w, codeEngineError, fmt.Sprintf("engine scan failed for %v file(s)", len(failures)), failureDetails{FailedFiles: len(failures)}, zs)
+		return
+	}
+	data, err := json.Marshal(partialScanResponse{Results: json.RawMessage(result), Failures: failures})
+	if err != nil {
+		zs.Errorf("Failed to marshal partial scan response: %v", err)
+		writeError(w, codeInternalError, "failed to produce partial scan response", nil, zs)
+		return
+	}
+	w.Header().Set(ContentTypeKey, ApplicationJSON)
+	w.WriteHeader(http.StatusMultiStatus)
+	printResponse(w, string(data)+"\n", zs, false)
+}
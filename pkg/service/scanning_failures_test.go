@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanDirectPartialFailure(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=d5e54c33\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,dir/b c.py\n" +
+		"file=8d53a2de7dfeaa20d057db98468d6671,1234,c.py"
+	tests := []struct {
+		name          string
+		partialStatus int
+		failOn        string
+		wantStatus    int
+		wantFailures  string
+		wantBody      string
+	}{
+		{name: "No failures", partialStatus: http.StatusMultiStatus, wantStatus: http.StatusOK, wantBody: `"a.py":[{"id":"none"}]`},
+		{name: "Partial 200", partialStatus: http.StatusOK, failOn: "b c.py", wantStatus: http.StatusOK, wantFailures: "dir%2Fb+c.py=engine_error",
+			wantBody: `"c.py":[{"id":"none"}]`},
+		{name: "Partial 207", partialStatus: http.StatusMultiStatus, failOn: "b c.py", wantStatus: http.StatusMultiStatus,
+			wantFailures: "dir%2Fb+c.py=engine_error", wantBody: `"failures":[{"file":"dir/b c.py","reason":"engine_error"}]`},
+		{name: "Partial 500", partialStatus: http.StatusInternalServerError, failOn: "b c.py", wantStatus: http.StatusInternalServerError,
+			wantFailures: "dir%2Fb+c.py=engine_error", wantBody: `"code":"engine_error","message":"engine scan failed for 1 file(s)"`},
+		{name: "Total failure", partialStatus: http.StatusOK, failOn: "file=", wantStatus: http.StatusInternalServerError,
+			wantFailures: "a.py=engine_error,dir%2Fb+c.py=engine_error,c.py=engine_error", wantBody: `"code":"engine_error","message":"engine scan failed"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			myConfig := setupConfig(t)
+			myConfig.Scanning.Workers = 2
+			myConfig.Scanning.WfpGrouping = 1
+			myConfig.Scanning.PartialFailureStatus = test.partialStatus
+			apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{failOn: test.failOn})
+			w := httptest.NewRecorder()
+			apiService.ScanDirect(w, newScanReq(t, "http://localhost/scan/direct", wfp, nil))
+			resp := w.Result()
+			assert.Equal(t, test.wantStatus, resp.StatusCode)
+			assert.Contains(t, w.Body.String(), test.wantBody)
+			if len(test.wantFailures) == 0 {
+				assert.Empty(t, resp.Header.Get(ScanFailedFilesKey))
+				return
+			}
+			failures := strings.Split(test.wantFailures, ",")
+			assert.Equal(t, fmt.Sprint(len(failures)), resp.Header.Get(ScanFailedFilesKey))
+			assert.ElementsMatch(t, failures, strings.Split(resp.Header.Get(ScanFailuresKey), ","))
+			if test.wantStatus == http.StatusMultiStatus {
+				var partial partialScanResponse
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &partial))
+				assert.Contains(t, string(partial.Results), `"a.py":[{"id":"none"}]`)
+				assert.NotContains(t, string(partial.Results), "b c.py")
+			}
+		})
+	}
+}
+
+func TestChunkFailures(t *testing.T) {
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,src/a,b.py\n4=d5e54c33\nfile=7c53a2de7dfeaa20d057db98468d6670\n"
+	assert.Equal(t, []string{"src/a,b.py", "7c53a2de7dfeaa20d057db98468d6670"}, wfpFilePaths(wfp))
+	assert.Equal(t, []scanFailure{{File: "src/a,b.py", Reason: failureTimeout}, {File: "7c53a2de7dfeaa20d057db98468d6670", Reason: failureTimeout}},
+		chunkFailures(wfp, failureReason(true)))
+	assert.Equal(t, failureEngineError, failureReason(false))
+	assert.Empty(t, chunkFailures("", failureEngineError))
+
+	var failures []scanFailure
+	for i := 0; i < 1000; i++ {
+		failures = append(failures, scanFailure{File: fmt.Sprintf("path/to/file-%04d.py", i), Reason: failureEngineError})
+	}
+	w := httptest.NewRecorder()
+	setFailureHeaders(w, failures)
+	assert.Equal(t, "1000", w.Header().Get(ScanFailedFilesKey))
+	assert.LessOrEqual(t, len(w.Header().Get(ScanFailuresKey)), maxFailureHeaderLen)
+	assert.True(t, strings.HasPrefix(w.Header().Get(ScanFailuresKey), "path%2Fto%2Ffile-0000.py=engine_error,"))
+}
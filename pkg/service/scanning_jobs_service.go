@@ -0,0 +1,363 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Scan job states.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+)
+
+// scanJob holds the state of an asynchronous scan request.
+type scanJob struct {
+	mu        sync.Mutex
+	id        string
+	reqID     string
+	owner     string // Authenticated caller who submitted the job (see callerID)
+	request   *scanRequest
+	filesDone atomic.Int64
+	status    string
+	result    string
+	failures  []scanFailure
+	err       *scanError
+	callback  string
+	created   time.Time
+	started   time.Time
+	finished  time.Time
+}
+
+// scanJobStatus is the JSON representation of a scan job status.
+type scanJobStatus struct {
+	ID         string        `json:"job_id"`
+	Status     string        `json:"status"`
+	FilesTotal int64         `json:"files_total"`
+	FilesDone  int64         `json:"files_done"`
+	Created    time.Time     `json:"created"`
+	Started    *time.Time    `json:"started,omitempty"`
+	Finished   *time.Time    `json:"finished,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Failures   []scanFailure `json:"failures,omitempty"`
+	Callback   string        `json:"callback,omitempty"`
+}
+
+// scanJobStore holds all asynchronous scan jobs and the queue of jobs waiting to be processed.
+// Running jobs use the store context, which is cancelled when the job workers are stopped.
+type scanJobStore struct {
+	mu     sync.RWMutex
+	jobs   map[string]*scanJob
+	queue  chan *scanJob
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newScanJobStore creates a job store with a queue of the given size.
+func newScanJobStore(queueSize int) *scanJobStore {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scanJobStore{jobs: make(map[string]*scanJob), queue: make(chan *scanJob, queueSize), ctx: ctx, cancel: cancel}
+}
+
+// submit adds the job to the store and queues it for processing. It fails if the queue is full.
+func (js *scanJobStore) submit(job *scanJob) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	select {
+	case js.queue <- job:
+		js.jobs[job.id] = job
+		return nil
+	default:
+		return errors.New("scan job queue is full")
+	}
+}
+
+// get returns the job with the given ID (if it exists).
+func (js *scanJobStore) get(id string) (*scanJob, bool) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+	job, ok := js.jobs[id]
+	return job, ok
+}
+
+// expire removes all finished jobs older than the given retention period and returns how many were removed.
+func (js *scanJobStore) expire(retention time.Duration) int {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	removed := 0
+	cutoff := time.Now().Add(-retention)
+	for id, job := range js.jobs {
+		job.mu.Lock()
+		expired := !job.finished.IsZero() && job.finished.Before(cutoff)
+		job.mu.Unlock()
+		if expired {
+			delete(js.jobs, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// statusDetails returns a snapshot of the current job status.
+func (job *scanJob) statusDetails() scanJobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	details := scanJobStatus{ID: job.id, Status: job.status, FilesTotal: job.request.wfpCount, FilesDone: job.filesDone.Load(), Created: job.created}
+	if !job.started.IsZero() {
+		started := job.started
+		details.Started = &started
+	}
+	if !job.finished.IsZero() {
+		finished := job.finished
+		details.Finished = &finished
+	}
+	if job.err != nil {
+		details.Error = job.err.message
+	}
+	details.Failures = job.failures
+	details.Callback = job.callback
+	return details
+}
+
+// SetupScanJobs starts the background workers that process asynchronous scan jobs and the expired job cleanup.
+// The returned function stops them, cancelling any jobs in progress and waiting for them to finish.
+func (s APIService) SetupScanJobs() func() {
+	workers := s.config.Scanning.JobWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	zlog.S.Infof("Starting %v asynchronous scan job worker(s)", workers)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 1; i <= workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			s.scanJobWorker(id, stop)
+		}(i)
+	}
+	scheduler := gocron.NewScheduler(time.UTC)
+	if _, err := scheduler.Every(1).Minute().Do(s.expireScanJobs); err != nil {
+		zlog.S.Warnf("Problem setting up scan job cleanup cron: %v", err)
+	} else {
+		scheduler.StartAsync()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			scheduler.Stop()
+			close(stop)
+			s.jobs.cancel()
+			wg.Wait()
+		})
+	}
+}
+
+// expireScanJobs removes finished jobs which have exceeded the retention period.
+func (s APIService) expireScanJobs() {
+	retention := time.Duration(s.config.Scanning.JobRetention) * time.Minute
+	if removed := s.jobs.expire(retention); removed > 0 {
+		zlog.S.Debugf("Removed %v expired scan job(s)", removed)
+	}
+}
+
+// scanJobWorker processes queued scan jobs until the queue is closed or the worker is stopped.
+func (s APIService) scanJobWorker(id int, stop <-chan struct{}) {
+	zlog.S.Debugf("Starting up scan job worker: %v", id)
+	defer zlog.S.Debugf("Shutting down scan job worker: %v", id)
+	for {
+		select {
+		case job, ok := <-s.jobs.queue:
+			if !ok {
+				return
+			}
+			s.processScanJob(job)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// processScanJob runs the scan for the given job and records the outcome.
+func (s APIService) processScanJob(job *scanJob) {
+	logContext := requestContext(withEngineAdmission(s.jobs.ctx), job.reqID, "", "") // Queued jobs have already been accepted
+	zs := sugaredLogger(logContext)
+	zs.Infof("Processing scan job %v (%v files)", job.id, job.request.wfpCount)
+	job.mu.Lock()
+	job.status = jobRunning
+	job.started = time.Now()
+	job.mu.Unlock()
+	result, failures, err := s.runScan(logContext, job.request, zs, nil, func(files int) { job.filesDone.Add(int64(files)) })
+	s.removeSbomFile(job.request, zs)
+	if len(job.request.config.callbackURL) > 0 {
+		defer func() { go s.sendScanJobCallback(s.jobs.ctx, job, zs) }() // Notify the client once the outcome is recorded
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.finished = time.Now()
+	job.request.contents, job.request.wfps = nil, nil // Release the WFP memory
+	job.failures = failures
+	if err != nil {
+		var scanErr *scanError
+		if s.jobs.ctx.Err() != nil {
+			scanErr = &scanError{status: http.StatusServiceUnavailable, code: codeEngineError, message: "scan job cancelled by server shutdown"}
+		} else if !errors.As(err, &scanErr) {
+			scanErr = &scanError{status: http.StatusInternalServerError, code: codeEngineError, message: "engine scan failed"}
+		}
+		s.refundQuota(job.request)
+		job.status = jobFailed
+		job.err = scanErr
+		zs.Warnf("Scan job %v failed: %v", job.id, err)
+		return
+	}
+	job.status = jobCompleted
+	job.result = result
+	zs.Infof("Scan job %v completed in %v", job.id, job.finished.Sub(job.started))
+}
+
+// SubmitScanJob handles asynchronous WFP scanning requests, returning a job ID straight away.
+func (s APIService) SubmitScanJob(w http.ResponseWriter, r *http.Request) {
+	counters.incRequest("scan_jobs")
+	reqID := getReqID(r)
+	w.Header().Set(ResponseIDKey, reqID)
+	var logContext context.Context
+	var span oteltrace.Span
+	if s.config.Telemetry.Enabled {
+		span, logContext = getSpan(r.Context(), reqID)
+	} else {
+		logContext = requestContext(r.Context(), reqID, "", "")
+	}
+	zs := sugaredLogger(logContext) // Set up the logger with context
+	logRequestDetails(r, zs)
+	req := s.parseScanRequest(w, r, zs, span, true)
+	if req == nil {
+		return
+	}
+	s.countScanSize(req.wfps, req.wfpCount, zs, logContext, span)
+	s.dedupeRequest(req, zs, logContext, span)
+	s.cacheRequest(req, zs, logContext, span)
+	job := &scanJob{id: uuid.NewString(), reqID: reqID, owner: callerID(r.Context()), request: req, status: jobQueued, created: time.Now()}
+	if len(req.config.callbackURL) > 0 {
+		job.callback = callbackPending
+	}
+	if err := s.jobs.submit(job); err != nil {
+		zs.Warnf("Failed to submit scan job: %v", err)
+		s.removeSbomFile(req, zs)
+		s.refundQuota(req)
+		writeError(w, codeQueueFull, "scan job queue is full", nil, zs)
+		setSpanError(span, "Scan job queue full.")
+		return
+	}
+	zs.Infof("Submitted scan job: %v", job.id)
+	w.Header().Set("Location", fmt.Sprintf("/api/scan/jobs/%s", job.id))
+	writeJobStatus(w, http.StatusAccepted, job.statusDetails(), zs)
+}
+
+// ScanJobStatus responds with the status and progress of the requested scan job.
+func (s APIService) ScanJobStatus(w http.ResponseWriter, r *http.Request) {
+	job, zs := s.requestedScanJob(w, r)
+	if job == nil {
+		return
+	}
+	writeJobStatus(w, http.StatusOK, job.statusDetails(), zs)
+}
+
+// ScanJobResult responds with the results of the requested scan job.
+// If the job has not finished yet, the current status is returned with a 202 (Accepted).
+func (s APIService) ScanJobResult(w http.ResponseWriter, r *http.Request) {
+	job, zs := s.requestedScanJob(w, r)
+	if job == nil {
+		return
+	}
+	job.mu.Lock()
+	status, result, failures, scanErr := job.status, job.result, job.failures, job.err
+	job.mu.Unlock()
+	switch status {
+	case jobCompleted:
+		s.writeScanResponse(w, result, failures, nil, zs)
+	case jobFailed:
+		s.writeScanResponse(w, "", failures, scanErr, zs)
+	default:
+		writeJobStatus(w, http.StatusAccepted, job.statusDetails(), zs)
+	}
+}
+
+// requestedScanJob looks up the scan job referenced in the request. Jobs submitted by another caller are reported as not found.
+// On failure, the error is written to the response.
+func (s APIService) requestedScanJob(w http.ResponseWriter, r *http.Request) (*scanJob, *zap.SugaredLogger) {
+	reqID := getReqID(r)
+	w.Header().Set(ResponseIDKey, reqID)
+	var logContext context.Context
+	if s.config.Telemetry.Enabled {
+		_, logContext = getSpan(r.Context(), reqID)
+	} else {
+		logContext = requestContext(r.Context(), reqID, "", "")
+	}
+	zs := sugaredLogger(logContext) // Set up the logger with context
+	vars := mux.Vars(r)
+	zs.Debugf("%v request from %v - %v", r.URL.Path, r.RemoteAddr, vars)
+	id, ok := vars["id"]
+	if !ok || len(id) == 0 {
+		zs.Errorf("Failed to retrieve id request variable from: %v", vars)
+		writeError(w, codeInvalidRequest, "no id request variable submitted", nil, zs)
+		return nil, zs
+	}
+	job, ok := s.jobs.get(id)
+	if ok && job.owner != callerID(r.Context()) {
+		zs.Warnf("Scan job %v requested by a caller other than its owner", id)
+		ok = false
+	}
+	if !ok {
+		zs.Warnf("Scan job not found: %v", id)
+		writeError(w, codeNotFound, "scan job not found", nil, zs)
+		return nil, zs
+	}
+	return job, zs
+}
+
+// writeJobStatus sends the given job status back to the client as JSON.
+func writeJobStatus(w http.ResponseWriter, status int, details scanJobStatus, zs *zap.SugaredLogger) {
+	data, err := json.Marshal(details)
+	if err != nil {
+		zs.Errorf("Failed to marshal scan job status: %v", err)
+		writeError(w, codeInternalError, "failed to produce scan job status", nil, zs)
+		return
+	}
+	w.Header().Set(ContentTypeKey, ApplicationJSON)
+	w.WriteHeader(status)
+	printResponse(w, string(data)+"\n", zs, true)
+}
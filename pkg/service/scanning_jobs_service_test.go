@@ -0,0 +1,268 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// getJob runs the given job handler for the requested job ID and returns the response code and body.
+func getJob(t *testing.T, handler http.HandlerFunc, path, id string) (int, string) {
+	t.Helper()
+	req := newReq("GET", path, "", map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	handler(w, req)
+	resp := w.Result()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("an error was not expected when reading from request: %v", err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestScanJobs(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.JobWorkers = 1
+	engine := &fakeEngine{}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	t.Cleanup(apiService.SetupScanJobs())
+
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,test.py\n4=d5e54c33\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,other.py"
+	req := newScanReq(t, "http://localhost/scan/jobs", wfp, nil)
+	w := httptest.NewRecorder()
+	apiService.SubmitScanJob(w, req)
+	resp := w.Result()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var submitted scanJobStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&submitted))
+	assert.NotEmpty(t, submitted.ID)
+	assert.Equal(t, int64(2), submitted.FilesTotal)
+	assert.Equal(t, fmt.Sprintf("/api/scan/jobs/%s", submitted.ID), resp.Header.Get("Location"))
+
+	var status scanJobStatus
+	assert.Eventually(t, func() bool {
+		code, body := getJob(t, apiService.ScanJobStatus, "http://localhost/scan/jobs/{id}", submitted.ID)
+		if code != http.StatusOK || json.Unmarshal([]byte(body), &status) != nil {
+			return false
+		}
+		return status.Status == jobCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(2), status.FilesDone)
+	assert.NotNil(t, status.Finished)
+
+	code, body := getJob(t, apiService.ScanJobResult, "http://localhost/scan/jobs/{id}/result", submitted.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"test.py":[{"id":"none"}]`)
+	assert.Contains(t, body, `"other.py":[{"id":"none"}]`)
+
+	code, _ = getJob(t, apiService.ScanJobStatus, "http://localhost/scan/jobs/{id}", "unknown-job")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = getJob(t, apiService.ScanJobResult, "http://localhost/scan/jobs/{id}/result", "unknown-job")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// A failed scan should report the engine error
+	engine.setScanErr(fmt.Errorf("engine failure"))
+	req = newScanReq(t, "http://localhost/scan/jobs", wfp, nil)
+	w = httptest.NewRecorder()
+	apiService.SubmitScanJob(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	var failed scanJobStatus
+	assert.NoError(t, json.NewDecoder(w.Result().Body).Decode(&failed))
+	assert.Eventually(t, func() bool {
+		code, _ := getJob(t, apiService.ScanJobResult, "http://localhost/scan/jobs/{id}/result", failed.ID)
+		return code == http.StatusInternalServerError
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Bad requests should be rejected before queueing
+	req = newScanReq(t, "http://localhost/scan/jobs", "   ", nil)
+	w = httptest.NewRecorder()
+	apiService.SubmitScanJob(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestScanJobQueueFull(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.JobQueueSize = 1
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{}) // no workers started, so jobs stay queued
+
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,test.py\n4=d5e54c33"
+	w := httptest.NewRecorder()
+	apiService.SubmitScanJob(w, newScanReq(t, "http://localhost/scan/jobs", wfp, nil))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	var queued scanJobStatus
+	assert.NoError(t, json.NewDecoder(w.Result().Body).Decode(&queued))
+	assert.Equal(t, jobQueued, queued.Status)
+
+	code, body := getJob(t, apiService.ScanJobResult, "http://localhost/scan/jobs/{id}/result", queued.ID)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Contains(t, body, jobQueued)
+
+	w = httptest.NewRecorder()
+	apiService.SubmitScanJob(w, newScanReq(t, "http://localhost/scan/jobs", wfp, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+}
+
+func TestScanJobStoreExpire(t *testing.T) {
+	store := newScanJobStore(0)
+	running := &scanJob{id: "running", request: &scanRequest{}, status: jobRunning, created: time.Now()}
+	old := &scanJob{id: "old", request: &scanRequest{}, status: jobCompleted, created: time.Now()}
+	recent := &scanJob{id: "recent", request: &scanRequest{}, status: jobCompleted, created: time.Now()}
+	for _, job := range []*scanJob{running, old, recent} {
+		assert.NoError(t, store.submit(job))
+		<-store.queue // drain the queue so the next job can be submitted
+	}
+	old.finished = time.Now().Add(-2 * time.Hour)
+	recent.finished = time.Now()
+	assert.Equal(t, 1, store.expire(time.Hour))
+	_, ok := store.get("old")
+	assert.False(t, ok)
+	_, ok = store.get("recent")
+	assert.True(t, ok)
+	_, ok = store.get("running")
+	assert.True(t, ok)
+	assert.Equal(t, 0, store.expire(time.Hour))
+}
+
+func TestStopScanJobs(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.JobWorkers = 2
+	engine := &fakeEngine{}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	stop := apiService.SetupScanJobs()
+	stop()
+	stop() // Stopping again is harmless
+
+	w := httptest.NewRecorder()
+	apiService.SubmitScanJob(w, newScanReq(t, "http://localhost/scan/jobs", "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,test.py\n4=d5e54c33", nil))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	var queued scanJobStatus
+	assert.NoError(t, json.NewDecoder(w.Result().Body).Decode(&queued))
+	time.Sleep(50 * time.Millisecond)
+	code, body := getJob(t, apiService.ScanJobStatus, "http://localhost/scan/jobs/{id}", queued.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, jobQueued) // No workers left to run it
+	assert.Empty(t, engine.calls)
+}
+
+func TestScanJobOwner(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{}) // no workers started, so jobs stay queued
+	withKey := func(req *http.Request, keyID string) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), KeyIDContextKey{}, keyID))
+	}
+
+	w := httptest.NewRecorder()
+	apiService.SubmitScanJob(w, withKey(newScanReq(t, "http://localhost/scan/jobs", "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,test.py\n4=d5e54c33", nil), "owner"))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	var queued scanJobStatus
+	assert.NoError(t, json.NewDecoder(w.Result().Body).Decode(&queued))
+
+	tests := []struct {
+		name   string
+		keyID  string
+		status int
+	}{
+		{name: "owner", keyID: "owner", status: http.StatusOK},
+		{name: "other caller", keyID: "other", status: http.StatusNotFound},
+		{name: "unauthenticated", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newReq("GET", "http://localhost/scan/jobs/{id}", "", map[string]string{"id": queued.ID})
+			if len(tt.keyID) > 0 {
+				req = withKey(req, tt.keyID)
+			}
+			w := httptest.NewRecorder()
+			apiService.ScanJobStatus(w, req)
+			assert.Equal(t, tt.status, w.Result().StatusCode)
+			w = httptest.NewRecorder()
+			apiService.ScanJobResult(w, req)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, http.StatusAccepted, w.Result().StatusCode) // Still queued
+			} else {
+				assert.Equal(t, tt.status, w.Result().StatusCode)
+			}
+		})
+	}
+}
+
+func TestStopScanJobsCancelsRunning(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.JobWorkers = 1
+	engine := &fakeEngine{block: make(chan struct{})} // Never unblocked, so only cancellation ends the scan
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	stop := apiService.SetupScanJobs()
+
+	w := httptest.NewRecorder()
+	apiService.SubmitScanJob(w, newScanReq(t, "http://localhost/scan/jobs", "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,test.py\n4=d5e54c33", nil))
+	assert.Equal(t, http.StatusAccepted, w.Result().StatusCode)
+	var submitted scanJobStatus
+	assert.NoError(t, json.NewDecoder(w.Result().Body).Decode(&submitted))
+	assert.Eventually(t, func() bool {
+		_, body := getJob(t, apiService.ScanJobStatus, "http://localhost/scan/jobs/{id}", submitted.ID)
+		return strings.Contains(body, jobRunning)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopping the scan jobs did not cancel the running job")
+	}
+	code, body := getJob(t, apiService.ScanJobResult, "http://localhost/scan/jobs/{id}/result", submitted.ID)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "server shutdown")
+}
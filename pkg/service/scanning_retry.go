@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// scanChunk scans a chunk of WFPs, retrying on failure if configured to do so.
+// A failed multi-file chunk is split up and each file retried on its own, so that a single bad file does not fail the rest.
+// Chunks rejected because the engine is busy are not retried (as that would only add to the load), and retries stop once
+// the maximum retry time has passed or the request is cancelled.
+func (s APIService) scanChunk(ctx context.Context, id, wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger, span oteltrace.Span) chunkResult {
+	result, reason := s.scanOnce(ctx, wfp, sbomFile, config, zs)
+	if len(reason) == 0 {
+		return chunkResult{result: result}
+	}
+	retries := s.config.Scanning.ScanRetries
+	if retries <= 0 || reason == failureEngineBusy {
+		return chunkResult{failures: chunkFailures(wfp, reason)}
+	}
+	var deadline time.Time
+	if s.config.Scanning.ScanRetryMaxTime > 0 {
+		deadline = time.Now().Add(time.Duration(s.config.Scanning.ScanRetryMaxTime) * time.Second)
+	}
+	files := splitWfpFiles(wfp)
+	if len(files) <= 1 {
+		return s.retryScan(ctx, id, wfp, sbomFile, config, reason, retries, deadline, zs, span)
+	}
+	zs.Infof("Scan chunk (%v) of %v files failed (%v). Retrying each file individually", id, len(files), reason)
+	counters.incRequest("scan_split_chunks")
+	addSpanEvent(span, "Splitting failed scan chunk", oteltrace.WithAttributes(attribute.Int("files", len(files)), attribute.String("reason", reason)))
+	var chunk chunkResult
+	var results []string
+	for _, file := range files {
+		fileResult := s.retryScan(ctx, id, file, sbomFile, config, reason, retries, deadline, zs, span)
+		if len(fileResult.result) > 0 {
+			results = append(results, fileResult.result)
+		}
+		chunk.failures = append(chunk.failures, fileResult.failures...)
+	}
+	chunk.result = strings.Join(results, ",")
+	return chunk
+}
+
+// retryScan retries scanning the given WFP up to the requested number of times, with an exponential backoff between attempts.
+// It gives up early if the engine is busy, the next attempt would start after the deadline (if set) or the context is cancelled.
+// The reason is that of the original failure, which is reported if the WFP is never retried.
+func (s APIService) retryScan(ctx context.Context, id, wfp, sbomFile string, config ScanningServiceConfig, reason string, retries int,
+	deadline time.Time, zs *zap.SugaredLogger, span oteltrace.Span) chunkResult {
+	backoff := time.Duration(s.config.Scanning.ScanRetryBackoff) * time.Millisecond
+	for attempt := 1; attempt <= retries; attempt++ {
+		if !deadline.IsZero() && time.Now().Add(backoff).After(deadline) {
+			zs.Warnf("Scan (%v) retry time limit reached after %v attempts", id, attempt-1)
+			break
+		}
+		if !sleepContext(ctx, backoff) {
+			zs.Debugf("Scan (%v) cancelled while waiting to retry: %v", id, ctx.Err())
+			break
+		}
+		backoff *= 2
+		counters.incRequest("scan_retries")
+		addSpanEvent(span, "Retrying engine scan", oteltrace.WithAttributes(attribute.Int("attempt", attempt),
+			attribute.Int("files", countWfpFiles(wfp))))
+		zs.Debugf("Retrying scan (%v) attempt %v of %v", id, attempt, retries)
+		var result string
+		result, reason = s.scanOnce(ctx, wfp, sbomFile, config, zs)
+		if len(reason) == 0 {
+			return chunkResult{result: result}
+		}
+		if reason == failureEngineBusy {
+			break
+		}
+	}
+	zs.Warnf("Scan (%v) still failing after retries: %v", id, reason)
+	return chunkResult{failures: chunkFailures(wfp, reason)}
+}
+
+// sleepContext waits for the given duration, returning false if the context is cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// scanOnce runs a single engine scan of the WFP and returns the result without its surrounding brackets ({}).
+// If the scan fails (or returns nothing), the failure reason is returned instead.
+func (s APIService) scanOnce(ctx context.Context, wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, string) {
+	result, timedOut, err := s.scanWfp(ctx, wfp, sbomFile, config, zs)
+	if err != nil {
+		if errors.Is(err, errEngineBusy) {
+			return "", failureEngineBusy
+		}
+		return "", failureReason(timedOut)
+	}
+	result = strings.TrimSpace(result) // remove any leading/trailing spaces
+	resLen := len(result)
+	if resLen > 1 && result[0] == '{' && result[resLen-1] == '}' {
+		result = result[1 : resLen-1] // Strip leading/trailing brackets ({})
+	}
+	result = strings.TrimSpace(result)
+	if len(result) == 0 {
+		zs.Warnf("Nothing in the engine response")
+		return "", failureEngineError
+	}
+	return result, ""
+}
+
+// splitWfpFiles splits a WFP chunk into the individual WFP for each file. Each file starts on a file= line
+// (so paths containing file= are not split).
+func splitWfpFiles(wfp string) []string {
+	var files []string
+	var file strings.Builder
+	for _, line := range strings.Split(wfp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "file=") && file.Len() > 0 {
+			files = append(files, file.String())
+			file.Reset()
+		}
+		if len(line) > 0 {
+			if file.Len() > 0 {
+				file.WriteString("\n")
+			}
+			file.WriteString(line)
+		}
+	}
+	if file.Len() > 0 {
+		files = append(files, file.String())
+	}
+	return files
+}
+
+// countWfpFiles returns the number of files in a WFP chunk (i.e. the number of lines starting with file=).
+func countWfpFiles(wfp string) int {
+	return strings.Count("\n"+wfp, "\nfile=")
+}
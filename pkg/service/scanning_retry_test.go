@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanChunkRetries(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=d5e54c33\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\n" +
+		"file=8d53a2de7dfeaa20d057db98468d6671,1234,c.py"
+	tests := []struct {
+		name         string
+		retries      int
+		backoff      int
+		maxTime      int
+		cancelled    bool
+		wfp          string
+		engine       *fakeEngine
+		wantResults  []string
+		wantFailures []scanFailure
+		wantScans    int
+		wantRetries  int64
+		wantSplits   int64
+	}{
+		{name: "No failure", retries: 2, wfp: wfp, engine: &fakeEngine{}, wantResults: []string{"a.py", "b.py", "c.py"}, wantScans: 1},
+		{name: "Retries disabled", retries: 0, wfp: wfp, engine: &fakeEngine{failFor: 1}, wantScans: 1,
+			wantFailures: []scanFailure{{"a.py", failureTimeout}, {"b.py", failureTimeout}, {"c.py", failureTimeout}}},
+		{name: "Split and recover", retries: 1, wfp: wfp, engine: &fakeEngine{failFor: 1}, wantResults: []string{"a.py", "b.py", "c.py"},
+			wantScans: 4, wantRetries: 3, wantSplits: 1},
+		{name: "Split with bad file", retries: 2, wfp: wfp, engine: &fakeEngine{failOn: "b.py"}, wantResults: []string{"a.py", "c.py"},
+			wantFailures: []scanFailure{{"b.py", failureEngineError}}, wantScans: 5, wantRetries: 4, wantSplits: 1},
+		{name: "Single file recovers", retries: 2, wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", engine: &fakeEngine{failFor: 2},
+			wantResults: []string{"a.py"}, wantScans: 3, wantRetries: 2},
+		{name: "Engine busy", retries: 2, wfp: wfp, engine: &fakeEngine{scanErr: errEngineBusy}, wantScans: 1,
+			wantFailures: []scanFailure{{"a.py", failureEngineBusy}, {"b.py", failureEngineBusy}, {"c.py", failureEngineBusy}}},
+		{name: "Retry time limit", retries: 2, backoff: 2000, maxTime: 1, wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py",
+			engine: &fakeEngine{failFor: 1}, wantFailures: []scanFailure{{"a.py", failureTimeout}}, wantScans: 1},
+		{name: "Retry time limit after split", retries: 2, backoff: 2000, maxTime: 1, wfp: wfp, engine: &fakeEngine{failFor: 1}, wantScans: 1,
+			wantFailures: []scanFailure{{"a.py", failureTimeout}, {"b.py", failureTimeout}, {"c.py", failureTimeout}}, wantSplits: 1},
+		{name: "Request cancelled", retries: 2, backoff: 60000, cancelled: true, wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py",
+			engine: &fakeEngine{failFor: 1}, wantFailures: []scanFailure{{"a.py", failureTimeout}}, wantScans: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			myConfig := setupConfig(t)
+			myConfig.Scanning.ScanRetries = test.retries
+			if test.backoff > 0 {
+				myConfig.Scanning.ScanRetryBackoff = test.backoff
+			}
+			myConfig.Scanning.ScanRetryMaxTime = test.maxTime
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancelled {
+				cancel()
+			}
+			apiService := NewAPIServiceWithEngine(myConfig, test.engine)
+			retries, splits := counters.values["scan_retries"], counters.values["scan_split_chunks"]
+			chunk := apiService.scanChunk(ctx, "test", test.wfp, "", DefaultScanningServiceConfig(myConfig), zlog.S, nil)
+			files, err := splitScanResult(chunk.result)
+			assert.NoError(t, err)
+			var names []string
+			for _, file := range files {
+				names = append(names, file.File)
+			}
+			assert.Equal(t, test.wantResults, names)
+			assert.Equal(t, test.wantFailures, chunk.failures)
+			assert.Len(t, test.engine.calls, test.wantScans)
+			assert.Equal(t, test.wantRetries, counters.values["scan_retries"]-retries)
+			assert.Equal(t, test.wantSplits, counters.values["scan_split_chunks"]-splits)
+		})
+	}
+}
+
+func TestScanDirectRetries(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.Workers = 2
+	myConfig.Scanning.WfpGrouping = 2
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{failOn: "b.py"})
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\n" +
+		"file=8d53a2de7dfeaa20d057db98468d6671,1234,c.py\nfile=9d53a2de7dfeaa20d057db98468d6672,4321,d.py"
+	w := httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "http://localhost/scan/direct", wfp, nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "1", w.Result().Header.Get(ScanFailedFilesKey))
+	assert.Equal(t, "b.py=engine_error", w.Result().Header.Get(ScanFailuresKey))
+	for _, file := range []string{"a.py", "c.py", "d.py"} {
+		assert.Contains(t, w.Body.String(), `"`+file+`":[{"id":"none"}]`)
+	}
+}
+
+func TestSplitWfpFiles(t *testing.T) {
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,dir/file=a.py\n4=d5e54c33\n\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,file=b.py\n"
+	assert.Equal(t, []string{"file=37f7cd1e657aa3c30ece35995b4c59e5,3114,dir/file=a.py\n4=d5e54c33",
+		"file=7c53a2de7dfeaa20d057db98468d6670,2321,file=b.py"}, splitWfpFiles(wfp))
+	assert.Equal(t, 2, countWfpFiles(wfp))
+	assert.Empty(t, splitWfpFiles(""))
+	assert.Equal(t, 0, countWfpFiles(""))
+}
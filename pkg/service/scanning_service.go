@@ -20,13 +20,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,6 +36,7 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	oteltrace "go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"scanoss.com/go-api/pkg/wfp"
 )
 
 const (
@@ -77,82 +80,312 @@ func (s APIService) ScanDirect(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// scanRequest holds the validated details of a WFP scan request.
+type scanRequest struct {
+	contents []byte                // Trimmed WFP contents
+	wfps     []string              // WFP contents split into individual files
+	wfpCount int64                 // Number of files to scan
+	sbomFile *os.File              // Optional SBOM temporary file
+	config   ScanningServiceConfig // Scanning configuration for this request
+	dedupe   *wfpDeduper           // Duplicate files removed from the request (if any)
+	cached   *cachedScan           // Files with a cached result removed from the request (if caching is enabled)
+	client   string                // Client whose daily quota was charged for the request
+	charged  int64                 // Number of files charged to the client's daily quota (refunded if the request fails)
+}
+
+// sbomFilename returns the name of the SBOM temporary file (if any).
+func (req *scanRequest) sbomFilename() string {
+	if req.sbomFile == nil {
+		return ""
+	}
+	return req.sbomFile.Name()
+}
+
+// setWfps replaces the files to scan with the given WFP entries (each starting with file=).
+func (req *scanRequest) setWfps(entries []string) {
+	req.contents = []byte(strings.Join(entries, "\n"))
+	req.wfps = make([]string, 0, len(entries))
+	for _, entry := range entries {
+		req.wfps = append(req.wfps, strings.TrimPrefix(entry, "file=")) // Keep the split (file= removed) format
+	}
+}
+
+// parseWfpEntries parses and validates the WFP, returning each file entry as supplied (with file= removed).
+// A *wfp.SyntaxError is returned if the WFP is malformed.
+func parseWfpEntries(contents []byte) ([]string, error) {
+	reader := wfp.NewReader(bytes.NewReader(contents))
+	var entries []string
+	for {
+		_, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, strings.TrimPrefix(reader.Text(), "file="))
+	}
+}
+
+// scanError represents a failed scan along with the HTTP status it should be reported with.
+type scanError struct {
+	status  int
+	code    string // Error response code
+	message string
+}
+
+// Error returns the scan error message.
+func (e *scanError) Error() string {
+	return e.message
+}
+
+// write reports the scan error back to the client.
+func (e *scanError) write(w http.ResponseWriter, zs *zap.SugaredLogger) {
+	writeErrorStatus(w, e.status, e.code, e.message, nil, zs)
+}
+
 // scanDirect handles WFP scanning requests from a client.
 func (s APIService) scanDirect(w http.ResponseWriter, r *http.Request, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) int64 {
 	logRequestDetails(r, zs)
+	if s.streamUpload(r) {
+		return s.scanUpload(w, r, zs, context, span)
+	}
+	req := s.parseScanRequest(w, r, zs, span, false)
+	if req == nil {
+		return 0
+	}
+	defer s.removeSbomFile(req, zs)
+	context, admitted := s.limiter.admit(context) // Don't accept more work if the engine queue is already full
+	if !admitted {
+		s.refundQuota(req)
+		s.writeEngineBusy(w, zs)
+		setSpanError(span, "Engine queue full.")
+		return 0
+	}
+	if len(req.config.callbackURL) > 0 {
+		zs.Warnf("Ignoring callback URL for synchronous scan request: %v", req.config.callbackURL)
+	}
+	s.countScanSize(req.wfps, req.wfpCount, zs, context, span)
+	s.dedupeRequest(req, zs, context, span)
+	s.cacheRequest(req, zs, context, span)
+	if strings.Contains(r.Header.Get(AcceptKey), ApplicationNDJSON) {
+		s.scanStream(w, req, zs, context, span)
+		return req.wfpCount
+	}
+	result, failures, err := s.runScan(context, req, zs, span, nil)
+	if err != nil {
+		s.refundQuota(req)
+	}
+	s.writeScanResponse(w, result, failures, err, zs)
+	return req.wfpCount
+}
+
+// parseScanRequest extracts and validates the WFP scan details from the request.
+// Any callback URL is only validated for asynchronous (job) requests, as it is ignored otherwise.
+// On failure, the error is written to the response and nil is returned.
+func (s APIService) parseScanRequest(w http.ResponseWriter, r *http.Request, zs *zap.SugaredLogger, span oteltrace.Span, async bool) *scanRequest {
+	s.limitRequestBody(w, r)
+	if !s.decompressRequestBody(w, r, zs) {
+		setSpanError(span, "Invalid compressed request body.")
+		return nil
+	}
 	contents, err := s.getFormFile(r, zs, "WFP")
 	if err != nil {
-		http.Error(w, "ERROR receiving WFP file contents", http.StatusBadRequest)
-		return 0
+		if s.writeBodyLimitExceeded(w, err, zs) {
+			setSpanError(span, "Upload size limit exceeded.")
+			return nil
+		}
+		writeError(w, codeInvalidRequest, "failed to receive WFP file contents", nil, zs)
+		return nil
 	}
 	contentsTrimmed := bytes.TrimSpace(contents)
 	if len(contentsTrimmed) == 0 {
 		zs.Errorf("No WFP contents to scan (%v - %v)", len(contents), contents)
-		http.Error(w, "ERROR no WFP contents supplied", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no WFP contents supplied", nil, zs)
 		setSpanError(span, "No WFP contents supplied")
-		return 0
+		return nil
 	}
-	scanConfig, err := s.getConfigFromRequest(r, zs)
-	if err != nil {
-		http.Error(w, "ERROR invalid scanning configuration", http.StatusBadRequest)
-		setSpanError(span, "Invalid scanning configuration.")
-		return 0
+	scanConfig, ok := s.getScanConfig(w, r, zs, span)
+	if !ok {
+		return nil
 	}
-	// Check if we have an SBOM (and type) supplied
-	var sbomFilename string
-	if len(scanConfig.sbomFile) > 0 && len(scanConfig.sbomType) > 0 {
-		if scanConfig.sbomType != sbomIdentify && scanConfig.sbomType != sbomBlackList { // Make sure we have a valid SBOM scan type
-			zs.Errorf("Invalid SBOM type: %v", scanConfig.sbomType)
-			http.Error(w, "ERROR invalid SBOM 'type' supplied", http.StatusBadRequest)
-			return 0
-		}
-		tempFile, err2 := s.writeSbomFile(scanConfig.sbomFile, zs)
-		if err2 != nil {
-			http.Error(w, "ERROR engine scan failed", http.StatusInternalServerError)
-			return 0
-		}
-		if s.config.Scanning.TmpFileDelete {
-			defer removeFile(tempFile, zs)
+	if async && len(scanConfig.callbackURL) > 0 && !s.checkCallbackURL(w, scanConfig.callbackURL, zs, span) {
+		return nil
+	}
+	wfps, err := parseWfpEntries(contentsTrimmed)
+	if err != nil {
+		var syntaxErr *wfp.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			writeInvalidWfp(w, syntaxErr, zs, span)
+		} else {
+			zs.Errorf("Failed to validate WFP: %v", err)
+			writeError(w, codeInvalidRequest, "failed to receive WFP file contents", nil, zs)
 		}
-		sbomFilename = tempFile.Name() // Save the SBOM filename
-		zs.Debugf("Stored SBOM (%v) in %v", scanConfig.sbomType, sbomFilename)
+		return nil
+	}
+	wfpCount := int64(len(wfps))
+	if maxFiles := s.config.Scanning.MaxWfpFiles; maxFiles > 0 && wfpCount > maxFiles {
+		writeLimitExceeded(w, limitWfpFiles, maxFiles, "files", zs)
+		setSpanError(span, "WFP file limit exceeded.")
+		return nil
 	}
-	wfps := strings.Split(string(contentsTrimmed), "file=")
-	wfpCount := int64(len(wfps) - 1) // The first entry in the array is empty (hence the -1)
-	if wfpCount <= 0 {
+	if wfpCount == 0 {
 		zs.Errorf("No WFP (file=...) entries found to scan")
-		http.Error(w, "ERROR no WFP file contents (file=...) supplied", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no WFP file contents (file=...) supplied", nil, zs)
 		setSpanError(span, "No WFP (file=...) entries found.")
-		return 0
+		return nil
 	}
 	if !s.validateHPSM(contentsTrimmed, zs, w) {
 		setSpanError(span, "HPSM disabled.")
-		return 0
+		return nil
 	}
-	s.countScanSize(wfps, wfpCount, zs, context, span)
-	// Only one worker selected, so send the whole WFP in a single command
-	if s.config.Scanning.Workers <= 1 {
-		s.singleScan(string(contentsTrimmed), sbomFilename, scanConfig, zs, w)
-	} else {
-		s.scanThreaded(wfps, int(wfpCount), sbomFilename, scanConfig, zs, w, span)
+	if !s.chargeQuota(w, r, s.scanLimits, wfpCount, zs) {
+		setSpanError(span, "Daily scan quota exceeded.")
+		return nil
+	}
+	req := &scanRequest{contents: contentsTrimmed, wfps: wfps, wfpCount: wfpCount, config: scanConfig, client: s.clientID(r), charged: wfpCount}
+	if !s.storeSbomFile(w, req, zs) {
+		s.refundQuota(req)
+		return nil
+	}
+	return req
+}
+
+// refundQuota takes the files charged for a scan request back off the client's daily quota, i.e. when the request fails.
+func (s APIService) refundQuota(req *scanRequest) {
+	s.scanLimits.refund(req.client, req.charged)
+	req.charged = 0
+}
+
+// getScanConfig extracts and validates the scanning configuration (including any SBOM) from the request.
+// On failure, the error is written to the response and false is returned.
+func (s APIService) getScanConfig(w http.ResponseWriter, r *http.Request, zs *zap.SugaredLogger, span oteltrace.Span) (ScanningServiceConfig, bool) {
+	scanConfig, err := s.getConfigFromRequest(r, zs)
+	if err != nil {
+		writeError(w, codeInvalidRequest, "invalid scanning configuration", nil, zs)
+		setSpanError(span, "Invalid scanning configuration.")
+		return scanConfig, false
 	}
-	return wfpCount
+	if !s.checkSbomSize(w, len(scanConfig.sbomFile), zs) {
+		setSpanError(span, "SBOM size limit exceeded.")
+		return scanConfig, false
+	}
+	// Check if we have an SBOM (and type) supplied
+	sbomSupplied := len(scanConfig.sbomFile) > 0 && len(scanConfig.sbomType) > 0
+	if sbomSupplied && scanConfig.sbomType != sbomIdentify && scanConfig.sbomType != sbomBlackList { // Make sure we have a valid SBOM scan type
+		zs.Errorf("Invalid SBOM type: %v", scanConfig.sbomType)
+		writeError(w, codeInvalidRequest, "invalid SBOM 'type' supplied", nil, zs)
+		return scanConfig, false
+	}
+	return scanConfig, true
+}
+
+// storeSbomFile writes any SBOM supplied with the scan request to a temporary file for the engine.
+// On failure, the error is written to the response and false is returned.
+func (s APIService) storeSbomFile(w http.ResponseWriter, req *scanRequest, zs *zap.SugaredLogger) bool {
+	sbomSupplied := len(req.config.sbomFile) > 0 && len(req.config.sbomType) > 0
+	if !sbomSupplied || streamEngineInput(s.config) { // Streamed SBOMs are passed to the engine from the scanning config
+		return true
+	}
+	var err error
+	req.sbomFile, err = s.writeSbomFile(req.config.sbomFile, zs)
+	if err != nil {
+		writeError(w, codeInternalError, "failed to store the SBOM for scanning", nil, zs)
+		return false
+	}
+	zs.Debugf("Stored SBOM (%v) in %v", req.config.sbomType, req.sbomFilename())
+	return true
+}
+
+// removeSbomFile removes the SBOM temporary file associated with the scan request (if requested).
+func (s APIService) removeSbomFile(req *scanRequest, zs *zap.SugaredLogger) {
+	if req.sbomFile != nil && s.config.Scanning.TmpFileDelete {
+		removeFile(req.sbomFile, zs)
+	}
+}
+
+// runScan scans the given request, using multiple workers if configured to do so.
+// The optional progress function is called with the number of files completed as the scan progresses.
+// Any files which could not be scanned (when using multiple workers) are returned alongside the result.
+// Cached results (and the results of duplicate files) are added once the rest of the files have been scanned.
+func (s APIService) runScan(ctx context.Context, req *scanRequest, zs *zap.SugaredLogger, span oteltrace.Span, progress func(int)) (string, []scanFailure, error) {
+	var result string
+	var failures []scanFailure
+	var err error
+	single := s.config.Scanning.Workers <= 1 || req.cached.complete()
+	switch {
+	case req.cached.complete(): // Every file has a cached result, so there is nothing to scan
+		result = "{}"
+	case s.config.Scanning.Workers <= 1: // Only one worker selected, so send the whole WFP in a single command
+		result, err = s.singleScan(ctx, string(req.contents), req.sbomFilename(), req.config, zs)
+	default:
+		result, failures, err = s.scanThreaded(ctx, sliceWfpSource(req.wfps), int(req.wfpCount), req.sbomFilename(), req.config, zs, span, progress)
+		result, err = req.cached.recoverEmpty(result, failures, err)
+	}
+	failures = req.dedupe.expandFailures(failures)
+	if err != nil {
+		return result, failures, err
+	}
+	result, hits := req.cached.finish(result, zs)
+	result, duplicates := req.dedupe.expandResult(result, zs)
+	if progress != nil {
+		if single {
+			progress(int(req.wfpCount))
+		} else if hits+duplicates > 0 {
+			progress(hits + duplicates)
+		}
+	}
+	return result, failures, nil
+}
+
+// writeScanResponse sends the scan result (or failure) back to the client.
+// Partially failed scans are reported in the response headers and optionally with a different status (see writePartialScanResponse).
+func (s APIService) writeScanResponse(w http.ResponseWriter, result string, failures []scanFailure, err error, zs *zap.SugaredLogger) {
+	setFailureHeaders(w, failures)
+	if err != nil {
+		var scanErr *scanError
+		if errors.As(err, &scanErr) && scanErr.code == codeEngineBusy {
+			s.writeEngineBusy(w, zs)
+		} else if errors.As(err, &scanErr) {
+			scanErr.write(w, zs)
+		} else {
+			writeError(w, codeEngineError, "engine scan failed", nil, zs)
+		}
+		return
+	}
+	if len(failures) > 0 && s.config.Scanning.PartialFailureStatus != http.StatusOK {
+		s.writePartialScanResponse(w, result, failures, zs)
+		return
+	}
+	w.Header().Set(ContentTypeKey, ApplicationJSON)
+	printResponse(w, result+"\n", zs, false)
 }
 
 // countScanSize parses the WFPs to calculate the size of the scan request and record it for metrics.
 func (s APIService) countScanSize(wfps []string, wfpCount int64, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) {
 	var sizeCount int64 = 0
-	for _, wfp := range wfps {
-		matches := fileRegex.FindStringSubmatch(wfp)
-		if len(matches) > 0 {
-			i, err := strconv.ParseInt(matches[1], 10, 64)
-			if err == nil {
-				sizeCount += i
-			} else {
-				zs.Warnf("Problem parsing file size from %v - %v: %v", matches[1], err, wfp)
-			}
+	for _, entry := range wfps {
+		sizeCount += wfpFileSize(entry, zs)
+	}
+	s.recordScanSize(wfpCount, sizeCount, zs, context, span)
+	zs.Infof("Need to scan %v files of size %v", wfpCount, sizeCount)
+}
+
+// wfpFileSize parses the file size from a single WFP entry (without the file= prefix).
+func wfpFileSize(entry string, zs *zap.SugaredLogger) int64 {
+	matches := fileRegex.FindStringSubmatch(entry)
+	if len(matches) > 0 {
+		i, err := strconv.ParseInt(matches[1], 10, 64)
+		if err == nil {
+			return i
 		}
+		zs.Warnf("Problem parsing file size from %v - %v: %v", matches[1], err, entry)
 	}
+	return 0
+}
+
+// recordScanSize records the number and size of the files in a scan request for metrics.
+func (s APIService) recordScanSize(wfpCount, sizeCount int64, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) {
 	counters.incRequestAmount("files", wfpCount)
 	if s.config.Telemetry.Enabled {
 		oltpMetrics.scanFileCounter.Add(context, wfpCount)
@@ -162,15 +395,15 @@ func (s APIService) countScanSize(wfps []string, wfpCount int64, zs *zap.Sugared
 			span.SetAttributes(attribute.Int64("scan.file_size", sizeCount))
 		}
 	}
-	zs.Infof("Need to scan %v files of size %v", wfpCount, sizeCount)
 }
 
 // getConfigFromRequest extracts the form values from a request and returns the scanning configuration.
 func (s APIService) getConfigFromRequest(r *http.Request, zs *zap.SugaredLogger) (ScanningServiceConfig, error) {
-	flags := strings.TrimSpace(r.FormValue("flags"))    // Check form for scanning flags
-	scanType := strings.TrimSpace(r.FormValue("type"))  // Check form for SBOM type
-	sbom := strings.TrimSpace(r.FormValue("assets"))    // Check form for SBOM contents
-	dbName := strings.TrimSpace(r.FormValue("db_name")) // Check form for db name
+	flags := strings.TrimSpace(r.FormValue("flags"))              // Check form for scanning flags
+	scanType := strings.TrimSpace(r.FormValue("type"))            // Check form for SBOM type
+	sbom := strings.TrimSpace(r.FormValue("assets"))              // Check form for SBOM contents
+	dbName := strings.TrimSpace(r.FormValue("db_name"))           // Check form for db name
+	callbackURL := strings.TrimSpace(r.FormValue("callback_url")) // Check form for a job completion callback
 	// Fall back to headers if form values are empty
 	if len(flags) == 0 {
 		flags = strings.TrimSpace(r.Header.Get("flags"))
@@ -184,6 +417,9 @@ func (s APIService) getConfigFromRequest(r *http.Request, zs *zap.SugaredLogger)
 	if len(dbName) == 0 {
 		dbName = strings.TrimSpace(r.Header.Get("db_name"))
 	}
+	if len(callbackURL) == 0 {
+		callbackURL = strings.TrimSpace(r.Header.Get("callback_url"))
+	}
 	if len(flags) > 0 && s.config.Scanning.ScanFlags > 0 {
 		if !s.config.Scanning.AllowFlagsOverride {
 			zs.Warnf("Ignoring flags (%v) in the request. Using flags from the server config: %v",
@@ -196,8 +432,8 @@ func (s APIService) getConfigFromRequest(r *http.Request, zs *zap.SugaredLogger)
 	}
 	scanSettings := strings.TrimSpace(r.Header.Get("scanoss-settings")) // Check the header for scan settings
 	if s.config.App.Trace {
-		zs.Debugf("Header: %v, Form: %v, flags: %v, type: %v, assets: %v, db_name: %v, scanSettings: %v",
-			r.Header, r.Form, flags, scanType, sbom, dbName, scanSettings)
+		zs.Debugf("Header: %v, Form: %v, flags: %v, type: %v, assets: %v, db_name: %v, callback_url: %v, scanSettings: %v",
+			r.Header, r.Form, flags, scanType, sbom, dbName, callbackURL, scanSettings)
 	}
 	// Create default configuration from server config
 	scanConfig := DefaultScanningServiceConfig(s.config)
@@ -217,7 +453,12 @@ func (s APIService) getConfigFromRequest(r *http.Request, zs *zap.SugaredLogger)
 			zs.Debugf("Decoded scan settings: %s", string(decoded))
 		}
 	}
-	return s.UpdateScanningServiceConfigDTO(zs, &scanConfig, flags, scanType, sbom, dbName, decoded)
+	updatedConfig, err := s.UpdateScanningServiceConfigDTO(zs, &scanConfig, flags, scanType, sbom, dbName, decoded)
+	if err != nil {
+		return updatedConfig, err
+	}
+	updatedConfig.callbackURL = callbackURL // Only validated for asynchronous scan jobs (see parseScanRequest)
+	return updatedConfig, nil
 }
 
 // writeSbomFile writes the given string into an SBOM temporary file.
@@ -239,57 +480,126 @@ func (s APIService) writeSbomFile(sbom string, zs *zap.SugaredLogger) (*os.File,
 }
 
 // singleScan runs a scan of the WFP in a single thread.
-func (s APIService) singleScan(wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger, w http.ResponseWriter) {
+func (s APIService) singleScan(ctx context.Context, contents, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, error) {
 	zs.Debugf("Single threaded scan...")
-	result, timedOut, err := s.scanWfp(wfp, sbomFile, config, zs)
+	result, timedOut, err := s.scanWfp(ctx, contents, sbomFile, config, zs)
 	if err != nil {
+		zs.Errorf("Engine scan failed: %v", err)
 		if timedOut {
-			http.Error(w, "ERROR engine scan timed out", http.StatusGatewayTimeout)
-		} else {
-			http.Error(w, "ERROR engine scan failed", http.StatusInternalServerError)
+			return "", &scanError{status: http.StatusGatewayTimeout, code: codeEngineTimeout, message: "engine scan timed out"}
 		}
-		zs.Errorf("Engine scan failed: %v", err)
-	} else {
-		zs.Debug("Scan completed")
-		response := strings.TrimSpace(result)
-		if len(response) == 0 {
-			zs.Warnf("Nothing in the engine response")
-			http.Error(w, "ERROR engine scan failed", http.StatusInternalServerError)
-		} else {
-			w.Header().Set(ContentTypeKey, ApplicationJSON)
-			printResponse(w, fmt.Sprintf("%s\n", response), zs, false)
+		if errors.Is(err, errEngineBusy) {
+			return "", &scanError{status: http.StatusServiceUnavailable, code: codeEngineBusy, message: "engine busy, please retry later"}
 		}
+		return "", &scanError{status: http.StatusInternalServerError, code: codeEngineError, message: "engine scan failed"}
+	}
+	zs.Debug("Scan completed")
+	response := strings.TrimSpace(result)
+	if len(response) == 0 {
+		zs.Warnf("Nothing in the engine response")
+		return "", &scanError{status: http.StatusInternalServerError, code: codeEngineError, message: "engine scan failed"}
 	}
+	return response, nil
 }
 
 // scanThreaded scan the given WFPs in multiple threads.
-func (s APIService) scanThreaded(wfps []string, wfpCount int, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger, w http.ResponseWriter, span oteltrace.Span) {
+// The wfpCount is the number of WFPs in the source (if known), and is only used to size the number of workers.
+// The optional progress function is called with the number of files completed by each worker request.
+func (s APIService) scanThreaded(ctx context.Context, source wfpSource, wfpCount int, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger,
+	span oteltrace.Span, progress func(int)) (string, []scanFailure, error) {
+	var responses []string
+	stats := s.scanChunks(ctx, source, wfpCount, sbomFile, config, zs, span, progress, func(result string) {
+		responses = append(responses, result)
+	})
+	if stats.err != nil {
+		return "", stats.failures, stats.err
+	}
+	responsesLength := len(responses)
+	zs.Debugf("Responses: %v", responsesLength)
+	if responsesLength == 0 {
+		zs.Errorf("Multi-engine scan failed to produce results")
+		return "", stats.failures, noResultsError(stats.failures)
+	}
+	return "{" + strings.Join(responses, ",") + "}", stats.failures, nil
+}
+
+// scanChunks splits the WFPs from the given source into groups and scans them using multiple workers.
+// Each non-empty worker result (without the surrounding brackets) is passed to the emit function as soon as it is received.
+// It returns the number of scan requests sent, results received, the files which failed to scan and any error reading the source.
+func (s APIService) scanChunks(ctx context.Context, source wfpSource, wfpCount int, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger,
+	span oteltrace.Span, progress func(int), emit func(string)) chunkStats {
 	addSpanEvent(span, "Started Scanning.")
 	numWorkers := s.config.Scanning.Workers
-	groupedWfps := wfpCount / s.config.Scanning.WfpGrouping
-	if numWorkers > groupedWfps {
-		zs.Debugf("Requested workers (%v) greater than WFPs (%v). Reducing number.", numWorkers, groupedWfps)
-		numWorkers = groupedWfps
+	if wfpCount > 0 { // Only reduce the workers if we know how many WFPs there are
+		groupedWfps := wfpCount / s.config.Scanning.WfpGrouping
+		if numWorkers > groupedWfps {
+			zs.Debugf("Requested workers (%v) greater than WFPs (%v). Reducing number.", numWorkers, groupedWfps)
+			numWorkers = groupedWfps
+		}
 	}
 	if numWorkers < 1 {
 		numWorkers = 1 // Make sure we have at least one worker
 	}
 	// Multiple workers, create input and output channels
 	requests := make(chan string)
-	results := make(chan string, groupedWfps+1)
+	results := make(chan chunkResult, numWorkers)
 	zs.Debugf("Creating %v scanning workers...", numWorkers)
 	// Create workers
+	var workers sync.WaitGroup
 	for i := 1; i <= numWorkers; i++ {
-		go s.workerScan(fmt.Sprintf("%d_%s", i, uuid.New().String()), requests, results, sbomFile, config, zs)
+		workers.Add(1)
+		go func(id string) {
+			defer workers.Done()
+			s.workerScan(ctx, id, requests, results, sbomFile, config, zs, span, progress)
+		}(fmt.Sprintf("%d_%s", i, uuid.New().String()))
+	}
+	var requestCount int // Count the number of actual requests sent
+	var sourceErr error
+	go func() {
+		requestCount, sourceErr = s.submitChunks(source, requests, zs)
+		close(requests) // No more requests. close the channel
+		zs.Debugf("Finished sending requests: %v", requestCount)
+		workers.Wait()
+		close(results) // All workers have finished, so no more results
+	}()
+	var stats chunkStats
+	for chunk := range results { // Get results until all the workers have finished
+		if s.config.App.Trace {
+			zs.Debugf("Result %v: %v", stats.received, strings.TrimSpace(chunk.result))
+		}
+		result := strings.TrimSpace(chunk.result)
+		if len(result) > 0 {
+			stats.received++
+			emit(result)
+		}
+		stats.failures = append(stats.failures, chunk.failures...)
 	}
-	requestCount := 0 // Count the number of actual requests sent
+	addSpanEvent(span, "Finished Scanning.")
+	stats.requests = requestCount
+	stats.err = sourceErr
+	if requestCount != stats.received {
+		zs.Warnf("Received fewer scan responses (%v) than requested (%v). Failed files: %v", stats.received, requestCount, len(stats.failures))
+		addSpanEvent(span, "Unmatched scan responses", oteltrace.WithAttributes(attribute.Int("requested", requestCount),
+			attribute.Int("received", stats.received), attribute.Int("failed_files", len(stats.failures))))
+	}
+	return stats
+}
+
+// submitChunks reads the WFPs from the source, grouping them into scan requests for the workers.
+// It stops early if the source fails, returning the number of requests sent and the error.
+func (s APIService) submitChunks(source wfpSource, requests chan<- string, zs *zap.SugaredLogger) (int, error) {
+	requestCount := 0
 	var wfpRequests []string
-	for _, wfp := range wfps {
-		wfp = strings.TrimSpace(wfp)
-		if len(wfp) == 0 { // Ignore empty requests
-			continue
+	for {
+		entry, err := source()
+		if errors.Is(err, io.EOF) {
+			break
 		}
-		wfpRequests = append(wfpRequests, "file="+wfp)
+		if err != nil {
+			zs.Errorf("Failed to read WFPs to scan: %v", err)
+			return requestCount, err
+		}
+		wfpRequests = append(wfpRequests, entry)
 		if len(wfpRequests) >= s.config.Scanning.WfpGrouping { // Reach the WFP target, submit the request
 			if s.config.App.Trace {
 				zs.Debugf("Submitting requests: %v", len(wfpRequests))
@@ -306,37 +616,7 @@ func (s APIService) scanThreaded(wfps []string, wfpCount int, sbomFile string, c
 		requests <- strings.Join(wfpRequests, "\n")
 		requestCount++
 	}
-	close(requests) // No more requests. close the channel
-	zs.Debugf("Finished sending requests: %v", requestCount)
-	var responses []string
-	for i := 0; i < requestCount; i++ { // Get results for the number of requests sent
-		if s.config.App.Trace {
-			zs.Debugf("Waiting for result %v", i)
-		}
-		result := <-results
-		if s.config.App.Trace {
-			zs.Debugf("Result %v: %v", i, strings.TrimSpace(result))
-		}
-		result = strings.TrimSpace(result)
-		if len(result) > 0 {
-			responses = append(responses, result)
-		}
-	}
-	close(results)
-	addSpanEvent(span, "Finished Scanning.")
-	responsesLength := len(responses)
-	if requestCount != responsesLength {
-		zs.Warnf("Received fewer scan responses (%v) than requested (%v)", responsesLength, requestCount)
-		addSpanEvent(span, "Unmatched scan responses", oteltrace.WithAttributes(attribute.Int("requested", requestCount), attribute.Int("received", responsesLength)))
-	}
-	zs.Debugf("Responses: %v", responsesLength)
-	if responsesLength == 0 {
-		zs.Errorf("Multi-engine scan failed to produce results")
-		http.Error(w, "ERROR engine scan failed", http.StatusInternalServerError)
-	} else {
-		w.Header().Set(ContentTypeKey, ApplicationJSON)
-		printResponse(w, "{"+strings.Join(responses, ",")+"}\n", zs, false)
-	}
+	return requestCount, nil
 }
 
 // validateHPSM checks if HPSM is enabled or not. If it's not and HPSM is detected. Fail the scan request.
@@ -347,15 +627,23 @@ func (s APIService) validateHPSM(contents []byte, zs *zap.SugaredLogger, w http.
 		}
 		if strings.Contains(string(contents), "hpsm=") {
 			zs.Errorf("HPSM (hpsm=...) detected in WFPs and HPSM support is disabled")
-			http.Error(w, "ERROR HPSM detected in WFP. HPSM is disabled", http.StatusForbidden)
+			writeError(w, codeFeatureDisabled, "HPSM detected in WFP. HPSM is disabled", nil, zs)
 			return false
 		}
 	}
 	return true
 }
 
+// writeInvalidWfp responds with a 400 (Bad Request), pointing at the line of the WFP which is invalid.
+func writeInvalidWfp(w http.ResponseWriter, err *wfp.SyntaxError, zs *zap.SugaredLogger, span oteltrace.Span) {
+	zs.Errorf("Invalid WFP supplied: %v", err)
+	writeError(w, codeInvalidWfp, fmt.Sprintf("invalid WFP at %v", err), wfpDetails{Line: err.Line}, zs)
+	setSpanError(span, "Invalid WFP.")
+}
+
 // workerScan attempts to process all incoming scanning jobs and dumps the results into the subsequent results channel.
-func (s APIService) workerScan(id string, jobs <-chan string, results chan<- string, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) {
+func (s APIService) workerScan(ctx context.Context, id string, jobs <-chan string, results chan<- chunkResult, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger,
+	span oteltrace.Span, progress func(int)) {
 	if s.config.App.Trace {
 		zs.Debugf("Starting up scanning worker: %v", id)
 	}
@@ -367,25 +655,16 @@ func (s APIService) workerScan(id string, jobs <-chan string, results chan<- str
 		}
 		if len(job) == 0 {
 			zs.Warnf("Nothing in the job request to scan. Ignoring")
-			results <- ""
+			results <- chunkResult{}
 		} else {
-			result, _, err := s.scanWfp(job, sbomFile, config, zs)
+			chunk := s.scanChunk(ctx, id, job, sbomFile, config, zs, span)
 			if s.config.App.Trace {
-				zs.Debugf("scan result (%v): %v, %v", id, result, err)
+				zs.Debugf("Saving result (%v): '%v', failures: %v", id, chunk.result, chunk.failures)
 			}
-			if err != nil {
-				results <- ""
-			} else {
-				result = strings.TrimSpace(result) // remove any leading/trailing spaces
-				resLen := len(result)
-				if resLen > 1 && result[0] == '{' && result[resLen-1] == '}' {
-					result = result[1 : resLen-1] // Strip leading/trailing brackets ({})
-				}
-				if s.config.App.Trace {
-					zs.Debugf("Saving result: '%v'", result)
-				}
-				results <- strings.TrimSpace(result)
+			if progress != nil && len(chunk.result) > 0 {
+				progress(countWfpFiles(job) - len(chunk.failures))
 			}
+			results <- chunk
 		}
 	}
 	if s.config.App.Trace {
@@ -394,112 +673,24 @@ func (s APIService) workerScan(id string, jobs <-chan string, results chan<- str
 }
 
 // scanWfp run the scanoss engine scan of the supplied WFP.
-func (s APIService) scanWfp(wfp, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, bool, error) {
-	if len(wfp) == 0 {
+func (s APIService) scanWfp(ctx context.Context, contents, sbomFile string, config ScanningServiceConfig, zs *zap.SugaredLogger) (string, bool, error) {
+	if len(contents) == 0 {
 		zs.Warnf("Nothing in the job request to scan. Ignoring")
 		return "", false, fmt.Errorf("no wfp supplied to scan. ignoring")
 	}
-	tempFile, err := os.CreateTemp(s.config.Scanning.WfpLoc, "finger*.wfp")
-	if err != nil {
-		zs.Errorf("Failed to create temporary file: %v", err)
-		return "", false, fmt.Errorf("failed to create temporary WFP file")
-	}
-	if s.config.Scanning.TmpFileDelete {
-		defer removeFile(tempFile, zs)
-	}
-	zs.Debugf("Using temporary file: %v", tempFile.Name())
-	_, err = tempFile.WriteString(wfp + "\n")
-	if err != nil {
-		closeFile(tempFile, zs)
-		zs.Errorf("Failed to write WFP to temporary file: %v", err)
-		return "", false, fmt.Errorf("failed to write to temporary WFP file")
-	}
-	closeFile(tempFile, zs)
-	// Build command arguments
-	var args []string
-	if s.config.Scanning.ScanDebug {
-		args = append(args, "-d") // Set debug mode
-	}
-	// Database name
-	if len(config.dbName) > 0 {
-		args = append(args, fmt.Sprintf("-n%s", config.dbName))
-	}
-	// Scanning flags
-	if config.flags > 0 {
-		args = append(args, fmt.Sprintf("-F%v", config.flags))
-	}
-	// SBOM configuration
-	if len(sbomFile) > 0 && len(config.sbomType) > 0 {
-		switch config.sbomType {
-		case sbomIdentify:
-			args = append(args, "-s")
-		case sbomBlackList:
-			args = append(args, "-b")
-		default:
-			args = append(args, "-s") // Default to identify
-		}
-		args = append(args, sbomFile)
-	}
-	// Ranking threshold (only if ranking is enabled and allowed)
-	if config.rankingEnabled && config.rankingThreshold >= 0 && s.config.Scanning.RankingAllowed {
-		args = append(args, fmt.Sprintf("-r%d", config.rankingThreshold))
-	}
-	// Minimum snippet hits
-	if config.minSnippetHits > 0 {
-		args = append(args, fmt.Sprintf("--min-snippet-hits=%d", config.minSnippetHits))
-	}
-	// Minimum snippet lines
-	if config.minSnippetLines > 0 {
-		args = append(args, fmt.Sprintf("--min-snippet-lines=%d", config.minSnippetLines))
-	}
-	// Honour file extensions (not yet implemented in scanoss engine)
-	if !config.honourFileExts {
-		args = append(args, "--ignore-file-ext")
-	}
-	// WFP file argument
-	args = append(args, "-w", tempFile.Name())
-	zs.Debugf("Executing %v %v", s.config.Scanning.ScanBinary, strings.Join(args, " "))
-	timeoutErr := fmt.Errorf("scan command timed out after %v seconds", s.config.Scanning.ScanTimeout)
-	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Duration(s.config.Scanning.ScanTimeout)*time.Second, timeoutErr) // put a timeout on the scan execution
-	defer cancel()
-	timeoutEncountered := false
-	//nolint:gosec
-	output, err := exec.CommandContext(ctx, s.config.Scanning.ScanBinary, args...).Output()
-	if err != nil {
-		if cause := context.Cause(ctx); cause != nil {
-			zs.Errorf("Scan command (%v) timed out: %v", s.config.Scanning.ScanBinary, cause)
-			timeoutEncountered = true
-		} else {
-			zs.Errorf("Scan command (%v %v) failed: %v", s.config.Scanning.ScanBinary, args, err)
-		}
-		zs.Errorf("Command output: %s", bytes.TrimSpace(output))
-		if s.config.Scanning.KeepFailedWfps {
-			s.copyWfpTempFile(tempFile.Name(), zs)
-		}
-		return "", timeoutEncountered, fmt.Errorf("failed to scan WFP: %v", err)
-	}
-	return string(output), timeoutEncountered, err
+	return s.engine.Scan(ctx, contents, sbomFile, config, zs)
 }
 
 // TestEngine tests if the SCANOSS engine is accessible and running.
 func (s APIService) TestEngine() error {
 	zlog.S.Infof("Testing engine command: %v", s.config.Scanning.ScanBinary)
-	var args []string
-	args = append(args, "-h")
-	zlog.S.Debugf("Executing %v %v", s.config.Scanning.ScanBinary, strings.Join(args, " "))
-	timeoutErr := fmt.Errorf("engine test command timed out after 10 seconds")
-	ctx, cancel := context.WithTimeoutCause(context.Background(), 10*time.Second, timeoutErr) // put a timeout on the scanoss execution
-	defer cancel()
-	//nolint:gosec
-	output, err := exec.CommandContext(ctx, s.config.Scanning.ScanBinary, args...).Output()
-	if err != nil {
-		if cause := context.Cause(ctx); cause != nil {
-			zlog.S.Errorf("Scan test command (%v) timed out: %v", s.config.Scanning.ScanBinary, cause)
-		} else {
-			zlog.S.Errorf("Scan test command (%v %v) failed: %v", s.config.Scanning.ScanBinary, args, err)
-		}
-		zlog.S.Errorf("Command output: %s", bytes.TrimSpace(output))
-		return fmt.Errorf("failed to test scan engine: %v", err)
+	if err := s.engine.Health(context.Background(), zlog.S); err != nil {
+		return err
+	}
+	if ver, err := s.engine.Version(context.Background(), zlog.S); err != nil {
+		zlog.S.Warnf("Failed to determine engine version: %v", err)
+	} else {
+		zlog.S.Infof("Engine version: %v", ver)
 	}
 	return nil
 }
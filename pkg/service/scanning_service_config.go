@@ -35,6 +35,7 @@ type ScanningServiceConfig struct {
 	minSnippetHits   int
 	minSnippetLines  int
 	honourFileExts   bool
+	callbackURL      string
 }
 
 func DefaultScanningServiceConfig(serverDefaultConfig *cfg.ServerConfig) ScanningServiceConfig {
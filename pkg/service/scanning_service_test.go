@@ -17,6 +17,7 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -27,6 +28,12 @@ import (
 
 	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"scanoss.com/go-api/pkg/wfp"
 )
 
 func TestScanEngineWithTelemetry(t *testing.T) {
@@ -602,6 +609,39 @@ func TestScanDirectSingleSlow(t *testing.T) {
 	}
 }
 
+func TestScanDirectInvalidWfp(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.Workers = 1
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{})
+
+	tests := []struct {
+		name string
+		wfp  string
+		want int
+		body string
+	}{
+		{name: "valid", wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=579a7cd6\n", want: http.StatusOK, body: `"a.py"`},
+		{name: "bad md5", wfp: "file=37f7cd1e657aa3c30ece,3114,a.py\n", want: http.StatusBadRequest, body: "invalid WFP at line 1: invalid file md5"},
+		{name: "bad hash", wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=579a7cd6\n\n9=zz\n", want: http.StatusBadRequest,
+			body: "invalid WFP at line 4: invalid snippet hash"},
+		{name: "no file line", wfp: "4=579a7cd6\nfile=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n", want: http.StatusBadRequest,
+			body: "invalid WFP at line 1: expected a file entry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			apiService.ScanDirect(w, newScanReq(t, "/scan/direct", tt.wfp, nil))
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.body)
+		})
+	}
+}
+
 func TestScanFlags(t *testing.T) {
 	err := zlog.NewSugaredDevLogger()
 	if err != nil {
@@ -673,3 +713,49 @@ func TestScanFlags(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordScanSizeMetrics(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() {
+		otel.SetMeterProvider(noop.NewMeterProvider())
+		setupMetrics()
+	})
+	myConfig := setupConfig(t)
+	myConfig.Telemetry.Enabled = true
+	apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{})
+	ctx := context.Background()
+	apiService.recordScanSize(3, 1024, zlog.S, ctx, oteltrace.SpanFromContext(ctx))
+
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(ctx, &rm))
+	sums := make(map[string]int64)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, point := range sum.DataPoints {
+					sums[m.Name] += point.Value
+				}
+			}
+		}
+	}
+	assert.Equal(t, int64(3), sums["scanoss-api.scan.file_count"]) // each file is only counted once
+	assert.Equal(t, int64(1024), sums["scanoss-api.scan.file_size"])
+}
+
+func TestParseWfpEntries(t *testing.T) {
+	entries, err := parseWfpEntries([]byte("file=37f7cd1e657aa3c30ece35995b4c59e5,3114,dir/file=a.py\n4=d5e54c33\n\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,file=b.py"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"37f7cd1e657aa3c30ece35995b4c59e5,3114,dir/file=a.py\n4=d5e54c33", "7c53a2de7dfeaa20d057db98468d6670,2321,file=b.py"}, entries)
+	_, err = parseWfpEntries([]byte("file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=zz"))
+	var syntaxErr *wfp.SyntaxError
+	assert.ErrorAs(t, err, &syntaxErr)
+	entries, err = parseWfpEntries(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// fileResult is a single NDJSON line holding the scan results for one file.
+type fileResult struct {
+	File   string          `json:"file"`
+	Result json.RawMessage `json:"result"`
+}
+
+// scanSummary is the trailing NDJSON line summarising a streamed scan.
+type scanSummary struct {
+	Summary struct {
+		Files        int           `json:"files"`
+		Chunks       int           `json:"chunks"`
+		FailedChunks int           `json:"failed_chunks"`
+		ElapsedMs    int64         `json:"elapsed_ms"`
+		Failures     []scanFailure `json:"failures,omitempty"`
+	} `json:"summary"`
+}
+
+// ndjsonWriter writes newline delimited JSON to the client, flushing after each line.
+// The response header is only sent with the first line, so that a scan producing nothing can still report an error.
+type ndjsonWriter struct {
+	w       http.ResponseWriter
+	zs      *zap.SugaredLogger
+	started bool
+	files   int
+	invalid int
+	dedupe  *wfpDeduper // Duplicate files to copy results to (if any)
+}
+
+// writeLine marshals the given value and sends it to the client as a single line.
+func (nw *ndjsonWriter) writeLine(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		nw.zs.Errorf("Failed to marshal NDJSON line: %v", err)
+		return
+	}
+	if !nw.started {
+		nw.w.Header().Set(ContentTypeKey, ApplicationNDJSON)
+		nw.w.WriteHeader(http.StatusOK)
+		nw.started = true
+	}
+	if _, err = nw.w.Write(append(data, '\n')); err != nil {
+		nw.zs.Errorf("Failed to write NDJSON line: %v", err)
+		return
+	}
+	if err = http.NewResponseController(nw.w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		nw.zs.Warnf("Failed to flush NDJSON line: %v", err)
+	}
+}
+
+// writeResult splits an engine scan result into individual files and sends a line for each.
+func (nw *ndjsonWriter) writeResult(result string) {
+	files, err := splitScanResult(result)
+	if err != nil {
+		nw.zs.Errorf("Failed to split scan result into files: %v", err)
+		nw.invalid++
+		return
+	}
+	nw.writeFiles(files)
+}
+
+// writeFiles sends a line for each of the given file results (and any duplicates of them).
+func (nw *ndjsonWriter) writeFiles(files []fileResult) {
+	files = append(files, nw.dedupe.expandFiles(files)...)
+	for _, file := range files {
+		nw.writeLine(file)
+		nw.files++
+	}
+}
+
+// splitScanResult parses a JSON scan result object (with or without its surrounding brackets) into per-file results, keeping the engine's order.
+func splitScanResult(result string) ([]fileResult, error) {
+	result = strings.TrimSpace(result)
+	if !strings.HasPrefix(result, "{") {
+		result = "{" + result + "}"
+	}
+	dec := json.NewDecoder(strings.NewReader(result))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("scan result is not a JSON object: %v", err)
+	}
+	var files []fileResult
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scan result file name: %w", err)
+		}
+		name, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected scan result token: %v", tok)
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("failed to read scan result for %v: %w", name, err)
+		}
+		files = append(files, fileResult{File: name, Result: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("failed to read end of scan result: %w", err)
+	}
+	return files, nil
+}
+
+// appendFileResults adds the given file results to a JSON scan result object (with or without its surrounding brackets).
+func appendFileResults(result string, files []fileResult) string {
+	if len(files) == 0 {
+		return result
+	}
+	result = strings.TrimSpace(result)
+	var sb strings.Builder
+	sb.WriteString("{")
+	sb.WriteString(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(result, "{"), "}")))
+	for _, file := range files {
+		if sb.Len() > 1 {
+			sb.WriteString(",")
+		}
+		name, _ := json.Marshal(file.File) // Marshalling a string cannot fail
+		sb.Write(name)
+		sb.WriteString(":")
+		sb.Write(file.Result)
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// scanStream scans the request and streams one NDJSON line per file as the results arrive, followed by a summary line.
+func (s APIService) scanStream(w http.ResponseWriter, req *scanRequest, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) {
+	startTime := time.Now()
+	stream := &ndjsonWriter{w: w, zs: zs, dedupe: req.dedupe}
+	emit := func(result string) {
+		req.cached.store(result, zs)
+		stream.writeResult(result)
+	}
+	var stats chunkStats
+	switch {
+	case req.cached.complete(): // Every file has a cached result, so there is nothing to scan
+		stream.writeFiles(req.cached.hits)
+	case s.config.Scanning.Workers <= 1:
+		result, err := s.singleScan(context, string(req.contents), req.sbomFilename(), req.config, zs)
+		if err != nil {
+			s.refundQuota(req)
+			s.writeScanResponse(w, "", nil, err, zs)
+			return
+		}
+		emit(result)
+		if req.cached != nil {
+			stream.writeFiles(req.cached.hits)
+		}
+		stats.requests, stats.received = 1, 1
+	default:
+		if req.cached != nil {
+			stream.writeFiles(req.cached.hits) // Send the cached results straight away
+		}
+		stats = s.scanChunks(context, sliceWfpSource(req.wfps), int(req.wfpCount), req.sbomFilename(), req.config, zs, span, nil, emit)
+		stats.failures = req.dedupe.expandFailures(stats.failures)
+	}
+	if !stream.started {
+		zs.Errorf("Streamed scan failed to produce results")
+		s.refundQuota(req)
+		s.writeScanResponse(w, "", stats.failures, noResultsError(stats.failures), zs)
+		return
+	}
+	chunks := stats.requests
+	var summary scanSummary
+	summary.Summary.Files = stream.files
+	summary.Summary.Chunks = chunks
+	summary.Summary.FailedChunks = chunks - stats.received + stream.invalid
+	summary.Summary.Failures = stats.failures
+	summary.Summary.ElapsedMs = time.Since(startTime).Milliseconds()
+	stream.writeLine(summary)
+	zs.Debugf("Streamed %v file results from %v chunks (%v failed)", stream.files, chunks, summary.Summary.FailedChunks)
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanDirectStream(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=d5e54c33\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\n" +
+		"file=8d53a2de7dfeaa20d057db98468d6671,1234,c.py"
+	tests := []struct {
+		name         string
+		workers      int
+		failOn       string
+		wantStatus   int
+		wantFiles    []string
+		wantChunks   int
+		wantFailures int
+	}{
+		{name: "Single worker", workers: 1, wantStatus: http.StatusOK, wantFiles: []string{"a.py", "b.py", "c.py"}, wantChunks: 1},
+		{name: "Multiple workers", workers: 2, wantStatus: http.StatusOK, wantFiles: []string{"a.py", "b.py", "c.py"}, wantChunks: 3},
+		{name: "Partial failure", workers: 2, failOn: "b.py", wantStatus: http.StatusOK, wantFiles: []string{"a.py", "c.py"}, wantChunks: 3, wantFailures: 1},
+		{name: "Single worker failure", workers: 1, failOn: "b.py", wantStatus: http.StatusInternalServerError},
+		{name: "Total failure", workers: 2, failOn: "file=", wantStatus: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			myConfig := setupConfig(t)
+			myConfig.Scanning.Workers = test.workers
+			myConfig.Scanning.WfpGrouping = 1
+			apiService := NewAPIServiceWithEngine(myConfig, &fakeEngine{failOn: test.failOn})
+			req := newScanReq(t, "http://localhost/scan/direct", wfp, nil)
+			req.Header.Set(AcceptKey, ApplicationNDJSON)
+			w := httptest.NewRecorder()
+			apiService.ScanDirect(w, req)
+			resp := w.Result()
+			assert.Equal(t, test.wantStatus, resp.StatusCode)
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, ApplicationNDJSON, resp.Header.Get(ContentTypeKey))
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			assert.Len(t, lines, len(test.wantFiles)+1)
+			var files []string
+			for _, line := range lines[:len(lines)-1] {
+				var file fileResult
+				assert.NoError(t, json.Unmarshal([]byte(line), &file))
+				assert.JSONEq(t, `[{"id":"none"}]`, string(file.Result))
+				files = append(files, file.File)
+			}
+			assert.ElementsMatch(t, test.wantFiles, files)
+			var summary scanSummary
+			assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &summary))
+			assert.Equal(t, len(test.wantFiles), summary.Summary.Files)
+			assert.Equal(t, test.wantChunks, summary.Summary.Chunks)
+			assert.Equal(t, test.wantFailures, summary.Summary.FailedChunks)
+			assert.Len(t, summary.Summary.Failures, test.wantFailures)
+		})
+	}
+}
+
+func TestSplitScanResult(t *testing.T) {
+	files, err := splitScanResult(` {"b.py":[{"id":"none"}],"a.py":[{"id":"file","purl":["pkg:github/a/b"]}]} `)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "b.py", files[0].File)
+	assert.Equal(t, "a.py", files[1].File)
+	assert.JSONEq(t, `[{"id":"file","purl":["pkg:github/a/b"]}]`, string(files[1].Result))
+
+	files, err = splitScanResult(`"c.py":[{"id":"none"}]`) // worker results have their brackets stripped
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "c.py", files[0].File)
+
+	for _, bad := range []string{`[1,2]`, `{"a.py":}`, `{"a.py":[]`, `not json`} {
+		_, err = splitScanResult(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"scanoss.com/go-api/pkg/wfp"
+)
+
+const maxFormFieldSize = 1024 * 1024 // Maximum size of a (non-SBOM) form field in a streamed upload
+
+// Form fields which affect the scanning configuration (and must be sent before the WFP file when streaming).
+var scanConfigFields = map[string]bool{"flags": true, "type": true, "assets": true, "db_name": true, "callback_url": true}
+
+// wfpSource yields the next WFP file entry (file=...) to scan. It returns io.EOF once there are no more.
+type wfpSource func() (string, error)
+
+// sliceWfpSource returns a source for WFPs which have already been split (on file=) into a slice.
+func sliceWfpSource(wfps []string) wfpSource {
+	i := 0
+	return func() (string, error) {
+		for i < len(wfps) {
+			entry := strings.TrimSpace(wfps[i])
+			i++
+			if len(entry) > 0 { // Ignore empty entries
+				return "file=" + entry, nil
+			}
+		}
+		return "", io.EOF
+	}
+}
+
+// limitError reports that a request exceeded one of the configured limits.
+type limitError struct {
+	limit    string
+	maxValue int64
+	unit     string
+}
+
+// Error returns the limit error message.
+func (e *limitError) Error() string {
+	return fmt.Sprintf("request exceeds the maximum %v (%v %v)", e.limit, e.maxValue, e.unit)
+}
+
+// wfpStream reads and validates WFP file entries from an upload one at a time, so that the whole upload is never held in memory.
+type wfpStream struct {
+	reader   *wfp.Reader
+	files    int64        // Number of file entries read so far
+	size     int64        // Total size of the files read so far
+	maxFiles int64        // Maximum number of file entries allowed (0 = unlimited)
+	hpsm     bool         // HPSM entries are allowed
+	quota    func() error // Charges each file to the client's daily quota (if set)
+	charged  int64        // Number of files charged to the quota so far
+	err      error        // Failure reading the upload (if any)
+}
+
+// newWfpStream creates a WFP entry reader for the given upload, applying the server limits.
+func (s APIService) newWfpStream(r io.Reader) *wfpStream {
+	return &wfpStream{reader: wfp.NewReader(r), maxFiles: s.config.Scanning.MaxWfpFiles, hpsm: s.config.Scanning.HPSMEnabled}
+}
+
+// next returns the next complete WFP file entry from the upload, recording any failure to read it.
+// The entry is validated using its parsed form, but passed on as originally supplied.
+func (ws *wfpStream) next() (string, error) {
+	file, err := ws.reader.Next()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			ws.err = err
+		}
+		return "", err
+	}
+	ws.files++
+	if ws.maxFiles > 0 && ws.files > ws.maxFiles {
+		ws.err = &limitError{limit: limitWfpFiles, maxValue: ws.maxFiles, unit: "files"}
+		return "", ws.err
+	}
+	if !ws.hpsm && len(file.HPSM) > 0 {
+		ws.err = &scanError{status: http.StatusForbidden, code: codeFeatureDisabled, message: "HPSM detected in WFP. HPSM is disabled"}
+		return "", ws.err
+	}
+	if ws.quota != nil {
+		if err = ws.quota(); err != nil {
+			ws.err = err
+			return "", err
+		}
+		ws.charged++
+	}
+	ws.size += file.Size
+	return ws.reader.Text(), nil
+}
+
+// streamUpload reports if the scan request should be read and scanned as it is received.
+// This is only possible for multi-worker scans of multipart uploads, returning the standard (JSON) response.
+func (s APIService) streamUpload(r *http.Request) bool {
+	if !s.config.Scanning.StreamUploads || s.config.Scanning.Workers <= 1 || strings.Contains(r.Header.Get(AcceptKey), ApplicationNDJSON) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get(ContentTypeKey))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// openWfpUpload reads the form fields from a multipart upload until it reaches the WFP file, which is returned for streaming.
+// The form fields read are stored in the request, so that they can be used to build the scanning configuration.
+func (s APIService) openWfpUpload(r *http.Request, zs *zap.SugaredLogger) (*multipart.Reader, *multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+	form := url.Values{}
+	defer func() {
+		for key, values := range r.URL.Query() { // Keep the query values available to the form (after the body values)
+			form[key] = append(form[key], values...)
+		}
+		r.Form = form
+	}()
+	for {
+		var part *multipart.Part
+		part, err = reader.NextPart()
+		if err != nil {
+			return nil, nil, err
+		}
+		name := part.FormName()
+		if len(part.FileName()) > 0 {
+			if name == "file" || name == "filename" {
+				return reader, part, nil
+			}
+			zs.Debugf("Ignoring unexpected file upload: %v", name)
+			continue
+		}
+		maxSize := int64(maxFormFieldSize)
+		if name == "assets" { // SBOMs have their own (optional) limit, otherwise only the upload limit applies
+			maxSize = s.config.Scanning.MaxSbomSize * 1024 * 1024
+		}
+		var value []byte
+		if maxSize > 0 {
+			value, err = io.ReadAll(io.LimitReader(part, maxSize+1))
+		} else {
+			value, err = io.ReadAll(part)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if maxSize > 0 && int64(len(value)) > maxSize {
+			if name == "assets" {
+				return nil, nil, &limitError{limit: limitSbomSize, maxValue: s.config.Scanning.MaxSbomSize, unit: "MB"}
+			}
+			return nil, nil, &scanError{status: http.StatusBadRequest, code: codeInvalidRequest, message: fmt.Sprintf("form field '%v' is too large", name)}
+		}
+		form.Add(name, string(value))
+	}
+}
+
+// checkLateFields reads the rest of the upload, making sure no scanning configuration was sent after the WFP file.
+func checkLateFields(reader *multipart.Reader, zs *zap.SugaredLogger) error {
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if scanConfigFields[part.FormName()] {
+			zs.Errorf("Form field (%v) sent after the WFP file. Unable to apply it to a streamed scan", part.FormName())
+			return &scanError{status: http.StatusBadRequest, code: codeInvalidRequest, message: "form fields must be sent before the WFP file"}
+		}
+	}
+}
+
+// writeUploadError reports a failure reading a streamed WFP upload back to the client.
+func writeUploadError(w http.ResponseWriter, err error, maxUpload int64, zs *zap.SugaredLogger, span oteltrace.Span) {
+	var limitErr *limitError
+	var scanErr *scanError
+	var syntaxErr *wfp.SyntaxError
+	var quotaErr *quotaError
+	switch {
+	case errors.As(err, &syntaxErr):
+		writeInvalidWfp(w, syntaxErr, zs, span)
+	case errors.As(err, &quotaErr):
+		zs.Warnf("Upload rejected: %v", quotaErr)
+		writeQuotaExceeded(w, quotaErr, zs)
+		setSpanError(span, "Daily scan quota exceeded.")
+	case isMaxBytesError(err):
+		writeLimitExceeded(w, limitUploadSize, maxUpload, "MB", zs)
+		setSpanError(span, "Upload size limit exceeded.")
+	case errors.As(err, &limitErr):
+		writeLimitExceeded(w, limitErr.limit, limitErr.maxValue, limitErr.unit, zs)
+		setSpanError(span, "Request limit exceeded.")
+	case errors.As(err, &scanErr):
+		scanErr.write(w, zs)
+		setSpanError(span, scanErr.message)
+	default:
+		zs.Errorf("Failed to read WFP upload: %v", err)
+		writeError(w, codeInvalidRequest, "failed to receive WFP file contents", nil, zs)
+		setSpanError(span, "Failed to read WFP upload.")
+	}
+}
+
+// scanUpload scans a multipart WFP upload as it is received, sending each group of files to the workers
+// straight from the request body. Memory use is bounded by the number of workers rather than the size of the upload.
+func (s APIService) scanUpload(w http.ResponseWriter, r *http.Request, zs *zap.SugaredLogger, context context.Context, span oteltrace.Span) int64 {
+	s.limitRequestBody(w, r)
+	if !s.decompressRequestBody(w, r, zs) {
+		setSpanError(span, "Invalid compressed request body.")
+		return 0
+	}
+	reader, part, err := s.openWfpUpload(r, zs)
+	if err != nil {
+		writeUploadError(w, err, s.config.Scanning.MaxUploadSize, zs, span)
+		return 0
+	}
+	scanConfig, ok := s.getScanConfig(w, r, zs, span)
+	if !ok {
+		return 0
+	}
+	req := &scanRequest{config: scanConfig}
+	if !s.storeSbomFile(w, req, zs) {
+		return 0
+	}
+	defer s.removeSbomFile(req, zs)
+	context, admitted := s.limiter.admit(context) // Don't accept more work if the engine queue is already full
+	if !admitted {
+		s.writeEngineBusy(w, zs)
+		setSpanError(span, "Engine queue full.")
+		return 0
+	}
+	if len(req.config.callbackURL) > 0 {
+		zs.Warnf("Ignoring callback URL for synchronous scan request: %v", req.config.callbackURL)
+	}
+	zs.Debugf("Streaming WFP upload (%v) to the scanning workers", part.FileName())
+	stream := s.newWfpStream(part)
+	client := s.clientID(r)
+	if s.scanLimits != nil { // Files are charged to the daily quota as they arrive (and refunded if the upload fails)
+		stream.quota = func() error { return s.scanLimits.charge(client, 1) }
+	}
+	source := wfpSource(stream.next)
+	var deduper *wfpDeduper
+	if s.config.Scanning.DedupeFiles { // Duplicates are skipped as they arrive, and given their results once the scan is complete
+		deduper = newWfpDeduper(req.config.honourFileExts)
+		source = deduper.filter(source)
+	}
+	cached := s.results.newScan(req.config)
+	if cached != nil { // Files with a cached result are skipped as they arrive, and the results added once the scan is complete
+		source = cached.filter(source)
+	}
+	result, failures, err := s.scanThreaded(context, source, 0, req.sbomFilename(), req.config, zs, span, nil)
+	result, err = cached.recoverEmpty(result, failures, err)
+	uploadErr := stream.err
+	if uploadErr == nil {
+		uploadErr = checkLateFields(reader, zs)
+	}
+	if uploadErr == nil && stream.files == 0 {
+		zs.Errorf("No WFP (file=...) entries found to scan")
+		uploadErr = &scanError{status: http.StatusBadRequest, code: codeInvalidRequest, message: "no WFP file contents (file=...) supplied"}
+	}
+	if uploadErr != nil { // Any results are incomplete, so discard them (and refund the files charged, as for buffered uploads)
+		s.scanLimits.refund(client, stream.charged)
+		writeUploadError(w, uploadErr, s.config.Scanning.MaxUploadSize, zs, span)
+		return 0
+	}
+	if err != nil { // The scan failed, so refund the files charged (as for buffered uploads)
+		s.scanLimits.refund(client, stream.charged)
+	}
+	s.recordScanSize(stream.files, stream.size, zs, context, span)
+	zs.Infof("Scanned %v files of size %v", stream.files, stream.size)
+	s.recordCacheStats(cached, zs, context, span)
+	if err == nil {
+		result, _ = cached.finish(result, zs)
+	}
+	if deduper != nil {
+		s.recordDedupe(deduper, zs, context, span)
+		failures = deduper.expandFailures(failures)
+		if err == nil {
+			result, _ = deduper.expandResult(result, zs)
+		}
+	}
+	s.writeScanResponse(w, result, failures, err, zs)
+	return stream.files
+}
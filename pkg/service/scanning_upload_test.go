@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	zlog "github.com/scanoss/zap-logging-helper/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	wfpkg "scanoss.com/go-api/pkg/wfp"
+)
+
+func TestWfpStream(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	wfp := "\nfile=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=579a7cd6\r\n\n8=8A5E2B7C\nfh2=5f1bd4a5\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\n" +
+		"hpsm=abcd\nfile=7c53a2de7dfeaa20d057db98468d6671,10,c.py"
+	stream := &wfpStream{reader: wfpkg.NewReader(strings.NewReader(wfp)), hpsm: true}
+	var entries []string
+	for {
+		entry, err := stream.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, []string{
+		"file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=579a7cd6\n8=8A5E2B7C\nfh2=5f1bd4a5", // Passed on as supplied
+		"file=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\nhpsm=abcd",
+		"file=7c53a2de7dfeaa20d057db98468d6671,10,c.py",
+	}, entries)
+	assert.Equal(t, int64(3), stream.files)
+	assert.Equal(t, int64(5445), stream.size)
+	assert.NoError(t, stream.err)
+
+	// HPSM disabled
+	stream = &wfpStream{reader: wfpkg.NewReader(strings.NewReader(wfp))}
+	_, err = stream.next()
+	assert.NoError(t, err)
+	_, err = stream.next()
+	var scanErr *scanError
+	if assert.ErrorAs(t, err, &scanErr) {
+		assert.Equal(t, http.StatusForbidden, scanErr.status)
+	}
+	assert.Equal(t, err, stream.err)
+
+	// Too many files
+	stream = &wfpStream{reader: wfpkg.NewReader(strings.NewReader(wfp)), hpsm: true, maxFiles: 1}
+	_, err = stream.next()
+	assert.NoError(t, err)
+	_, err = stream.next()
+	var limitErr *limitError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, limitWfpFiles, limitErr.limit)
+
+	// Invalid WFP
+	stream = &wfpStream{reader: wfpkg.NewReader(strings.NewReader("file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n4=579a7cd\n"))}
+	_, err = stream.next()
+	var syntaxErr *wfpkg.SyntaxError
+	if assert.ErrorAs(t, err, &syntaxErr) {
+		assert.Equal(t, 2, syntaxErr.Line)
+	}
+	assert.Equal(t, err, stream.err)
+
+	// Already split WFPs
+	source := sliceWfpSource([]string{"", "37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n", "  ", "7c53a2de7dfeaa20d057db98468d6670,2321,b.py"})
+	entry, err := source()
+	assert.NoError(t, err)
+	assert.Equal(t, "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py", entry)
+	entry, err = source()
+	assert.NoError(t, err)
+	assert.Equal(t, "file=7c53a2de7dfeaa20d057db98468d6670,2321,b.py", entry)
+	_, err = source()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// newUploadReq sets up a multipart scan request with the WFP file sent before the given form fields.
+func newUploadReq(t *testing.T, wfp string, fields map[string]string) *http.Request {
+	postBody := new(bytes.Buffer)
+	mw := multipart.NewWriter(postBody)
+	writer, err := mw.CreateFormFile("file", "fingers.wfp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.WriteString(writer, wfp); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range fields {
+		if err = mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = mw.Close() // close the writer before making the request
+	req := httptest.NewRequest(http.MethodPost, "/scan/direct", postBody)
+	req.Header.Add("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestScanUpload(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.StreamUploads = true
+	myConfig.Scanning.Workers = 3
+	myConfig.Scanning.WfpGrouping = 2
+	myConfig.Scanning.HPSMEnabled = false
+	myConfig.Scanning.MaxWfpFiles = 50
+	myConfig.Scanning.MaxUploadSize = 1
+	myConfig.Scanning.ScanDailyQuota = 1000
+	engine := &fakeEngine{}
+	apiService := NewAPIServiceWithEngine(myConfig, engine)
+	var sb strings.Builder
+	for i := 0; i < 25; i++ {
+		sb.WriteString(fmt.Sprintf("file=37f7cd1e657aa3c30ece35995b4c59e5,3114,file%d.py\n4=579a7cd6\n", i))
+	}
+	wfp := sb.String()
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		want   int
+		body   string
+		stream bool
+	}{
+		{name: "scan", req: newScanReq(t, "/scan/direct", wfp, map[string]string{"flags": "16"}), want: http.StatusOK, body: `"file24.py":[{"id":"none"}]`, stream: true},
+		{name: "late fields", req: newUploadReq(t, wfp, map[string]string{"db_name": "test"}), want: http.StatusBadRequest, body: "form fields must be sent before", stream: true},
+		{name: "late unknown fields", req: newUploadReq(t, wfp, map[string]string{"context": "test"}), want: http.StatusOK, body: `"file0.py"`, stream: true},
+		{name: "no files", req: newScanReq(t, "/scan/direct", "\n\n", nil), want: http.StatusBadRequest, body: "no WFP file contents", stream: true},
+		{name: "hpsm", req: newScanReq(t, "/scan/direct", wfp+"file=37f7cd1e657aa3c30ece35995b4c59e5,3114,x.py\nhpsm=1234\n", nil), want: http.StatusForbidden,
+			body: "HPSM is disabled", stream: true},
+		{name: "invalid wfp", req: newScanReq(t, "/scan/direct", wfp+"file=37f7cd1e657aa3c30ece35995b4c59e5,abc,x.py\n", nil), want: http.StatusBadRequest,
+			body: "invalid WFP at line 51", stream: true},
+		{name: "too many files", req: newScanReq(t, "/scan/direct", wfp+wfp+wfp, nil), want: http.StatusRequestEntityTooLarge, body: `"limit":"wfp_files"`, stream: true},
+		{name: "too large", req: newScanReq(t, "/scan/direct", wfp+strings.Repeat("x", 1024*1024), nil), want: http.StatusRequestEntityTooLarge,
+			body: `"limit":"upload_size"`, stream: true},
+		{name: "no file", req: newReq(http.MethodPost, "/scan/direct", "", nil), want: http.StatusBadRequest, body: "failed to receive WFP file contents"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.stream, apiService.streamUpload(tt.req))
+			client := apiService.clientID(tt.req)
+			charged := apiService.scanLimits.usage().Clients[client].Files
+			w := httptest.NewRecorder()
+			apiService.ScanDirect(w, tt.req)
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.body)
+			charged = apiService.scanLimits.usage().Clients[client].Files - charged
+			if tt.want == http.StatusOK {
+				assert.Equal(t, int64(25), charged)
+			} else {
+				assert.Zero(t, charged) // Failed uploads are not charged to the quota
+			}
+		})
+	}
+	// Other responses (and configurations) read the whole upload first
+	req := newScanReq(t, "/scan/direct", wfp, nil)
+	req.Header.Set(AcceptKey, ApplicationNDJSON)
+	assert.False(t, apiService.streamUpload(req))
+	myConfig.Scanning.Workers = 1
+	assert.False(t, apiService.streamUpload(newScanReq(t, "/scan/direct", wfp, nil)))
+	myConfig.Scanning.Workers = 3
+	myConfig.Scanning.StreamUploads = false
+	assert.False(t, apiService.streamUpload(newScanReq(t, "/scan/direct", wfp, nil)))
+}
+
+func TestScanUploadSbom(t *testing.T) {
+	err := zlog.NewSugaredDevLogger()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a sugared logger", err)
+	}
+	defer zlog.SyncZap()
+	myConfig := setupConfig(t)
+	myConfig.Scanning.StreamUploads = true
+	myConfig.Scanning.Workers = 2
+	myConfig.Scanning.WfpGrouping = 1
+	myConfig.Scanning.MaxSbomSize = 1
+	apiService := NewAPIService(myConfig)
+	wfp := "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\nfile=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\n"
+	w := httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "/scan/direct?db_name=oss", wfp, map[string]string{"type": "identify", "assets": `{"components":[]}`}))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "kb_version")
+	w = httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, map[string]string{"type": "bad", "assets": `{"components":[]}`}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid SBOM 'type'")
+	w = httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, map[string]string{"type": "identify", "assets": strings.Repeat("x", 1024*1024+1)}))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), `"limit":"sbom_size"`)
+	w = httptest.NewRecorder()
+	apiService.ScanDirect(w, newScanReq(t, "/scan/direct", wfp, map[string]string{"db_name": "test_kb"})) // The engine fails for this KB
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -26,6 +26,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -45,12 +46,40 @@ const (
 	RequestIDKey         = "x-request-id"
 	ResponseIDKey        = "x-response-id"
 	ApplicationJSON      = "application/json"
+	ApplicationNDJSON    = "application/x-ndjson"
+	AcceptKey            = "Accept"
 	TextPlain            = "text/plain"
 	ReqLogKey            = "reqId"
 	SpanLogKey           = "span_id"
 	TraceLogKey          = "trace_id"
+	KeyIDLogKey          = "key_id"
+	SubjectLogKey        = "subject"
+	ClientCertLogKey     = "client_cert"
 	CharsetDetectedKey   = "X-Detected-Charset"
+	ScanFailedFilesKey   = "X-Scan-Failed-Files"
+	ScanFailuresKey      = "X-Scan-Failures"
 	ContentLengthKey     = "Content-Length"
+	RetryAfterKey        = "Retry-After"
+	ETagKey              = "ETag"
+	CacheControlKey      = "Cache-Control"
+	IfNoneMatchKey       = "If-None-Match"
+	RangeKey             = "Range"
+	AcceptRangesKey      = "Accept-Ranges"
+	ContentRangeKey      = "Content-Range"
+	ContentLinesKey      = "X-Content-Lines"
+	FileMD5Key           = "X-File-MD5"
+	ErrorCodeKey         = "X-Error-Code"
+	TranscodingKey       = "X-Charset-Transcoding"
+	AcceptCharsetKey     = "Accept-Charset"
+	VaryKey              = "Vary"
+	AcceptEncodingKey    = "Accept-Encoding"
+	ContentEncodingKey   = "Content-Encoding"
+	SessionKey           = "X-Session"
+	AuthorizationKey     = "Authorization"
+	AuthenticateKey      = "WWW-Authenticate"
+	RateLimitKey         = "X-RateLimit-Limit"
+	RateRemainingKey     = "X-RateLimit-Remaining"
+	RateResetKey         = "X-RateLimit-Reset"
 	CharSetMinConfidence = 0.7
 )
 
@@ -63,16 +92,69 @@ type SpanContextKey struct{}
 // TraceContextKey Trace ID Key name for using with Context.
 type TraceContextKey struct{}
 
+// KeyIDContextKey API Key ID Key name for using with Context.
+type KeyIDContextKey struct{}
+
+// SubjectContextKey Token Subject Key name for using with Context.
+type SubjectContextKey struct{}
+
+// ClientCertContextKey Client Certificate Identity Key name for using with Context.
+type ClientCertContextKey struct{}
+
 // APIService details.
 type APIService struct {
 	config                 *myconfig.ServerConfig
+	engine                 Engine
+	jobs                   *scanJobStore
+	limiter                *engineLimiter
+	results                *resultCache
+	contents               cacheBackend
+	apiKeys                *apiKeyStore
+	tokens                 *jwtVerifier
+	scanLimits             *rateLimiter
+	contentsLimits         *rateLimiter
 	fileContentslimitBytes int64
 }
 
 // NewAPIService instantiates an API Service instance for servicing the API requests.
+// Scans use a pool of persistent engine workers if configured, otherwise an engine process is started per scan.
 func NewAPIService(config *myconfig.ServerConfig) *APIService {
+	subprocess := NewSubprocessEngine(config)
+	if config.Scanning.EnginePoolSize > 0 {
+		return NewAPIServiceWithEngine(config, NewPoolEngine(config, subprocess))
+	}
+	return NewAPIServiceWithEngine(config, subprocess)
+}
+
+// NewAPIServiceWithEngine instantiates an API Service instance using the supplied scanning engine.
+// If a server-wide engine process limit is configured, the engine is wrapped to enforce it.
+func NewAPIServiceWithEngine(config *myconfig.ServerConfig, engine Engine) *APIService {
 	setupMetrics()
-	return &APIService{config: config, fileContentslimitBytes: config.Scanning.FileContentsLimit * 1024 * 1024}
+	var limiter *engineLimiter
+	if config.Scanning.EngineMaxProcesses > 0 {
+		limiter = newEngineLimiter(config.Scanning.EngineMaxProcesses, config.Scanning.EngineQueueSize,
+			time.Duration(config.Scanning.EngineQueueTimeout)*time.Second)
+		engine = &limitedEngine{engine: engine, limiter: limiter}
+	}
+	sc := config.Scanning
+	scanLimits := newRateLimiter(rateClassScan, sc.ScanRateLimit, sc.ScanRateBurst, sc.ScanDailyQuota)
+	contentsLimits := newRateLimiter(rateClassContents, sc.ContentsRateLimit, sc.ContentsRateBurst, sc.ContentsDailyQuota)
+	setActiveLimits(scanLimits, contentsLimits)
+	var tokens *jwtVerifier
+	if config.Auth.Enabled && config.Auth.JWTEnabled {
+		tokens = newJWTVerifier(config)
+	}
+	return &APIService{config: config, engine: engine, jobs: newScanJobStore(sc.JobQueueSize), limiter: limiter,
+		results: newResultCache(config), contents: newFileContentsCache(config), apiKeys: &apiKeyStore{}, tokens: tokens,
+		scanLimits: scanLimits, contentsLimits: contentsLimits, fileContentslimitBytes: sc.FileContentsLimit * 1024 * 1024}
+}
+
+// Close releases any resources held by the scanning engine (i.e. persistent engine workers) and stops reloading the JWKS.
+func (s APIService) Close() {
+	if closer, ok := s.engine.(engineCloser); ok {
+		closer.Close()
+	}
+	s.tokens.stop()
 }
 
 // Structure for counting the total number of requests processed.
@@ -96,6 +178,11 @@ type metricsCounters struct {
 	scanFileHistogram         metric.Int64Histogram // milliseconds
 	scanHistogramSec          metric.Float64Histogram
 	scanFileHistogramSec      metric.Float64Histogram
+	engineQueueDepth          metric.Int64UpDownCounter
+	engineQueueWaitHistogram  metric.Int64Histogram // milliseconds
+	scanDedupeCounter         metric.Int64Counter
+	scanCacheHitCounter       metric.Int64Counter
+	scanCacheMissCounter      metric.Int64Counter
 }
 
 var oltpMetrics = metricsCounters{}
@@ -112,6 +199,11 @@ func setupMetrics() {
 	oltpMetrics.scanFileHistogram, _ = meter.Int64Histogram("scanoss-api.scan.file_time", metric.WithDescription("The average time taken to scan a single file in a request (ms)"))
 	oltpMetrics.scanHistogramSec, _ = meter.Float64Histogram("scanoss-api.scan.req_time_sec", metric.WithDescription("The time taken to run a scan request (seconds)"))
 	oltpMetrics.scanFileHistogramSec, _ = meter.Float64Histogram("scanoss-api.scan.file_time_sec", metric.WithDescription("Average time to scan a single file per request (seconds)"))
+	oltpMetrics.engineQueueDepth, _ = meter.Int64UpDownCounter("scanoss-api.engine.queue_depth", metric.WithDescription("The number of requests waiting for an engine process"))
+	oltpMetrics.engineQueueWaitHistogram, _ = meter.Int64Histogram("scanoss-api.engine.queue_wait", metric.WithDescription("The time spent waiting for an engine process (ms)"))
+	oltpMetrics.scanDedupeCounter, _ = meter.Int64Counter("scanoss-api.scan.dedupe_files", metric.WithDescription("The number of duplicate scan request files not sent to the engine"))
+	oltpMetrics.scanCacheHitCounter, _ = meter.Int64Counter("scanoss-api.scan.cache_hits", metric.WithDescription("The number of scan request files with a cached result"))
+	oltpMetrics.scanCacheMissCounter, _ = meter.Int64Counter("scanoss-api.scan.cache_misses", metric.WithDescription("The number of scan request files without a cached result"))
 }
 
 // incRequest increments the count for the given request type.
@@ -150,17 +242,18 @@ func HeadResponse(w http.ResponseWriter, r *http.Request) {
 
 // MetricsHandler responds with the metrics for the requested type.
 func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(ResponseIDKey, getReqID(r))
 	vars := mux.Vars(r)
 	zlog.S.Debugf("%v request from %v - %v", r.URL.Path, r.RemoteAddr, vars)
 	if len(vars) == 0 {
 		zlog.S.Errorf("Failed to retrieve request variables")
-		http.Error(w, "ERROR no request variables submitted", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no request variables submitted", nil, zlog.S)
 		return
 	}
 	mType, ok := vars["type"]
 	if !ok {
 		zlog.S.Errorf("Failed to retrieve type request variable from: %v", vars)
-		http.Error(w, "ERROR no type request variable submitted", http.StatusBadRequest)
+		writeError(w, codeInvalidRequest, "no type request variable submitted", nil, zlog.S)
 		return
 	}
 	// Convert bytes to megabytes
@@ -174,16 +267,19 @@ func MetricsHandler(w http.ResponseWriter, r *http.Request) {
 		return fmt.Sprintf("{\"alloc\": \"%.2f MiB\", \"total-alloc\": \"%.2f MiB\", \"sys\": \"%.2f MiB\"}", bToMb(m.Alloc), bToMb(m.TotalAlloc), bToMb(m.Sys))
 	}
 	reqCount := func() string {
-		return fmt.Sprintf("{\"scans\": %v, \"files\": %v, \"file_contents\": %v, \"attribution\": %v, \"license_details\": %v}",
-			counters.values["scan"], counters.values["files"], counters.values["file_contents"], counters.values["attribution"],
-			counters.values["license_details"])
+		return fmt.Sprintf("{\"scans\": %v, \"scan_jobs\": %v, \"files\": %v, \"scan_retries\": %v, \"scan_split_chunks\": %v, "+
+			"\"dedupe_files\": %v, \"cache_hits\": %v, \"cache_misses\": %v, "+
+			"\"file_contents\": %v, \"file_contents_batch\": %v, \"contents_cache_hits\": %v, \"contents_cache_misses\": %v, \"attribution\": %v, \"license_details\": %v}",
+			counters.values["scan"], counters.values["scan_jobs"], counters.values["files"], counters.values["scan_retries"],
+			counters.values["scan_split_chunks"], counters.values["dedupe_files"], counters.values["cache_hits"], counters.values["cache_misses"],
+			counters.values["file_contents"], counters.values["file_contents_batch"], counters.values["contents_cache_hits"], counters.values["contents_cache_misses"],
+			counters.values["attribution"], counters.values["license_details"])
 	}
 	// Get the number of goroutines
 	routines := func() string {
 		return fmt.Sprintf("{\"count\": %v}", runtime.NumGoroutine())
 	}
 	var responseString string
-	responseStatus := http.StatusOK
 	switch mType {
 	case "goroutines":
 		responseString = routines()
@@ -191,14 +287,23 @@ func MetricsHandler(w http.ResponseWriter, r *http.Request) {
 		responseString = heap()
 	case "requests":
 		responseString = reqCount()
+	case "quotas":
+		quotas, err := quotaMetrics()
+		if err != nil {
+			zlog.S.Errorf("Failed to produce quota metrics: %v", err)
+			writeError(w, codeInternalError, "failed to produce quota metrics", nil, zlog.S)
+			return
+		}
+		responseString = quotas
 	case "all":
 		responseString = fmt.Sprintf("{\"goroutines\": %s, \"heap\": %s, \"requests\": %s}", routines(), heap(), reqCount())
 	default:
-		responseString = fmt.Sprintf("{\"error\": \"Unknown request type: %v. Supported: goroutines, heap, requests, all\"}", mType)
-		responseStatus = http.StatusBadRequest
+		zlog.S.Errorf("Unknown metrics request type: %v", mType)
+		writeError(w, codeInvalidRequest, fmt.Sprintf("unknown request type: %v. Supported: goroutines, heap, requests, quotas, all", mType), nil, zlog.S)
+		return
 	}
 	w.Header().Set(ContentTypeKey, ApplicationJSON)
-	w.WriteHeader(responseStatus)
+	w.WriteHeader(http.StatusOK)
 	zlog.S.Infof("Metrics: %v", responseString)
 	printResponse(w, responseString+"\n", zlog.S, true)
 }
@@ -230,6 +335,10 @@ func (s APIService) getFormFile(r *http.Request, zs *zap.SugaredLogger, formType
 	for _, fName := range formFiles { // Check for the contents in 'file' and 'filename'
 		var file multipart.File
 		file, _, err = r.FormFile(fName)
+		if isBodyLimitError(err) {
+			zs.Errorf("Request body too large to retrieve %s Form File: %v", formType, err)
+			return nil, err
+		}
 		if err != nil {
 			zs.Infof("Cannot retrieve %s Form File: %v - %v. Trying an alternative name...", formType, fName, err)
 			continue
@@ -250,8 +359,8 @@ func (s APIService) getFormFile(r *http.Request, zs *zap.SugaredLogger, formType
 	return contents, nil
 }
 
-// copyWfpTempFile copies a 'failed' WFP scan file to another file for later review.
-func (s APIService) copyWfpTempFile(filename string, zs *zap.SugaredLogger) string {
+// copyWfpTempFile copies a 'failed' WFP scan file to another file (in wfpLoc) for later review.
+func copyWfpTempFile(wfpLoc, filename string, zs *zap.SugaredLogger) string {
 	zs.Debugf("Backing up failed WFP file...")
 	source, err := os.Open(filename)
 	if err != nil {
@@ -259,7 +368,7 @@ func (s APIService) copyWfpTempFile(filename string, zs *zap.SugaredLogger) stri
 		return ""
 	}
 	defer closeFile(source, zs)
-	tempFile, err := os.CreateTemp(s.config.Scanning.WfpLoc, "failed-finger*.wfp")
+	tempFile, err := os.CreateTemp(wfpLoc, "failed-finger*.wfp")
 	if err != nil {
 		zs.Errorf("Failed to create temporary file: %v", err)
 		return ""
@@ -319,6 +428,15 @@ func sugaredLogger(ctx context.Context) *zap.SugaredLogger {
 		if ctxTraceID, ok := ctx.Value(TraceContextKey{}).(string); ok {
 			fields = append(fields, zap.String(TraceLogKey, ctxTraceID))
 		}
+		if ctxKeyID, ok := ctx.Value(KeyIDContextKey{}).(string); ok {
+			fields = append(fields, zap.String(KeyIDLogKey, ctxKeyID))
+		}
+		if ctxSubject, ok := ctx.Value(SubjectContextKey{}).(string); ok {
+			fields = append(fields, zap.String(SubjectLogKey, ctxSubject))
+		}
+		if ctxClientCert, ok := ctx.Value(ClientCertContextKey{}).(string); ok {
+			fields = append(fields, zap.String(ClientCertLogKey, ctxClientCert))
+		}
 		if len(fields) > 0 {
 			newLogger = newLogger.With(fields...)
 		}
@@ -362,6 +480,7 @@ func addSpanEvent(span oteltrace.Span, msg string, options ...oteltrace.EventOpt
 }
 
 // logRequestDetails logs details about the HTTP request, including method, path, and client IP addresses.
+// The caller's API key ID, token subject or client certificate (if any) is included by the logger (see sugaredLogger).
 func logRequestDetails(r *http.Request, zs *zap.SugaredLogger) {
 	sourceIP, forwardedIP := getClientIP(r)
 	// Create structured log fields
@@ -18,8 +18,10 @@ package service
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -44,6 +46,28 @@ func newReq(method, path, body string, vars map[string]string) *http.Request {
 	return mux.SetURLVars(r, vars)
 }
 
+// newScanReq sets up a multipart POST request containing the given WFP contents and extra form fields.
+func newScanReq(t *testing.T, path, wfp string, fields map[string]string) *http.Request {
+	postBody := new(bytes.Buffer)
+	mw := multipart.NewWriter(postBody)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer, err := mw.CreateFormFile("file", "fingers.wfp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.WriteString(writer, wfp); err != nil {
+		t.Fatal(err)
+	}
+	_ = mw.Close() // close the writer before making the request
+	req := httptest.NewRequest(http.MethodPost, path, postBody)
+	req.Header.Add("Content-Type", mw.FormDataContentType())
+	return req
+}
+
 // setupConfig sets up the default config for use.
 func setupConfig(t *testing.T) *myconfig.ServerConfig {
 	var feeders []config.Feeder
@@ -53,6 +77,7 @@ func setupConfig(t *testing.T) *myconfig.ServerConfig {
 	}
 	myConfig.Scanning.ScanDebug = true
 	myConfig.Scanning.ScanBinary = "../../test-support/scanoss.sh"
+	myConfig.Scanning.ScanRetryBackoff = 1 // Keep retries quick
 	return myConfig
 }
 
@@ -162,6 +187,9 @@ func TestMetricsHandler(t *testing.T) {
 				t.Fatalf("an error was not expected when reading from request: %v", err)
 			}
 			assert.Equal(t, test.want, resp.StatusCode)
+			if test.want == http.StatusOK {
+				assert.True(t, json.Valid(body), string(body))
+			}
 			fmt.Println("Status: ", resp.StatusCode)
 			fmt.Println("Type: ", resp.Header.Get("Content-Type"))
 			fmt.Println("Body: ", string(body))
@@ -176,9 +204,8 @@ func TestApiService(t *testing.T) {
 	}
 	defer zlog.SyncZap()
 	myConfig := setupConfig(t)
-	apiService := NewAPIService(myConfig)
-	apiService.copyWfpTempFile("", zlog.S)
-	tempFile := apiService.copyWfpTempFile("utils_service.go", zlog.S)
+	copyWfpTempFile(myConfig.Scanning.WfpLoc, "", zlog.S)
+	tempFile := copyWfpTempFile(myConfig.Scanning.WfpLoc, "utils_service.go", zlog.S)
 	assert.NotEmpty(t, tempFile)
 	source, err := os.Open(tempFile)
 	if err != nil {
@@ -0,0 +1,317 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package wfp parses, validates and serialises SCANOSS WFP (Winnowing Fingerprint) data.
+//
+// A WFP contains one entry per file:
+//
+//	file=<md5>,<size>,<path>
+//	hpsm=<hex crc8 line hashes>        (optional)
+//	<key>=<value>                      (optional extra attributes, i.e. fh2=...)
+//	<line>=<hash>[,<hash>...]          (snippet hashes for a line, as 8 hex digits)
+package wfp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	filePrefix = "file="
+	hpsmKey    = "hpsm"
+	md5Len     = 32 // Number of hex digits in an MD5
+	hashLen    = 8  // Number of hex digits in a snippet hash
+)
+
+// SyntaxError describes a problem with a WFP, and the line it was found on.
+type SyntaxError struct {
+	Line int    // Line number (starting from 1)
+	Msg  string // Description of the problem
+}
+
+// Error returns the syntax error message, including the line number.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Snippet holds the snippet hashes for a single line of a file.
+type Snippet struct {
+	Line   int
+	Hashes []uint32
+}
+
+// Attribute is any other key=value line in a file entry (i.e. fh2).
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// File is a single file entry from a WFP.
+type File struct {
+	MD5        string
+	Size       int64
+	Path       string
+	HPSM       string
+	Attributes []Attribute
+	Snippets   []Snippet
+	Line       int // Line number the entry started on
+}
+
+// Reader reads WFP file entries one at a time.
+type Reader struct {
+	reader       *bufio.Reader
+	line         int             // Current line number
+	pending      *File           // Entry being read (waiting for the start of the next one)
+	pendingText  strings.Builder // Original text of the pending entry
+	complete     *File           // Entry finished by the start of the next one
+	completeText string          // Original text of the complete entry
+	text         string          // Original text of the entry last returned by Next
+	done         bool
+}
+
+// NewReader creates a WFP reader for the given input.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next complete file entry. It returns io.EOF once there are no more entries.
+// A *SyntaxError is returned if the WFP is malformed.
+func (r *Reader) Next() (*File, error) {
+	for !r.done {
+		text, err := r.reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			r.done = true
+		} else if err != nil {
+			return nil, err
+		}
+		if len(text) == 0 && r.done {
+			break
+		}
+		r.line++
+		if err = r.parseLine(strings.TrimSpace(text)); err != nil {
+			return nil, err
+		}
+		if r.complete != nil {
+			file := r.complete
+			r.complete, r.text = nil, r.completeText
+			return file, nil
+		}
+	}
+	if r.pending != nil {
+		file := r.pending
+		r.pending, r.text = nil, r.pendingText.String()
+		r.pendingText.Reset()
+		return file, nil
+	}
+	return nil, io.EOF
+}
+
+// Text returns the original text of the entry last returned by Next: its non-blank lines, without surrounding white space.
+// Unlike File.String, this keeps the entry exactly as supplied (i.e. line order, case & padding).
+func (r *Reader) Text() string {
+	return r.text
+}
+
+// parseLine adds the given line to the pending entry. If the line starts a new entry, the previous one is marked as complete.
+func (r *Reader) parseLine(text string) error {
+	if len(text) == 0 {
+		return nil // Ignore blank lines
+	}
+	if strings.HasPrefix(text, filePrefix) {
+		file, err := parseFileLine(strings.TrimPrefix(text, filePrefix))
+		if err != nil {
+			return &SyntaxError{Line: r.line, Msg: err.Error()}
+		}
+		file.Line = r.line
+		r.complete, r.completeText = r.pending, r.pendingText.String()
+		r.pending = file
+		r.pendingText.Reset()
+		r.pendingText.WriteString(text)
+		return nil
+	}
+	if r.pending == nil {
+		return &SyntaxError{Line: r.line, Msg: "expected a file entry (file=<md5>,<size>,<path>)"}
+	}
+	key, value, found := strings.Cut(text, "=")
+	if !found || len(key) == 0 {
+		return &SyntaxError{Line: r.line, Msg: "expected a key=value entry"}
+	}
+	var err error
+	switch {
+	case key == hpsmKey:
+		err = r.pending.setHPSM(value)
+	case isDigits(key):
+		err = r.pending.addSnippet(key, value)
+	default:
+		err = r.pending.addAttribute(key, value)
+	}
+	if err != nil {
+		return &SyntaxError{Line: r.line, Msg: err.Error()}
+	}
+	r.pendingText.WriteString("\n" + text)
+	return nil
+}
+
+// parseFileLine parses the <md5>,<size>,<path> details of a file entry.
+func parseFileLine(details string) (*File, error) {
+	parts := strings.SplitN(details, ",", 3) // The path may contain commas
+	if len(parts) != 3 {
+		return nil, errors.New("file entry must have an md5, size and path (file=<md5>,<size>,<path>)")
+	}
+	if len(parts[0]) != md5Len || !isHex(parts[0]) {
+		return nil, fmt.Errorf("invalid file md5: %q", parts[0])
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid file size: %q", parts[1])
+	}
+	if len(parts[2]) == 0 {
+		return nil, errors.New("missing file path")
+	}
+	return &File{MD5: parts[0], Size: size, Path: parts[2]}, nil
+}
+
+// setHPSM sets the HPSM line hashes for the file.
+func (f *File) setHPSM(value string) error {
+	if len(f.HPSM) > 0 {
+		return errors.New("duplicate hpsm entry")
+	}
+	if len(value) == 0 || len(value)%2 != 0 || !isHex(value) {
+		return errors.New("invalid hpsm value (expected pairs of hex digits)")
+	}
+	f.HPSM = value
+	return nil
+}
+
+// addSnippet adds the hashes for a snippet line to the file.
+func (f *File) addSnippet(line, value string) error {
+	lineNum, err := strconv.Atoi(line)
+	if err != nil {
+		return fmt.Errorf("invalid snippet line number: %q", line)
+	}
+	snippet := Snippet{Line: lineNum}
+	for _, hash := range strings.Split(value, ",") {
+		if len(hash) != hashLen {
+			return fmt.Errorf("invalid snippet hash: %q", hash)
+		}
+		h, parseErr := strconv.ParseUint(hash, 16, 32)
+		if parseErr != nil {
+			return fmt.Errorf("invalid snippet hash: %q", hash)
+		}
+		snippet.Hashes = append(snippet.Hashes, uint32(h))
+	}
+	f.Snippets = append(f.Snippets, snippet)
+	return nil
+}
+
+// addAttribute adds any other key=value entry to the file.
+func (f *File) addAttribute(key, value string) error {
+	for _, c := range key {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return fmt.Errorf("invalid entry key: %q", key)
+		}
+	}
+	f.Attributes = append(f.Attributes, Attribute{Key: key, Value: value})
+	return nil
+}
+
+// String serialises the file entry back into WFP format (without a trailing new line).
+func (f *File) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s%s,%d,%s", filePrefix, f.MD5, f.Size, f.Path))
+	if len(f.HPSM) > 0 {
+		sb.WriteString("\n" + hpsmKey + "=" + f.HPSM)
+	}
+	for _, attr := range f.Attributes {
+		sb.WriteString("\n" + attr.Key + "=" + attr.Value)
+	}
+	for _, snippet := range f.Snippets {
+		sb.WriteString(fmt.Sprintf("\n%d=", snippet.Line))
+		for i, hash := range snippet.Hashes {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(fmt.Sprintf("%08x", hash))
+		}
+	}
+	return sb.String()
+}
+
+// Parse reads all the file entries from the given WFP.
+func Parse(r io.Reader) ([]*File, error) {
+	reader := NewReader(r)
+	var files []*File
+	for {
+		file, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+}
+
+// Validate checks the syntax of the given WFP, returning the number of file entries it contains.
+func Validate(r io.Reader) (int, error) {
+	reader := NewReader(r)
+	count := 0
+	for {
+		_, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// Format serialises the given file entries into WFP format.
+func Format(files []*File) string {
+	var sb strings.Builder
+	for _, file := range files {
+		sb.WriteString(file.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// isHex reports if the string only contains hex digits.
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isDigits reports if the string only contains decimal digits.
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+/*
+ * Copyright (C) 2018-2025 SCANOSS.COM
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 2 of the License, or
+ * (at your option) any later version.
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package wfp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testWfp = `file=37f7cd1e657aa3c30ece35995b4c59e5,3114,src/a,b.py
+fh2=5f1bd4a5e9f8e4b2b1d0e3c6a7f8d9e0
+4=579a7cd6
+8=8a5e2b7c,0000ffff
+
+file=7c53a2de7dfeaa20d057db98468d6670,2321,b.py
+hpsm=00a1b2c3
+file=D41D8CD98F00B204E9800998ECF8427E,0,empty.txt
+`
+
+func TestParse(t *testing.T) {
+	files, err := Parse(strings.NewReader(testWfp))
+	if err != nil {
+		t.Fatalf("an error was not expected when parsing: %v", err)
+	}
+	if assert.Len(t, files, 3) {
+		assert.Equal(t, &File{
+			MD5:        "37f7cd1e657aa3c30ece35995b4c59e5",
+			Size:       3114,
+			Path:       "src/a,b.py",
+			Attributes: []Attribute{{Key: "fh2", Value: "5f1bd4a5e9f8e4b2b1d0e3c6a7f8d9e0"}},
+			Snippets:   []Snippet{{Line: 4, Hashes: []uint32{0x579a7cd6}}, {Line: 8, Hashes: []uint32{0x8a5e2b7c, 0xffff}}},
+			Line:       1,
+		}, files[0])
+		assert.Equal(t, "00a1b2c3", files[1].HPSM)
+		assert.Equal(t, 6, files[1].Line)
+		assert.Equal(t, int64(0), files[2].Size)
+		assert.Empty(t, files[2].Snippets)
+	}
+	// Serialising and re-parsing should give the same records
+	again, err := Parse(strings.NewReader(Format(files)))
+	assert.NoError(t, err)
+	for i := range again {
+		again[i].Line = files[i].Line
+	}
+	assert.Equal(t, files, again)
+	assert.Equal(t, "file=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\nhpsm=00a1b2c3", files[1].String())
+
+	files, err = Parse(strings.NewReader("\n\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestReader(t *testing.T) {
+	reader := NewReader(strings.NewReader(strings.ReplaceAll(testWfp, "\n", "\r\n")))
+	var paths, texts []string
+	for {
+		file, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if !assert.NoError(t, err) {
+			break
+		}
+		paths = append(paths, file.Path)
+		texts = append(texts, reader.Text())
+	}
+	assert.Equal(t, []string{"src/a,b.py", "b.py", "empty.txt"}, paths)
+	assert.Equal(t, []string{
+		"file=37f7cd1e657aa3c30ece35995b4c59e5,3114,src/a,b.py\nfh2=5f1bd4a5e9f8e4b2b1d0e3c6a7f8d9e0\n4=579a7cd6\n8=8a5e2b7c,0000ffff",
+		"file=7c53a2de7dfeaa20d057db98468d6670,2321,b.py\nhpsm=00a1b2c3",
+		"file=D41D8CD98F00B204E9800998ECF8427E,0,empty.txt",
+	}, texts)
+	_, err := reader.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestValidate(t *testing.T) {
+	for _, name := range []string{"fingers.wfp", "fingers-hpsm.wfp", "fingers-empty.wfp"} {
+		data, err := os.ReadFile("../service/tests/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Validate(strings.NewReader(string(data)))
+		assert.NoError(t, err, name)
+	}
+	count, err := Validate(strings.NewReader(testWfp))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	const file = "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,a.py\n"
+	tests := []struct {
+		name string
+		wfp  string
+		line int
+		msg  string
+	}{
+		{name: "no file entry", wfp: "4=579a7cd6\n" + file, line: 1, msg: "expected a file entry"},
+		{name: "junk", wfp: "invalid content", line: 1, msg: "expected a file entry"},
+		{name: "bad md5", wfp: file + "file=37f7cd1e657aa3c30ece35995b4c59eX,3114,a.py\n", line: 2, msg: "invalid file md5"},
+		{name: "bad size", wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5,-1,a.py", line: 1, msg: "invalid file size"},
+		{name: "missing path", wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5,3114,", line: 1, msg: "missing file path"},
+		{name: "missing fields", wfp: "file=37f7cd1e657aa3c30ece35995b4c59e5", line: 1, msg: "must have an md5, size and path"},
+		{name: "no value", wfp: file + "\n579a7cd6\n", line: 3, msg: "expected a key=value entry"},
+		{name: "short hash", wfp: file + "4=579a7cd\n", line: 2, msg: "invalid snippet hash"},
+		{name: "bad hash", wfp: file + "4=579a7cd6,579a7cdz\n", line: 2, msg: "invalid snippet hash"},
+		{name: "bad hpsm", wfp: file + "hpsm=abc\n", line: 2, msg: "invalid hpsm value"},
+		{name: "duplicate hpsm", wfp: file + "hpsm=abcd\nhpsm=abcd\n", line: 3, msg: "duplicate hpsm"},
+		{name: "bad key", wfp: file + "Fh2=1234\n", line: 2, msg: "invalid entry key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Validate(strings.NewReader(tt.wfp))
+			var syntaxErr *SyntaxError
+			if assert.ErrorAs(t, err, &syntaxErr) {
+				assert.Equal(t, tt.line, syntaxErr.Line)
+				assert.Contains(t, syntaxErr.Msg, tt.msg)
+				assert.Contains(t, err.Error(), "line ")
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+#!/bin/bash
+###
+# SPDX-License-Identifier: GPL-2.0-or-later
+#
+# Copyright (C) 2018-2025 SCANOSS.COM
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation, either version 2 of the License, or
+# (at your option) any later version.
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+# You should have received a copy of the GNU General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+###
+
+# Simulate a persistent engine worker using the stdin/stdout framing protocol:
+#   request:  {"op":"scan","args":[...],"length":N}\n<N bytes of WFP>
+#   response: {"status":"ok","length":N}\n<N bytes of scan results>
+export LC_ALL=C
+# Any other command is handled by the standard engine simulator
+if [ "$1" != "--stdio" ] ; then
+  exec "$(dirname "$0")/scanoss.sh" "$@"
+fi
+while IFS= read -r header; do
+  len=$(echo "$header" | sed -n 's/.*"length":\([0-9]*\).*/\1/p')
+  wfp=$(head -c "$len")
+  # Simulate an engine failure for any WFP containing 'engine-error'
+  if [[ "$wfp" == *"engine-error"* ]]; then
+    printf '{"status":"error","length":0,"error":"simulated engine failure"}\n'
+    continue
+  fi
+  # Simulate a worker crashing for any WFP containing 'worker-crash'
+  if [[ "$wfp" == *"worker-crash"* ]]; then
+    exit 1
+  fi
+  result=""
+  while IFS= read -r line; do
+    if [[ "$line" == file=* ]]; then
+      path=$(echo "$line" | cut -d, -f3-)
+      [ -n "$result" ] && result="$result,"
+      result="$result\"$path\":[{\"id\": \"none\", \"worker\": \"$$\"}]"
+    fi
+  done <<< "$wfp"
+  result="{$result}"
+  printf '{"status":"ok","length":%d}\n%s' "${#result}" "$result"
+done
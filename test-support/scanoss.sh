@@ -23,6 +23,12 @@ if [ "$1" == "-h" ] || [ "$2" == "-h" ] || [ "$1" == "-help" ] || [ "$2" == "-he
   exit 0
 fi
 
+# Simulate getting the engine version
+if [ "$1" == "-v" ] || [ "$2" == "-v" ] ; then
+  echo "scanoss-5.4.20"
+  exit 0
+fi
+
 # Simulate getting file contents
 if [ "$1" == "-k" ] || [ "$2" == "-k" ] || [ "$3" == "-k" ] ; then
   for i in "$@"; do :; done